@@ -0,0 +1,26 @@
+package bytengine
+
+// Aggregate functions of a BQL group statement
+const (
+	AggSum = "sum"
+	AggAvg = "avg"
+	AggMin = "min"
+	AggMax = "max"
+)
+
+// GroupField is a field the files of a select query are grouped by. Name
+// is the field as written in the query (e.g. 'country' or 'file_mime') and
+// labels its value in the result rows.
+type GroupField struct {
+	Field string `json:"field"`
+	Name  string `json:"name"`
+}
+
+// Aggregate is a function computed over a field of the files of each
+// group. Sum and avg only take numbers into account, min and max ignore
+// missing values.
+type Aggregate struct {
+	Func  string `json:"func"`
+	Field string `json:"field"`
+	Name  string `json:"name"` // label of the result e.g. 'sum(age)'
+}
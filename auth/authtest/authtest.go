@@ -0,0 +1,157 @@
+// Package authtest provides a conformance test suite for
+// bytengine.Authentication plugins.
+//
+// Plugin packages run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		authtest.Run(t, func(t *testing.T) bytengine.Authentication {
+//			...
+//		})
+//	}
+//
+// Every sub test removes all existing users with ClearAll.
+package authtest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a started authentication plugin
+type Factory func(t *testing.T) bytengine.Authentication
+
+// Run runs all conformance tests against the plugin created by newAuth
+func Run(t *testing.T, newAuth Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, a bytengine.Authentication)
+	}{
+		{"NewUser", testNewUser},
+		{"Password", testPassword},
+		{"Status", testStatus},
+		{"DbAccess", testDbAccess},
+		{"ListUser", testListUser},
+		{"RemoveUser", testRemoveUser},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newAuth(t)
+			require.Nil(t, a.ClearAll(), "clear all failed")
+			tc.fn(t, a)
+		})
+	}
+}
+
+func listUser(t *testing.T, a bytengine.Authentication, rgx string) []string {
+	l, err := a.ListUser(rgx)
+	require.Nil(t, err, "user list error")
+	sort.Strings(l)
+	return l
+}
+
+func testNewUser(t *testing.T, a bytengine.Authentication) {
+	assert.Nil(t, a.NewUser("john", "password", false), "user not created")
+	assert.Nil(t, a.NewUser("jane", "password", true), "user not created")
+	assert.NotNil(t, a.NewUser("john", "password", false), "duplicate user created")
+
+	// invalid users
+	assert.NotNil(t, a.NewUser("guest", "password", false), "reserved username accepted")
+	assert.NotNil(t, a.NewUser("1john", "password", false), "invalid username accepted")
+	assert.NotNil(t, a.NewUser("jack", "short", false), "short password accepted")
+	assert.NotNil(t, a.NewUser("jack", "pass word", false), "password with whitespace accepted")
+
+	usr, err := a.UserInfo("john")
+	assert.Nil(t, err, "user info failed")
+	assert.Equal(t, "john", usr.Username, "wrong username")
+	assert.True(t, usr.Active, "new users should be active")
+	assert.False(t, usr.Root, "wrong root status")
+	assert.Len(t, usr.Databases, 0, "new users shouldn't have database access")
+
+	usr, err = a.UserInfo("jane")
+	assert.Nil(t, err, "user info failed")
+	assert.True(t, usr.Root, "wrong root status")
+
+	_, err = a.UserInfo("jack")
+	assert.NotNil(t, err, "info for unknown user")
+	assert.Equal(t, []string{"jane", "john"}, listUser(t, a, ""), "wrong user list")
+}
+
+func testPassword(t *testing.T, a bytengine.Authentication) {
+	assert.Nil(t, a.NewUser("john", "password", false), "user not created")
+	assert.False(t, a.Authenticate("john", "wrongpassword"), "authentication should have failed")
+	assert.False(t, a.Authenticate("jane", "password"), "unknown user authenticated")
+	assert.True(t, a.Authenticate("john", "password"), "authentication failed")
+
+	assert.Nil(t, a.ChangeUserPassword("john", "password2"), "password update failed")
+	assert.False(t, a.Authenticate("john", "password"), "authentication should have failed")
+	assert.True(t, a.Authenticate("john", "password2"), "authentication failed")
+	assert.NotNil(t, a.ChangeUserPassword("john", "short"), "short password accepted")
+	assert.True(t, a.Authenticate("john", "password2"), "authentication failed")
+}
+
+func testStatus(t *testing.T, a bytengine.Authentication) {
+	assert.Nil(t, a.NewUser("john", "password", false), "user not created")
+
+	assert.Nil(t, a.ChangeUserStatus("john", false), "user status update failed")
+	assert.False(t, a.Authenticate("john", "password"), "inactive user authenticated")
+	usr, err := a.UserInfo("john")
+	assert.Nil(t, err, "user info failed")
+	assert.False(t, usr.Active, "user status not updated")
+
+	assert.Nil(t, a.ChangeUserStatus("john", true), "user status update failed")
+	assert.True(t, a.Authenticate("john", "password"), "authentication failed")
+	assert.NotNil(t, a.ChangeUserStatus("jane", false), "unknown user updated")
+}
+
+func testDbAccess(t *testing.T, a bytengine.Authentication) {
+	assert.Nil(t, a.NewUser("john", "password", false), "user not created")
+
+	assert.Nil(t, a.ChangeUserDbAccess("john", "db1", true), "database access failed")
+	assert.Nil(t, a.ChangeUserDbAccess("john", "db1", true), "database access failed")
+	assert.Nil(t, a.ChangeUserDbAccess("john", "db2", true), "database access failed")
+	assert.False(t, a.HasDbAccess("john", "db"), "database access failed")
+	assert.True(t, a.HasDbAccess("john", "db1"), "database access failed")
+	assert.False(t, a.HasDbAccess("jane", "db1"), "unknown user has database access")
+
+	usr, err := a.UserInfo("john")
+	assert.Nil(t, err, "user info failed")
+	assert.ElementsMatch(t, []string{"db1", "db2"}, usr.Databases, "database granted twice")
+
+	assert.Nil(t, a.ChangeUserDbAccess("john", "db1", false), "database access failed")
+	assert.False(t, a.HasDbAccess("john", "db1"), "database access not revoked")
+	assert.True(t, a.HasDbAccess("john", "db2"), "wrong database access revoked")
+	assert.NotNil(t, a.ChangeUserDbAccess("jane", "db1", true), "unknown user updated")
+}
+
+func testListUser(t *testing.T, a bytengine.Authentication) {
+	for _, usr := range []string{"john", "jane", "michelle"} {
+		assert.Nil(t, a.NewUser(usr, "password", false), "user not created")
+	}
+
+	assert.Equal(t, []string{"jane", "john", "michelle"}, listUser(t, a, ""), "wrong user list")
+	assert.Equal(t, []string{"jane", "john"}, listUser(t, a, "^J"), "list should be case insensitive")
+	assert.Equal(t, []string{"michelle"}, listUser(t, a, "elle$"), "wrong filtered user list")
+	assert.Len(t, listUser(t, a, "^x"), 0, "wrong filtered user list")
+	_, err := a.ListUser("[")
+	assert.NotNil(t, err, "invalid regex accepted")
+}
+
+func testRemoveUser(t *testing.T, a bytengine.Authentication) {
+	assert.Nil(t, a.NewUser("john", "password", false), "user not created")
+	assert.Nil(t, a.NewUser("jane", "password", false), "user not created")
+
+	assert.Nil(t, a.RemoveUser("john"), "delete user failed")
+	assert.NotNil(t, a.RemoveUser("john"), "unknown user removed")
+	assert.False(t, a.Authenticate("john", "password"), "removed user authenticated")
+	_, err := a.UserInfo("john")
+	assert.NotNil(t, err, "info for removed user")
+	assert.Equal(t, []string{"jane"}, listUser(t, a, ""), "wrong user list")
+
+	// username can be reused
+	assert.Nil(t, a.NewUser("john", "password2", false), "user not created")
+	assert.True(t, a.Authenticate("john", "password2"), "authentication failed")
+}
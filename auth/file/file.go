@@ -0,0 +1,406 @@
+// Package file provides an authentication plugin that stores user accounts
+// in a local JSON file. The file is locked while it is read or rewritten
+// and changes are written to a temporary file which then atomically
+// replaces the previous version.
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/auth"
+)
+
+type Config struct {
+	Path string `json:"path"`
+}
+
+func NewAuthentication() *Authentication {
+	return &Authentication{}
+}
+
+type Authentication struct {
+	mu   sync.RWMutex
+	path string
+}
+
+type authToken struct {
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	Active    bool     `json:"active"`
+	Databases []string `json:"databases"`
+	Root      bool     `json:"root"`
+}
+
+var errNotFound = errors.New("not found")
+
+/*
+============================================================================
+    Private Methods
+============================================================================
+*/
+
+func (m *Authentication) load() (map[string]*authToken, error) {
+	users := map[string]*authToken{}
+	b, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return users, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return users, nil
+	}
+
+	var list []*authToken
+	err = json.Unmarshal(b, &list)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range list {
+		users[item.Username] = item
+	}
+	return users, nil
+}
+
+// save writes all users to a temporary file and renames it over the
+// current user file
+func (m *Authentication) save(users map[string]*authToken) error {
+	list := make([]*authToken, 0, len(users))
+	for _, item := range users {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Username < list[j].Username
+	})
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(m.path), filepath.Base(m.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0600)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// view loads the users while holding a shared lock
+func (m *Authentication) view(fn func(users map[string]*authToken) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	unlock, err := lockFile(m.path+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	users, err := m.load()
+	if err != nil {
+		return err
+	}
+	return fn(users)
+}
+
+// update loads the users while holding an exclusive lock and saves them if
+// fn succeeds
+func (m *Authentication) update(fn func(users map[string]*authToken) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	unlock, err := lockFile(m.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	users, err := m.load()
+	if err != nil {
+		return err
+	}
+	err = fn(users)
+	if err != nil {
+		return err
+	}
+	return m.save(users)
+}
+
+// updateUser applies fn to an existing user
+func (m *Authentication) updateUser(usr string, fn func(u *authToken)) error {
+	return m.update(func(users map[string]*authToken) error {
+		u, ok := users[usr]
+		if !ok {
+			return errNotFound
+		}
+		fn(u)
+		return nil
+	})
+}
+
+/*
+============================================================================
+    Auth Interface Methods
+============================================================================
+*/
+
+func (m *Authentication) Start(config string) error {
+	var c Config
+	err := json.Unmarshal([]byte(config), &c)
+	if err != nil {
+		return err
+	}
+	if len(c.Path) == 0 {
+		return errors.New("file: user file path required")
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.Path), 0755)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.path = c.Path
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Authentication) ClearAll() error {
+	return m.update(func(users map[string]*authToken) error {
+		for usr := range users {
+			delete(users, usr)
+		}
+		return nil
+	})
+}
+
+func (m *Authentication) Authenticate(usr, pw string) bool {
+	var pwh string
+	err := m.view(func(users map[string]*authToken) error {
+		u, ok := users[usr]
+		if !ok || !u.Active {
+			return errNotFound
+		}
+		pwh = u.Password
+		return nil
+	})
+	if err != nil {
+		return false
+	}
+
+	return auth.ValidatePassword([]byte(pwh), []byte(pw))
+}
+
+func (m *Authentication) NewUser(usr, pw string, root bool) error {
+	// usernames are lowercase
+	usr = strings.ToLower(usr)
+
+	// check username and password
+	err := auth.CheckUsername(usr)
+	if err != nil {
+		return err
+	}
+	err = auth.CheckPassword(pw)
+	if err != nil {
+		return err
+	}
+
+	encrypt_pw, err := auth.PasswordEncrypt(pw)
+	if err != nil {
+		msg := fmt.Sprintf("user %s couldn't be created:\n%s", usr, err)
+		return errors.New(msg)
+	}
+
+	exists := false
+	err = m.update(func(users map[string]*authToken) error {
+		if _, ok := users[usr]; ok {
+			exists = true
+			return errors.New("user exists")
+		}
+		users[usr] = &authToken{
+			usr,
+			string(encrypt_pw),
+			true,
+			[]string{},
+			root,
+		}
+		return nil
+	})
+	if exists {
+		msg := fmt.Sprintf("user %s already exists", usr)
+		return errors.New(msg)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("user %s couldn't be created:\n%s", usr, err)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func (m *Authentication) ChangeUserPassword(usr, pw string) error {
+	// validate password
+	err := auth.CheckPassword(pw)
+	if err != nil {
+		return err
+	}
+
+	encrypt_pw, err := auth.PasswordEncrypt(pw)
+	if err != nil {
+		msg := fmt.Sprintf("user %s couldn't be created:\n%s", usr, err)
+		return errors.New(msg)
+	}
+
+	err = m.updateUser(usr, func(u *authToken) {
+		u.Password = string(encrypt_pw)
+	})
+	if err != nil {
+		msg := fmt.Sprintf("user %s password couldn't be created:\n%s", usr, err)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func (m *Authentication) ChangeUserStatus(usr string, isactive bool) error {
+	err := m.updateUser(usr, func(u *authToken) {
+		u.Active = isactive
+	})
+	if err != nil {
+		msg := fmt.Sprintf("user %s status couldn't be updated:\n%s", usr, err)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func (m *Authentication) ListUser(rgx string) ([]string, error) {
+	// case insensitive regex
+	r, err := regexp.Compile("(?i)" + rgx)
+	if err != nil {
+		msg := fmt.Sprintf("user list couldn't be retrieved:\n%s", err)
+		return nil, errors.New(msg)
+	}
+
+	res := []string{}
+	err = m.view(func(users map[string]*authToken) error {
+		for usr := range users {
+			if r.MatchString(usr) {
+				res = append(res, usr)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		msg := fmt.Sprintf("user list couldn't be retrieved:\n%s", err)
+		return nil, errors.New(msg)
+	}
+
+	sort.Strings(res)
+	return res, nil
+}
+
+func (m *Authentication) ChangeUserDbAccess(usr, db string, grant bool) error {
+	err := m.updateUser(usr, func(u *authToken) {
+		list := []string{}
+		for _, item := range u.Databases {
+			if item != db {
+				list = append(list, item)
+			}
+		}
+		if grant {
+			list = append(list, db)
+		}
+		u.Databases = list
+	})
+	if err != nil {
+		msg := fmt.Sprintf("user %s database access couldn't be updated:\n%s", usr, err)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func (m *Authentication) HasDbAccess(usr, db string) bool {
+	found := false
+	m.view(func(users map[string]*authToken) error {
+		u, ok := users[usr]
+		if !ok {
+			return nil
+		}
+		for _, item := range u.Databases {
+			if item == db {
+				found = true
+				break
+			}
+		}
+		return nil
+	})
+
+	return found
+}
+
+func (m *Authentication) RemoveUser(usr string) error {
+	err := m.update(func(users map[string]*authToken) error {
+		if _, ok := users[usr]; !ok {
+			return errNotFound
+		}
+		delete(users, usr)
+		return nil
+	})
+	if err != nil {
+		msg := fmt.Sprintf("couldn't remove user %s:\n%s", usr, err)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func (m *Authentication) UserInfo(u string) (*bytengine.User, error) {
+	var usr *bytengine.User
+	err := m.view(func(users map[string]*authToken) error {
+		item, ok := users[u]
+		if !ok {
+			return errNotFound
+		}
+		usr = &bytengine.User{
+			Username:  item.Username,
+			Active:    item.Active,
+			Databases: append([]string{}, item.Databases...),
+			Root:      item.Root,
+		}
+		return nil
+	})
+	if err != nil {
+		msg := fmt.Sprintf("couldn't get info for user %s:\n%s", u, err)
+		return nil, errors.New(msg)
+	}
+
+	return usr, nil
+}
+
+func init() {
+	bytengine.RegisterAuthentication("file", NewAuthentication())
+}
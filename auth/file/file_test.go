@@ -0,0 +1,51 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/auth/authtest"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	CONFIG = `
+    {
+        "path":"/tmp/bytengine_auth_test/users.json"
+    }`
+)
+
+func TestConformance(t *testing.T) {
+	authtest.Run(t, func(t *testing.T) bytengine.Authentication {
+		fauth, err := bytengine.NewAuthentication("file", CONFIG)
+		assert.Nil(t, err, "auth not created")
+		return fauth
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	config := `{"path":"/tmp/bytengine_auth_test/persist/users.json"}`
+	os.RemoveAll("/tmp/bytengine_auth_test/persist")
+
+	a1 := NewAuthentication()
+	err := a1.Start(config)
+	assert.Nil(t, err, "auth not started")
+	err = a1.NewUser("john", "password", true)
+	assert.Nil(t, err, "user not created")
+
+	// user is visible to other instances using the same file
+	a2 := NewAuthentication()
+	err = a2.Start(config)
+	assert.Nil(t, err, "auth not started")
+	ok := a2.Authenticate("john", "password")
+	assert.True(t, ok, "authentication failed")
+	usr, err := a2.UserInfo("john")
+	assert.Nil(t, err, "user info failed")
+	assert.True(t, usr.Root, "user info failed")
+
+	// only the user file and the lock file are left in the directory
+	files, err := os.ReadDir("/tmp/bytengine_auth_test/persist")
+	assert.Nil(t, err, "directory listing failed")
+	assert.Len(t, files, 2, "temporary files left behind")
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package file
+
+// lockFile is a no-op on platforms without flock. Access is only
+// serialized within the running process.
+func lockFile(name string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
@@ -2,6 +2,7 @@ package mongo
 
 import (
 	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/auth/authtest"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -66,3 +67,11 @@ func TestUserManagement(t *testing.T) {
 	l, err = mgauth.ListUser("")
 	assert.Len(t, l, 0, "user list error")
 }
+
+func TestConformance(t *testing.T) {
+	authtest.Run(t, func(t *testing.T) bytengine.Authentication {
+		mgauth, err := bytengine.NewAuthentication("mongodb", CONFIG)
+		assert.Nil(t, err, "auth not created")
+		return mgauth
+	})
+}
@@ -4,25 +4,37 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"time"
 )
 
-var (
-	// to be expanded
-	MimeList = map[string]string{
-		".js":  "text/javascript",
-		".css": "text/css",
-	}
+var bstPlugins = make(map[string]ByteStore)
 
-	bstPlugins = make(map[string]ByteStore)
-)
+// FileHint describes the content passed to ByteStore.Add and Update. Size
+// is the content length in bytes or -1 if unknown. Name is the original
+// file name and ContentType the type declared by the client, both are used
+// to refine the detected mime type. Mime overrides the detected type.
+type FileHint struct {
+	Name        string
+	Size        int64
+	ContentType string
+	Mime        string
+}
 
+// ByteStore plugins store attachments. Add and Update read the content from
+// r until EOF and return its 'size', 'mime' type and sha256 'hash' (Add also
+// returns the new item 'name', Update only if the content was stored under
+// another name). ReadRange writes length bytes of the content
+// starting at offset, or everything after offset if length is -1, and fails
+// if the range goes past the end of the content. List returns the ids of
+// all items stored for the database with the time they were last written.
 type ByteStore interface {
 	Start(config string) error
-	Add(db string, file *os.File) (map[string]interface{}, error)
-	Update(db, id string, file *os.File) (map[string]interface{}, error)
+	Add(db string, r io.Reader, hint FileHint) (map[string]interface{}, error)
+	Update(db, id string, r io.Reader, hint FileHint) (map[string]interface{}, error)
 	Delete(db, id string) error
 	Read(db, filename string, file io.Writer) error
+	ReadRange(db, filename string, offset, length int64, file io.Writer) error
+	List(db string) (map[string]time.Time, error)
 	DropDatabase(db string) error
 }
 
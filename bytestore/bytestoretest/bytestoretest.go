@@ -0,0 +1,285 @@
+// Package bytestoretest provides a conformance test suite for
+// bytengine.ByteStore plugins.
+//
+// Plugin packages run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		bytestoretest.Run(t, func(t *testing.T) bytengine.ByteStore {
+//			...
+//		})
+//	}
+//
+// The suite only uses the databases 'bsttest1' and 'bsttest2' which are
+// dropped before every sub test.
+package bytestoretest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a started byte store plugin
+type Factory func(t *testing.T) bytengine.ByteStore
+
+const (
+	db1 = "bsttest1"
+	db2 = "bsttest2"
+)
+
+// Run runs all conformance tests against the plugin created by newBST
+func Run(t *testing.T, newBST Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, bst bytengine.ByteStore)
+	}{
+		{"AddRead", testAddRead},
+		{"ReadRange", testReadRange},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"List", testList},
+		{"DropDatabase", testDropDatabase},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bst := newBST(t)
+			require.Nil(t, bst.DropDatabase(db1), "database not dropped")
+			require.Nil(t, bst.DropDatabase(db2), "database not dropped")
+			tc.fn(t, bst)
+		})
+	}
+}
+
+/*
+============================================================================
+    Helpers
+============================================================================
+*/
+
+func hint(data string) bytengine.FileHint {
+	return bytengine.FileHint{Name: "data.txt", Size: int64(len(data))}
+}
+
+func add(t *testing.T, bst bytengine.ByteStore, db, data string) map[string]interface{} {
+	info, err := bst.Add(db, strings.NewReader(data), hint(data))
+	require.Nil(t, err, "item not added")
+	return info
+}
+
+func checksum(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
+
+// failingReader returns an error after the content has been read
+type failingReader struct {
+	r io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func read(t *testing.T, bst bytengine.ByteStore, db, id string) string {
+	var buf bytes.Buffer
+	err := bst.Read(db, id, &buf)
+	require.Nil(t, err, "item '%s' couldn't be read", id)
+	return buf.String()
+}
+
+/*
+============================================================================
+    Tests
+============================================================================
+*/
+
+func testAddRead(t *testing.T, bst bytengine.ByteStore) {
+	info := add(t, bst, db1, "Hello from bst!")
+	id, ok := info["name"].(string)
+	require.True(t, ok, "missing item name")
+	assert.NotEmpty(t, id, "missing item name")
+	assert.EqualValues(t, 15, info["size"], "wrong item size")
+	assert.Contains(t, info["mime"], "text/plain", "wrong item mime type")
+	assert.Equal(t, checksum("Hello from bst!"), info["hash"], "wrong item checksum")
+	assert.Equal(t, "Hello from bst!", read(t, bst, db1, id), "item content differs")
+
+	// every item gets its own name, even with the same content
+	info2 := add(t, bst, db1, "Hello from bst!")
+	assert.NotEqual(t, id, info2["name"], "duplicate item name")
+
+	// larger content
+	data := string(bytes.Repeat([]byte("0123456789abcdef"), 64*1024))
+	info3 := add(t, bst, db1, data)
+	assert.EqualValues(t, len(data), info3["size"], "wrong item size")
+	assert.Equal(t, data, read(t, bst, db1, info3["name"].(string)), "large item content differs")
+
+	// content of unknown size
+	info4, err := bst.Add(db1, strings.NewReader(data), bytengine.FileHint{Size: -1})
+	require.Nil(t, err, "item of unknown size not added")
+	assert.EqualValues(t, len(data), info4["size"], "wrong item size")
+	assert.Equal(t, checksum(data), info4["hash"], "wrong item checksum")
+	assert.Equal(t, data, read(t, bst, db1, info4["name"].(string)), "large item content differs")
+
+	// mime type is refined with the file name
+	info5, err := bst.Add(db1, strings.NewReader("body {}"), bytengine.FileHint{Name: "style.css", Size: 7})
+	require.Nil(t, err, "item not added")
+	assert.Contains(t, info5["mime"], "text/css", "wrong item mime type")
+	info5, err = bst.Add(db1, strings.NewReader("<html><body></body></html>"), bytengine.FileHint{Size: -1})
+	require.Nil(t, err, "item not added")
+	assert.Contains(t, info5["mime"], "text/html", "wrong item mime type")
+	info5, err = bst.Add(db1, strings.NewReader(`{"a": 1}`), bytengine.FileHint{Size: -1, ContentType: "application/json"})
+	require.Nil(t, err, "item not added")
+	assert.Contains(t, info5["mime"], "application/json", "declared content type not used")
+	info5, err = bst.Add(db1, strings.NewReader("body {}"), bytengine.FileHint{Name: "style.css", Size: -1, Mime: "text/x-custom"})
+	require.Nil(t, err, "item not added")
+	assert.Equal(t, "text/x-custom", info5["mime"], "mime type not overridden")
+
+	// items are stored per database
+	err = bst.Read(db2, id, ioutil.Discard)
+	assert.NotNil(t, err, "item read from other database")
+	err = bst.Read(db1, "missing", ioutil.Discard)
+	assert.NotNil(t, err, "missing item read")
+}
+
+func readRange(t *testing.T, bst bytengine.ByteStore, id string, offset, length int64) string {
+	var buf bytes.Buffer
+	err := bst.ReadRange(db1, id, offset, length, &buf)
+	require.Nil(t, err, "range %d/%d of item '%s' couldn't be read", offset, length, id)
+	return buf.String()
+}
+
+func testReadRange(t *testing.T, bst bytengine.ByteStore) {
+	id := add(t, bst, db1, "0123456789")["name"].(string)
+	assert.Equal(t, "0123456789", readRange(t, bst, id, 0, -1), "wrong full range")
+	assert.Equal(t, "012", readRange(t, bst, id, 0, 3), "wrong range")
+	assert.Equal(t, "56789", readRange(t, bst, id, 5, -1), "wrong open range")
+	assert.Equal(t, "789", readRange(t, bst, id, 7, 3), "wrong range")
+	assert.Equal(t, "9", readRange(t, bst, id, 9, 1), "wrong last byte")
+	assert.Equal(t, "", readRange(t, bst, id, 4, 0), "wrong empty range")
+
+	err := bst.ReadRange(db1, id, 8, 5, ioutil.Discard)
+	assert.NotNil(t, err, "range past the end read")
+	err = bst.ReadRange(db1, id, -1, 5, ioutil.Discard)
+	assert.NotNil(t, err, "negative offset read")
+	err = bst.ReadRange(db1, "missing", 0, 1, ioutil.Discard)
+	assert.NotNil(t, err, "range of missing item read")
+	err = bst.ReadRange(db2, id, 0, 1, ioutil.Discard)
+	assert.NotNil(t, err, "range read from other database")
+
+	// ranges spanning storage chunks
+	data := string(bytes.Repeat([]byte("0123456789abcdef"), 64*1024))
+	id = add(t, bst, db1, data)["name"].(string)
+	assert.Equal(t, data[300000:700000], readRange(t, bst, id, 300000, 400000), "wrong large range")
+	assert.Equal(t, data[1000000:], readRange(t, bst, id, 1000000, -1), "wrong large open range")
+}
+
+func testUpdate(t *testing.T, bst bytengine.ByteStore) {
+	id := add(t, bst, db1, "Hello from bst!")["name"].(string)
+	other := add(t, bst, db1, "Other item")["name"].(string)
+
+	data := "<html><body>Updated content</body></html>"
+	info, err := bst.Update(db1, id, strings.NewReader(data), hint(data))
+	require.Nil(t, err, "item not updated")
+	assert.EqualValues(t, len(data), info["size"], "wrong item size")
+	assert.Contains(t, info["mime"], "text/html", "wrong item mime type")
+	assert.Equal(t, checksum(data), info["hash"], "wrong item checksum")
+
+	assert.Equal(t, data, read(t, bst, db1, id), "item not updated")
+	assert.Equal(t, "Other item", read(t, bst, db1, other), "other item changed")
+
+	// failed uploads keep the previous content
+	_, err = bst.Update(db1, id, failingReader{strings.NewReader("partial")}, bytengine.FileHint{Size: -1})
+	assert.NotNil(t, err, "failed upload accepted")
+	_, err = bst.Update(db1, id, strings.NewReader("short"), bytengine.FileHint{Size: 10})
+	assert.NotNil(t, err, "truncated upload accepted")
+	_, err = bst.Update(db1, id, strings.NewReader("too long"), bytengine.FileHint{Size: 3})
+	assert.NotNil(t, err, "oversized upload accepted")
+	assert.Equal(t, data, read(t, bst, db1, id), "content changed by failed upload")
+}
+
+func testDelete(t *testing.T, bst bytengine.ByteStore) {
+	id := add(t, bst, db1, "Hello from bst!")["name"].(string)
+	other := add(t, bst, db1, "Hello from bst!")["name"].(string)
+
+	assert.Nil(t, bst.Delete(db1, id), "item not deleted")
+	err := bst.Read(db1, id, ioutil.Discard)
+	assert.NotNil(t, err, "deleted item still readable")
+	assert.Equal(t, "Hello from bst!", read(t, bst, db1, other), "other item deleted")
+}
+
+// listed returns the ids of a ByteStore.List result
+func listed(list map[string]time.Time) []string {
+	ids := []string{}
+	for id := range list {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func testList(t *testing.T, bst bytengine.ByteStore) {
+	list, err := bst.List(db1)
+	assert.Nil(t, err, "empty database not listed")
+	assert.Len(t, list, 0, "wrong item list")
+
+	// some stores only keep modification times to the second
+	start := time.Now().Truncate(time.Second)
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		ids = append(ids, add(t, bst, db1, "Hello from bst!")["name"].(string))
+	}
+	other := add(t, bst, db2, "Hello from bst!")["name"].(string)
+
+	// updated items are listed once
+	_, err = bst.Update(db1, ids[0], strings.NewReader("updated"), hint("updated"))
+	require.Nil(t, err, "item not updated")
+
+	list, err = bst.List(db1)
+	assert.Nil(t, err, "items not listed")
+	assert.ElementsMatch(t, ids, listed(list), "wrong item list")
+	assert.NotContains(t, list, other, "item of other database listed")
+	for id, modified := range list {
+		assert.False(t, modified.Before(start), "wrong modification time of %s", id)
+		assert.False(t, modified.After(time.Now()), "wrong modification time of %s", id)
+	}
+
+	assert.Nil(t, bst.Delete(db1, ids[1]), "item not deleted")
+	list, err = bst.List(db1)
+	assert.Nil(t, err, "items not listed")
+	assert.ElementsMatch(t, []string{ids[0], ids[2]}, listed(list), "deleted item listed")
+}
+
+func testDropDatabase(t *testing.T, bst bytengine.ByteStore) {
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		ids = append(ids, add(t, bst, db1, "Hello from bst!")["name"].(string))
+	}
+	kept := add(t, bst, db2, "Hello from bst!")["name"].(string)
+
+	assert.Nil(t, bst.DropDatabase(db1), "database not dropped")
+	for _, id := range ids {
+		err := bst.Read(db1, id, ioutil.Discard)
+		assert.NotNil(t, err, "item not removed with database")
+	}
+	assert.Equal(t, "Hello from bst!", read(t, bst, db2, kept), "item of other database removed")
+
+	// dropping a missing database isn't an error
+	assert.Nil(t, bst.DropDatabase(db1), "missing database not dropped")
+
+	// database is usable after drop
+	id := add(t, bst, db1, "new content")["name"].(string)
+	assert.Equal(t, "new content", read(t, bst, db1, id), "database not usable after drop")
+}
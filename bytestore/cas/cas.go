@@ -0,0 +1,407 @@
+// Package cas provides a content addressed byte store plugin. Blobs are
+// stored once per database under their SHA-256 digest and every Add returns
+// a new handle which references a blob. Blobs are deleted from disk once no
+// handle makes reference to them anymore.
+package cas
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/bytestore"
+	"github.com/nu7hatch/gouuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+type Config struct {
+	RootDir string        `json:"rootdir"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+var (
+	handlesBucket  = []byte("handles")  // handle -> digest
+	refsBucket     = []byte("refs")     // digest -> number of handles
+	modifiedBucket = []byte("modified") // handle -> time of the last write
+)
+
+type ByteStore struct {
+	mu      sync.Mutex
+	RootDir string
+	DB      *bolt.DB
+}
+
+func NewByteStore() *ByteStore {
+	return &ByteStore{}
+}
+
+func newHandle() (string, error) {
+	tmp, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(tmp.String(), "-", "", -1), nil
+}
+
+func (m *ByteStore) blobPath(db, digest string) string {
+	return filepath.Join(m.RootDir, "blobs", db, digest[:2], digest)
+}
+
+// spool copies the content to a temporary location. Its SHA-256 digest is
+// computed by the reader on the way.
+func (m *ByteStore) spool(in *bytestore.Reader) (tmpname string, err error) {
+	tmp, err := ioutil.TempFile(filepath.Join(m.RootDir, "tmp"), "upload")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	_, err = io.Copy(tmp, in)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// place moves the uploaded content in place unless the blob already
+// exists. Blobs are moved in before their reference count is incremented so
+// that a committed count always makes reference to a blob on disk. It must
+// be called with the lock held.
+func (m *ByteStore) place(db, digest, tmpname string) error {
+	bpath := m.blobPath(db, digest)
+	_, err := os.Stat(bpath)
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(bpath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpname, bpath)
+}
+
+// unlink deletes the blob from disk if its committed reference count is
+// zero. It must be called with the lock held once the transaction which
+// released the blob has committed.
+func (m *ByteStore) unlink(db, digest string) {
+	var count int64
+	err := m.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db))
+		if b == nil {
+			return nil
+		}
+		var err error
+		count, err = getCount(b.Bucket(refsBucket), digest)
+		return err
+	})
+	if err != nil || count > 0 {
+		return
+	}
+	// a blob left behind is reused by the next upload of the same content
+	os.Remove(m.blobPath(db, digest))
+}
+
+// reference increments the reference count of the blob
+func reference(tx *bolt.Tx, db, digest string) error {
+	refs := tx.Bucket([]byte(db)).Bucket(refsBucket)
+	count, err := getCount(refs, digest)
+	if err != nil {
+		return err
+	}
+	return refs.Put([]byte(digest), []byte(strconv.FormatInt(count+1, 10)))
+}
+
+// release decrements the reference count of the blob. The blob itself is
+// deleted by unlink after the transaction has committed.
+func release(tx *bolt.Tx, db, digest string) error {
+	refs := tx.Bucket([]byte(db)).Bucket(refsBucket)
+	count, err := getCount(refs, digest)
+	if err != nil {
+		return err
+	}
+	if count > 1 {
+		return refs.Put([]byte(digest), []byte(strconv.FormatInt(count-1, 10)))
+	}
+	return refs.Delete([]byte(digest))
+}
+
+func getCount(refs *bolt.Bucket, digest string) (int64, error) {
+	val := refs.Get([]byte(digest))
+	if val == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(val), 10, 64)
+}
+
+func createBuckets(tx *bolt.Tx, db string) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(db))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range [][]byte{handlesBucket, refsBucket, modifiedBucket} {
+		_, err = b.CreateBucketIfNotExists(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// save stores the file content under the given handle and releases the blob
+// previously referenced by the handle
+func (m *ByteStore) save(db, handle string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	in := bytestore.NewReader(r, hint)
+	tmpname, err := m.spool(in)
+	if err != nil {
+		return nil, err
+	}
+	info := in.Info()
+	digest := in.Hash()
+	// no-op once the content has been moved in place
+	defer os.Remove(tmpname)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err = m.place(db, digest, tmpname)
+	if err != nil {
+		return nil, err
+	}
+	var previous string
+	err = m.DB.Update(func(tx *bolt.Tx) error {
+		b, err := createBuckets(tx, db)
+		if err != nil {
+			return err
+		}
+		modified := strconv.FormatInt(time.Now().UnixNano(), 10)
+		err = b.Bucket(modifiedBucket).Put([]byte(handle), []byte(modified))
+		if err != nil {
+			return err
+		}
+		handles := b.Bucket(handlesBucket)
+		previous = string(handles.Get([]byte(handle)))
+		if previous == digest {
+			previous = ""
+			return nil
+		}
+		err = reference(tx, db, digest)
+		if err != nil {
+			return err
+		}
+		err = handles.Put([]byte(handle), []byte(digest))
+		if err != nil {
+			return err
+		}
+		if previous != "" {
+			return release(tx, db, previous)
+		}
+		return nil
+	})
+	if err != nil {
+		// the blob isn't kept if no handle makes reference to it
+		m.unlink(db, digest)
+		return nil, err
+	}
+	if previous != "" {
+		m.unlink(db, previous)
+	}
+
+	info["name"] = handle
+	return info, nil
+}
+
+func (m *ByteStore) digest(db, handle string) (string, error) {
+	var digest string
+	err := m.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db))
+		if b != nil {
+			digest = string(b.Bucket(handlesBucket).Get([]byte(handle)))
+		}
+		if digest == "" {
+			return fmt.Errorf("item '%s' not found", handle)
+		}
+		return nil
+	})
+	return digest, err
+}
+
+func (m *ByteStore) Start(config string) error {
+	var c Config
+	err := json.Unmarshal([]byte(config), &c)
+	if err != nil {
+		return err
+	}
+	if len(c.RootDir) == 0 {
+		return errors.New("cas: root directory required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the plugin is started by every worker so the open index is reused
+	if m.DB != nil {
+		if m.RootDir == c.RootDir {
+			return nil
+		}
+		m.DB.Close()
+		m.DB = nil
+	}
+
+	err = os.MkdirAll(filepath.Join(c.RootDir, "tmp"), 0755)
+	if err != nil {
+		return err
+	}
+	opts := &bolt.Options{Timeout: c.Timeout * time.Second}
+	db, err := bolt.Open(filepath.Join(c.RootDir, "index.db"), 0600, opts)
+	if err != nil {
+		return err
+	}
+	m.DB = db
+	m.RootDir = c.RootDir
+	return nil
+}
+
+// Close closes the blob index
+func (m *ByteStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.DB == nil {
+		return nil
+	}
+	err := m.DB.Close()
+	m.DB = nil
+	return err
+}
+
+func (m *ByteStore) Add(db string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	handle, err := newHandle()
+	if err != nil {
+		return nil, fmt.Errorf("Item could not be added: %s", err)
+	}
+	return m.save(db, handle, r, hint)
+}
+
+func (m *ByteStore) Update(db, filename string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	return m.save(db, filename, r, hint)
+}
+
+func (m *ByteStore) Delete(db, filename string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var digest string
+	err := m.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db))
+		if b == nil {
+			return fmt.Errorf("item '%s' not found", filename)
+		}
+		handles := b.Bucket(handlesBucket)
+		digest = string(handles.Get([]byte(filename)))
+		if digest == "" {
+			return fmt.Errorf("item '%s' not found", filename)
+		}
+		err := handles.Delete([]byte(filename))
+		if err != nil {
+			return err
+		}
+		if modified := b.Bucket(modifiedBucket); modified != nil {
+			err = modified.Delete([]byte(filename))
+			if err != nil {
+				return err
+			}
+		}
+		return release(tx, db, digest)
+	})
+	if err != nil {
+		return err
+	}
+	m.unlink(db, digest)
+	return nil
+}
+
+func (m *ByteStore) Read(db, filename string, file io.Writer) error {
+	digest, err := m.digest(db, filename)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(m.blobPath(db, digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(file, f)
+	return err
+}
+
+func (m *ByteStore) ReadRange(db, filename string, offset, length int64, file io.Writer) error {
+	digest, err := m.digest(db, filename)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(m.blobPath(db, digest))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return bytestore.CopyRange(file, f, offset, length)
+}
+
+// List returns the handles of the database, not the blob digests
+func (m *ByteStore) List(db string) (map[string]time.Time, error) {
+	list := make(map[string]time.Time)
+	err := m.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db))
+		if b == nil {
+			return nil
+		}
+		// handles written before modification times were recorded have
+		// none
+		modified := b.Bucket(modifiedBucket)
+		return b.Bucket(handlesBucket).ForEach(func(k, v []byte) error {
+			var t time.Time
+			if modified != nil {
+				if val := modified.Get(k); val != nil {
+					nsec, err := strconv.ParseInt(string(val), 10, 64)
+					if err != nil {
+						return err
+					}
+					t = time.Unix(0, nsec)
+				}
+			}
+			list[string(k)] = t
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (m *ByteStore) DropDatabase(db string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.DB.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(db))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(m.RootDir, "blobs", db))
+}
+
+func init() {
+	bytengine.RegisterByteStore("cas", NewByteStore())
+}
@@ -0,0 +1,199 @@
+package cas
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/bytestore/bytestoretest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func addText(t *testing.T, b bytengine.ByteStore, db, txt string) map[string]interface{} {
+	fpath := "/tmp/bytengine_cas_test.txt"
+	err := ioutil.WriteFile(fpath, []byte(txt), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := b.Add(db, f, bytengine.FileHint{Name: fpath, Size: int64(len(txt))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func readText(t *testing.T, b bytengine.ByteStore, db, name string) string {
+	var buf bytes.Buffer
+	err := b.Read(db, name, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func digest(txt string) string {
+	h := sha256.Sum256([]byte(txt))
+	return hex.EncodeToString(h[:])
+}
+
+func TestCasBST(t *testing.T) {
+	os.RemoveAll("/tmp/cas_data")
+	b, err := bytengine.NewByteStore("cas", `{"rootdir":"/tmp/cas_data"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := b.(*ByteStore)
+
+	db := "bst_test"
+	txt := "Hello from bst!"
+
+	// identical content is stored once
+	info1 := addText(t, b, db, txt)
+	info2 := addText(t, b, db, txt)
+	if info1["name"] == info2["name"] {
+		t.Fatal("Handles should be unique")
+	}
+	if info1["hash"] != digest(txt) || info2["hash"] != digest(txt) {
+		t.Fatal("Wrong content digest")
+	}
+	if info1["size"].(int64) != int64(len(txt)) {
+		t.Fatal("Wrong content size")
+	}
+	blob := store.blobPath(db, digest(txt))
+	if _, err = os.Stat(blob); err != nil {
+		t.Fatal("Blob not stored")
+	}
+
+	// blob is kept while referenced
+	err = b.Delete(db, info1["name"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readText(t, b, db, info2["name"].(string)) != txt {
+		t.Fatal("Content in files is different")
+	}
+	err = b.Read(db, info1["name"].(string), ioutil.Discard)
+	if err == nil {
+		t.Fatal("Deleted handle still readable")
+	}
+
+	// update references new content and releases the old blob
+	fpath := "/tmp/bytengine_cas_update.txt"
+	txt2 := "Updated content"
+	err = ioutil.WriteFile(fpath, []byte(txt2), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info3, err := b.Update(db, info2["name"].(string), f, bytengine.FileHint{Name: fpath, Size: int64(len(txt2))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info3["name"] != info2["name"] || info3["hash"] != digest(txt2) {
+		t.Fatal("Wrong update info")
+	}
+	if readText(t, b, db, info2["name"].(string)) != txt2 {
+		t.Fatal("Content not updated")
+	}
+	if _, err = os.Stat(blob); !os.IsNotExist(err) {
+		t.Fatal("Unused blob not deleted")
+	}
+
+	// same content in other databases is counted separately
+	info4 := addText(t, b, "bst_test2", txt2)
+	err = b.Delete("bst_test2", info4["name"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readText(t, b, db, info2["name"].(string)) != txt2 {
+		t.Fatal("Blob deleted from other database")
+	}
+
+	// drop database
+	err = b.DropDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(store.blobPath(db, digest(txt2))); !os.IsNotExist(err) {
+		t.Fatal("Blobs not deleted")
+	}
+}
+
+func TestCasLeftoverBlob(t *testing.T) {
+	b, err := bytengine.NewByteStore("cas", `{"rootdir":"/tmp/cas_data"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := b.(*ByteStore)
+
+	// blob left on disk by a transaction which didn't commit
+	db := "bst_leftover"
+	txt := "Hello from bst!"
+	blob := store.blobPath(db, digest(txt))
+	err = os.MkdirAll(filepath.Dir(blob), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(blob, []byte(txt), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := addText(t, b, db, txt)
+	if readText(t, b, db, info["name"].(string)) != txt {
+		t.Fatal("Leftover blob not reused")
+	}
+	err = b.Delete(db, info["name"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(blob); !os.IsNotExist(err) {
+		t.Fatal("Unused blob not deleted")
+	}
+
+	// failed commits don't leave unreferenced blobs behind
+	info = addText(t, b, db, txt)
+	err = store.DB.Update(func(tx *bolt.Tx) error {
+		refs := tx.Bucket([]byte(db)).Bucket(refsBucket)
+		return refs.Put([]byte(digest(txt)), []byte("invalid"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txt2 := "Updated content"
+	_, err = b.Update(db, info["name"].(string), strings.NewReader(txt2), bytengine.FileHint{Size: int64(len(txt2))})
+	if err == nil {
+		t.Fatal("Update with invalid reference count succeeded")
+	}
+	if _, err = os.Stat(store.blobPath(db, digest(txt2))); !os.IsNotExist(err) {
+		t.Fatal("Unreferenced blob kept after failed commit")
+	}
+	if readText(t, b, db, info["name"].(string)) != txt {
+		t.Fatal("Content changed by failed update")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	bytestoretest.Run(t, func(t *testing.T) bytengine.ByteStore {
+		b, err := bytengine.NewByteStore("cas", `{"rootdir":"/tmp/cas_data"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
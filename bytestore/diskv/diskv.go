@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/johnwilson/bytengine"
 	"github.com/johnwilson/bytengine/bytestore"
@@ -18,7 +20,10 @@ type Config struct {
 	CacheSize uint64 `json:"cachesize"`
 }
 
-const SeparationCharacter = "-"
+const (
+	SeparationCharacter = "-"
+	TempDir             = ".tmp" // relative to the root directory
+)
 
 type ByteStore struct {
 	RootDir   string
@@ -41,19 +46,14 @@ func (m *ByteStore) newKey(db string) (key string, id string) {
 	return
 }
 
-func (m *ByteStore) save(key string, file *os.File) (map[string]interface{}, error) {
-	defer file.Close()
-	err := m.DB.WriteStream(key, file, false)
-	if err != nil {
-		return nil, err
-	}
-
-	info, err := bytestore.GetFileInfo(file.Name())
+func (m *ByteStore) save(key string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	in := bytestore.NewReader(r, hint)
+	err := m.DB.WriteStream(key, in, false)
 	if err != nil {
 		return nil, err
 	}
 
-	return info, nil
+	return in.Info(), nil
 }
 
 func (m *ByteStore) Start(config string) error {
@@ -70,18 +70,20 @@ func (m *ByteStore) Start(config string) error {
 		BasePath:     c.RootDir,
 		Transform:    transformFunc,
 		CacheSizeMax: 1024 * 1024 * c.CacheSize, // in megabytes
+		// content is streamed to a temporary file first so that a failed
+		// upload doesn't overwrite the previous content
+		TempDir: filepath.Join(c.RootDir, TempDir),
 	})
 	return nil
 }
 
-func (m *ByteStore) Add(db string, file *os.File) (map[string]interface{}, error) {
-	defer file.Close()
+func (m *ByteStore) Add(db string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
 	key, filename := m.newKey(db)
 	if len(key) == 0 {
 		return nil, fmt.Errorf("Item could not be added: invalid key")
 	}
 
-	info, err := m.save(key, file)
+	info, err := m.save(key, r, hint)
 	if err != nil {
 		return nil, err
 	}
@@ -89,10 +91,9 @@ func (m *ByteStore) Add(db string, file *os.File) (map[string]interface{}, error
 	return info, nil
 }
 
-func (m *ByteStore) Update(db, filename string, file *os.File) (map[string]interface{}, error) {
-	defer file.Close()
+func (m *ByteStore) Update(db, filename string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
 	key := m.getKey(db, filename)
-	info, err := m.save(key, file)
+	info, err := m.save(key, r, hint)
 	if err != nil {
 		return nil, err
 	}
@@ -133,6 +134,39 @@ func (m *ByteStore) Read(db, filename string, file io.Writer) error {
 	return nil
 }
 
+// filePath returns the path of the file diskv stores the key in
+func (m *ByteStore) filePath(key string) string {
+	return filepath.Join(m.DB.BasePath, filepath.Join(m.DB.Transform(key)...), key)
+}
+
+// ReadRange reads the item's file directly so that it can seek to offset
+func (m *ByteStore) ReadRange(db, filename string, offset, length int64, file io.Writer) error {
+	f, err := os.Open(m.filePath(m.getKey(db, filename)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return bytestore.CopyRange(file, f, offset, length)
+}
+
+func (m *ByteStore) List(db string) (map[string]time.Time, error) {
+	prefix := db + SeparationCharacter
+	list := make(map[string]time.Time)
+	for key := range m.DB.KeysPrefix(prefix, nil) {
+		fi, err := os.Stat(m.filePath(key))
+		if os.IsNotExist(err) {
+			// deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list[strings.TrimPrefix(key, prefix)] = fi.ModTime()
+	}
+	return list, nil
+}
+
 func (m *ByteStore) DropDatabase(db string) error {
 	for key := range m.DB.Keys(nil) {
 		prefix := db + SeparationCharacter
@@ -2,6 +2,7 @@ package diskv
 
 import (
 	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/bytestore/bytestoretest"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -34,8 +35,9 @@ func TestDiskVBST(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer f.Close()
 	// add to store
-	info, err := b.Add(db, f)
+	info, err := b.Add(db, f, bytengine.FileHint{Name: fpath, Size: int64(len(txt))})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,3 +73,19 @@ func TestDiskVBST(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	bytestoretest.Run(t, func(t *testing.T) bytengine.ByteStore {
+		b, err := bytengine.NewByteStore(
+			"diskv",
+			`{
+                "rootdir":"/tmp/diskv_data",
+                "cachesize":1
+            }`,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
@@ -0,0 +1,234 @@
+package bytestore
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/johnwilson/bytengine"
+)
+
+// MimeList maps file extensions to mime types. It is used to refine the
+// type of content which can't be identified from its first bytes.
+var MimeList = map[string]string{
+	// text
+	".txt":  "text/plain",
+	".text": "text/plain",
+	".log":  "text/plain",
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".tsv":  "text/tab-separated-values",
+	".html": "text/html",
+	".htm":  "text/html",
+	".css":  "text/css",
+	".js":   "text/javascript",
+	".mjs":  "text/javascript",
+	".ics":  "text/calendar",
+	".vcf":  "text/vcard",
+	".json": "application/json",
+	".map":  "application/json",
+	".xml":  "application/xml",
+	".xsl":  "application/xml",
+	".rss":  "application/rss+xml",
+	".atom": "application/atom+xml",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".toml": "application/toml",
+	".svg":  "image/svg+xml",
+	".rtf":  "application/rtf",
+	".sh":   "application/x-sh",
+
+	// images
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".ico":  "image/x-icon",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".avif": "image/avif",
+	".heic": "image/heic",
+	".jxl":  "image/jxl",
+	".psd":  "image/vnd.adobe.photoshop",
+
+	// audio and video
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".wav":  "audio/wave",
+	".flac": "audio/flac",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".mid":  "audio/midi",
+	".midi": "audio/midi",
+	".aif":  "audio/aiff",
+	".aiff": "audio/aiff",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".ogv":  "video/ogg",
+	".avi":  "video/avi",
+
+	// fonts
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+
+	// documents
+	".pdf":  "application/pdf",
+	".ps":   "application/postscript",
+	".doc":  "application/msword",
+	".xls":  "application/vnd.ms-excel",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".epub": "application/epub+zip",
+
+	// archives and binaries
+	".zip":    "application/zip",
+	".jar":    "application/java-archive",
+	".apk":    "application/vnd.android.package-archive",
+	".gz":     "application/gzip",
+	".tgz":    "application/gzip",
+	".bz2":    "application/x-bzip2",
+	".xz":     "application/x-xz",
+	".zst":    "application/zstd",
+	".7z":     "application/x-7z-compressed",
+	".rar":    "application/vnd.rar",
+	".tar":    "application/x-tar",
+	".wasm":   "application/wasm",
+	".sqlite": "application/vnd.sqlite3",
+	".db":     "application/vnd.sqlite3",
+	".bin":    "application/octet-stream",
+}
+
+// magic is the signature of a file format at the given offset
+type magic struct {
+	offset int
+	sig    []byte
+	mime   string
+}
+
+// magicNumbers complements the formats detected by http.DetectContentType
+var magicNumbers = []magic{
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("8BPS"), "image/vnd.adobe.photoshop"},
+	{0, []byte("\xFF\x0A"), "image/jxl"},
+	{0, []byte("\x00\x00\x00\x0CJXL \x0D\x0A\x87\x0A"), "image/jxl"},
+	{4, []byte("ftypavif"), "image/avif"},
+	{4, []byte("ftypheic"), "image/heic"},
+	{4, []byte("ftypheix"), "image/heic"},
+	{4, []byte("ftypM4A "), "audio/mp4"},
+	{4, []byte("ftypqt  "), "video/quicktime"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("\xFF\xFB"), "audio/mpeg"},
+	{0, []byte("\xFF\xF3"), "audio/mpeg"},
+	{0, []byte("\xFF\xF2"), "audio/mpeg"},
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\xFD7zXZ\x00"), "application/x-xz"},
+	{0, []byte("\x28\xB5\x2F\xFD"), "application/zstd"},
+	{257, []byte("ustar"), "application/x-tar"},
+	{0, []byte("SQLite format 3\x00"), "application/vnd.sqlite3"},
+	{0, []byte("{\\rtf1"), "application/rtf"},
+	{0, []byte("\x7FELF"), "application/x-executable"},
+}
+
+// generic types which are refined with the file extension or the type
+// declared by the client
+var genericMimes = map[string]bool{
+	"text/plain":               true,
+	"text/xml":                 true,
+	"application/octet-stream": true,
+	"application/zip":          true, // office documents, epub, jar ...
+	"application/ogg":          true,
+	"video/webm":               true, // matroska
+}
+
+// isText reports whether the mime type describes text content
+func isText(mt string) bool {
+	return strings.HasPrefix(mt, "text/") ||
+		strings.HasSuffix(mt, "+xml") ||
+		strings.HasSuffix(mt, "+json") ||
+		mt == "application/json" ||
+		mt == "application/xml" ||
+		mt == "application/yaml" ||
+		mt == "application/toml" ||
+		mt == "application/rtf" ||
+		mt == "application/x-sh"
+}
+
+// ParseMime validates a mime type and returns it in canonical form
+func ParseMime(s string) (string, error) {
+	mt, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(mt, "/") {
+		return "", fmt.Errorf("mime type '%s' has no subtype", s)
+	}
+	return mime.FormatMediaType(mt, params), nil
+}
+
+// DetectMime returns the mime type of content starting with head. The
+// hint's Mime always wins. Otherwise known signatures are looked up and
+// generic types such as 'text/plain' or 'application/zip' are refined with
+// the content type declared by the client or the file name extension.
+func DetectMime(head []byte, hint bytengine.FileHint) string {
+	if hint.Mime != "" {
+		return hint.Mime
+	}
+
+	for _, m := range magicNumbers {
+		if len(head) >= m.offset+len(m.sig) && bytes.Equal(head[m.offset:m.offset+len(m.sig)], m.sig) {
+			return m.mime
+		}
+	}
+
+	detected := http.DetectContentType(head)
+	mt, params, err := mime.ParseMediaType(detected)
+	if err != nil || !genericMimes[mt] {
+		return detected
+	}
+
+	// client and extension types
+	candidates := []string{}
+	if hint.ContentType != "" {
+		if ct, _, err := mime.ParseMediaType(hint.ContentType); err == nil && ct != mt {
+			candidates = append(candidates, ct)
+		}
+	}
+	if ext, ok := MimeList[strings.ToLower(path.Ext(hint.Name))]; ok && ext != mt {
+		candidates = append(candidates, ext)
+	}
+	for _, c := range candidates {
+		if c == "application/octet-stream" {
+			continue
+		}
+		// text is never reported as binary content and vice versa
+		if isText(mt) != isText(c) {
+			continue
+		}
+		if isText(c) {
+			// keep the detected charset
+			return mime.FormatMediaType(c, params)
+		}
+		return c
+	}
+	return detected
+}
@@ -0,0 +1,68 @@
+package bytestore
+
+import (
+	"testing"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectMime(t *testing.T) {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tar := make([]byte, 512)
+	copy(tar, "file.txt")
+	copy(tar[257:], "ustar\x0000")
+
+	tests := []struct {
+		head []byte
+		hint bytengine.FileHint
+		mime string
+	}{
+		// signatures
+		{[]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytengine.FileHint{}, "image/png"},
+		{tiff, bytengine.FileHint{}, "image/tiff"},
+		{tar, bytengine.FileHint{Name: "archive.bin"}, "application/x-tar"},
+		{[]byte("\x00\x00\x00\x1Cftypavif"), bytengine.FileHint{}, "image/avif"},
+		{[]byte("7z\xBC\xAF\x27\x1C\x00\x04"), bytengine.FileHint{}, "application/x-7z-compressed"},
+		{[]byte("SQLite format 3\x00"), bytengine.FileHint{}, "application/vnd.sqlite3"},
+
+		// signatures win over the name and declared type
+		{[]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytengine.FileHint{Name: "a.txt", ContentType: "text/plain"}, "image/png"},
+
+		// text refined with the extension, charset is kept
+		{[]byte("body {}"), bytengine.FileHint{Name: "style.css"}, "text/css; charset=utf-8"},
+		{[]byte(`{"a": 1}`), bytengine.FileHint{Name: "data.JSON"}, "application/json; charset=utf-8"},
+		{[]byte("<svg></svg>"), bytengine.FileHint{Name: "logo.svg"}, "image/svg+xml; charset=utf-8"},
+		{[]byte("plain"), bytengine.FileHint{Name: "noext"}, "text/plain; charset=utf-8"},
+
+		// declared type comes before the extension
+		{[]byte(`{"a": 1}`), bytengine.FileHint{Name: "data.txt", ContentType: "application/json"}, "application/json; charset=utf-8"},
+		{[]byte("a: 1"), bytengine.FileHint{ContentType: "application/octet-stream", Name: "a.yaml"}, "application/yaml; charset=utf-8"},
+		{[]byte("a: 1"), bytengine.FileHint{ContentType: "invalid;;"}, "text/plain; charset=utf-8"},
+
+		// text and binary types aren't mixed up
+		{[]byte("plain"), bytengine.FileHint{Name: "image.png"}, "text/plain; charset=utf-8"},
+		{[]byte("\x00\x01\x02\x03"), bytengine.FileHint{Name: "data.json"}, "application/octet-stream"},
+		{[]byte("\x00\x01\x02\x03"), bytengine.FileHint{Name: "movie.mkv"}, "video/x-matroska"},
+
+		// containers
+		{[]byte("PK\x03\x04\x14\x00"), bytengine.FileHint{Name: "report.docx"}, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{[]byte("PK\x03\x04\x14\x00"), bytengine.FileHint{Name: "archive.zip"}, "application/zip"},
+
+		// override
+		{[]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytengine.FileHint{Mime: "application/x-custom"}, "application/x-custom"},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.mime, DetectMime(tc.head, tc.hint), "wrong mime for %q %+v", tc.head, tc.hint)
+	}
+}
+
+func TestParseMime(t *testing.T) {
+	mt, err := ParseMime("Text/HTML; Charset=UTF-8")
+	assert.Nil(t, err, "valid mime type rejected")
+	assert.Equal(t, "text/html; charset=UTF-8", mt, "wrong canonical mime type")
+	_, err = ParseMime("text")
+	assert.NotNil(t, err, "invalid mime type accepted")
+	_, err = ParseMime("")
+	assert.NotNil(t, err, "empty mime type accepted")
+}
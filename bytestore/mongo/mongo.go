@@ -3,7 +3,6 @@ package mongo
 import (
 	"encoding/json"
 	"io"
-	"os"
 	"strings"
 	"time"
 
@@ -11,6 +10,7 @@ import (
 	"github.com/johnwilson/bytengine/bytestore"
 	"github.com/nu7hatch/gouuid"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type Config struct {
@@ -27,26 +27,26 @@ type ByteStore struct {
 	database string
 }
 
-func (m *ByteStore) save(db, filename string, file *os.File) (map[string]interface{}, error) {
+func (m *ByteStore) save(db, filename string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
 	gfile, err := m.session.DB(m.database).GridFS(db).Create(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	_, err = io.Copy(gfile, file)
+	in := bytestore.NewReader(r, hint)
+	gfile.SetContentType(in.Mime())
+	_, err = io.Copy(gfile, in)
 	if err != nil {
+		// remove chunks written so far
+		gfile.Abort()
+		gfile.Close()
 		return nil, err
 	}
 	err = gfile.Close()
 	if err != nil {
 		return nil, err
 	}
-	info, err := bytestore.GetFileInfo(file.Name())
-	if err != nil {
-		return nil, err
-	}
 
-	return info, nil
+	return in.Info(), nil
 }
 
 func (m *ByteStore) Start(config string) error {
@@ -72,14 +72,13 @@ func (m *ByteStore) Start(config string) error {
 	return nil
 }
 
-func (m *ByteStore) Add(db string, file *os.File) (map[string]interface{}, error) {
-	defer file.Close()
+func (m *ByteStore) Add(db string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
 	tmp, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
 	}
 	filename := tmp.String()
-	info, err := m.save(db, filename, file)
+	info, err := m.save(db, filename, r, hint)
 	if err != nil {
 		return nil, err
 	}
@@ -87,9 +86,8 @@ func (m *ByteStore) Add(db string, file *os.File) (map[string]interface{}, error
 	return info, nil
 }
 
-func (m *ByteStore) Update(db, filename string, file *os.File) (map[string]interface{}, error) {
-	defer file.Close()
-	info, err := m.save(db, filename, file)
+func (m *ByteStore) Update(db, filename string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	info, err := m.save(db, filename, r, hint)
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +127,35 @@ func (m *ByteStore) Read(db, filename string, file io.Writer) error {
 	return nil
 }
 
+func (m *ByteStore) ReadRange(db, filename string, offset, length int64, file io.Writer) error {
+	gfile, err := m.session.DB(m.database).GridFS(db).Open(filename)
+	if err != nil {
+		return err
+	}
+	defer gfile.Close()
+	// GridFS files seek to the chunk holding offset
+	return bytestore.CopyRange(file, gfile, offset, length)
+}
+
+func (m *ByteStore) List(db string) (map[string]time.Time, error) {
+	// updates store new versions of a file under the same name
+	list := make(map[string]time.Time)
+	var file struct {
+		Filename   string    `bson:"filename"`
+		UploadDate time.Time `bson:"uploadDate"`
+	}
+	iter := m.session.DB(m.database).GridFS(db).Files.Find(nil).Select(bson.M{"filename": 1, "uploadDate": 1}).Iter()
+	for iter.Next(&file) {
+		if file.UploadDate.After(list[file.Filename]) {
+			list[file.Filename] = file.UploadDate
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
 func (m *ByteStore) DropDatabase(db string) error {
 	exists := false
 	list, err := m.session.DB(m.database).CollectionNames()
@@ -2,6 +2,7 @@ package mongo
 
 import (
 	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/bytestore/bytestoretest"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -38,8 +39,9 @@ func TestMongoBST(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer f.Close()
 	// add to store
-	info, err := b.Add(db, f)
+	info, err := b.Add(db, f, bytengine.FileHint{Name: fpath, Size: int64(len(txt))})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,3 +77,23 @@ func TestMongoBST(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	bytestoretest.Run(t, func(t *testing.T) bytengine.ByteStore {
+		b, err := bytengine.NewByteStore(
+			"mongodb",
+			`{
+                "addresses":["localhost:27017"],
+                "authdb":"",
+                "username":"",
+                "password":"",
+                "storedb":"bytestore",
+                "timeout":60
+            }`,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	})
+}
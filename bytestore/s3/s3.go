@@ -0,0 +1,221 @@
+// Package s3 provides a byte store plugin which keeps attachments in any
+// S3 compatible object storage. Attachments of each database are stored in
+// the configured bucket under the prefix "<prefix><database>/".
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/bytestore"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/nu7hatch/gouuid"
+)
+
+type Config struct {
+	Endpoint  string        `json:"endpoint"`
+	AccessKey string        `json:"accesskey"`
+	SecretKey string        `json:"secretkey"`
+	Region    string        `json:"region"`
+	Bucket    string        `json:"bucket"`
+	Prefix    string        `json:"prefix"`
+	Secure    bool          `json:"secure"`
+	PathStyle bool          `json:"pathstyle"`
+	Timeout   time.Duration `json:"timeout"`
+}
+
+// transport is used by the s3 client if set (tests use it to trust the
+// certificate of a local server)
+var transport http.RoundTripper
+
+type ByteStore struct {
+	config *Config
+	client *minio.Client
+}
+
+func NewByteStore() *ByteStore {
+	return &ByteStore{}
+}
+
+func (m *ByteStore) getKey(db, filename string) string {
+	return m.config.Prefix + db + "/" + filename
+}
+
+func (m *ByteStore) newKey(db string) (key string, id string) {
+	tmp, err := uuid.NewV4()
+	if err != nil {
+		return "", ""
+	}
+	id = strings.Replace(tmp.String(), "-", "", -1)
+	key = m.getKey(db, id)
+	return
+}
+
+// context returns a context bound to the configured request timeout
+func (m *ByteStore) context() (context.Context, context.CancelFunc) {
+	if m.config.Timeout > 0 {
+		return context.WithTimeout(context.Background(), m.config.Timeout*time.Second)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (m *ByteStore) save(key string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	in := bytestore.NewReader(r, hint)
+
+	ctx, cancel := m.context()
+	defer cancel()
+	// the size is left unknown so that the client reads until EOF and the
+	// upload fails if the content doesn't match the size hint
+	opts := minio.PutObjectOptions{ContentType: in.Mime()}
+	_, err := m.client.PutObject(ctx, m.config.Bucket, key, in, -1, opts)
+	if err != nil {
+		return nil, err
+	}
+	return in.Info(), nil
+}
+
+func (m *ByteStore) Start(config string) error {
+	var c Config
+	err := json.Unmarshal([]byte(config), &c)
+	if err != nil {
+		return err
+	}
+	if len(c.Endpoint) == 0 || len(c.Bucket) == 0 {
+		return errors.New("s3: endpoint and bucket required")
+	}
+
+	lookup := minio.BucketLookupAuto
+	if c.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(c.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Secure:       c.Secure,
+		Region:       c.Region,
+		BucketLookup: lookup,
+		Transport:    transport,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.config = &c
+	m.client = client
+	return nil
+}
+
+func (m *ByteStore) Add(db string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	key, filename := m.newKey(db)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Item could not be added: invalid key")
+	}
+
+	info, err := m.save(key, r, hint)
+	if err != nil {
+		return nil, err
+	}
+	info["name"] = filename
+	return info, nil
+}
+
+func (m *ByteStore) Update(db, filename string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	return m.save(m.getKey(db, filename), r, hint)
+}
+
+func (m *ByteStore) Delete(db, filename string) error {
+	ctx, cancel := m.context()
+	defer cancel()
+	return m.client.RemoveObject(ctx, m.config.Bucket, m.getKey(db, filename), minio.RemoveObjectOptions{})
+}
+
+func (m *ByteStore) Read(db, filename string, file io.Writer) error {
+	ctx, cancel := m.context()
+	defer cancel()
+	obj, err := m.client.GetObject(ctx, m.config.Bucket, m.getKey(db, filename), minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	_, err = io.Copy(file, obj)
+	return err
+}
+
+// ReadRange only requests the bytes in range from the server
+func (m *ByteStore) ReadRange(db, filename string, offset, length int64, file io.Writer) error {
+	if offset < 0 {
+		return fmt.Errorf("invalid range offset %d", offset)
+	}
+
+	ctx, cancel := m.context()
+	defer cancel()
+	key := m.getKey(db, filename)
+	if length == 0 {
+		_, err := m.client.StatObject(ctx, m.config.Bucket, key, minio.StatObjectOptions{})
+		return err
+	}
+
+	opts := minio.GetObjectOptions{}
+	var err error
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+	if err != nil {
+		return err
+	}
+	obj, err := m.client.GetObject(ctx, m.config.Bucket, key, opts)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	return bytestore.CopyRange(file, obj, 0, length)
+}
+
+func (m *ByteStore) List(db string) (map[string]time.Time, error) {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	prefix := m.getKey(db, "")
+	list := make(map[string]time.Time)
+	opts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true}
+	for obj := range m.client.ListObjects(ctx, m.config.Bucket, opts) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		list[strings.TrimPrefix(obj.Key, prefix)] = obj.LastModified
+	}
+	return list, nil
+}
+
+func (m *ByteStore) DropDatabase(db string) error {
+	ctx, cancel := m.context()
+	defer cancel()
+
+	opts := minio.ListObjectsOptions{Prefix: m.getKey(db, ""), Recursive: true}
+	for obj := range m.client.ListObjects(ctx, m.config.Bucket, opts) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		err := m.client.RemoveObject(ctx, m.config.Bucket, obj.Key, minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	bytengine.RegisterByteStore("s3", NewByteStore())
+}
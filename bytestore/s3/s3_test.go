@@ -0,0 +1,138 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/bytestore/bytestoretest"
+	"github.com/minio/minio-go/v7"
+)
+
+// startStore starts a fake s3 server and returns a bst using it. TLS is
+// used since the fake server doesn't support the streaming signatures sent
+// over plain http.
+func startStore(t *testing.T) (bytengine.ByteStore, *minio.Client) {
+	faker := gofakes3.New(s3mem.New())
+	ts := httptest.NewTLSServer(faker.Server())
+	t.Cleanup(ts.Close)
+	transport = ts.Client().Transport
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// create bst client
+	b, err := bytengine.NewByteStore(
+		"s3",
+		fmt.Sprintf(`{
+            "endpoint":"%s",
+            "accesskey":"key",
+            "secretkey":"secret",
+            "region":"us-east-1",
+            "bucket":"bytengine",
+            "prefix":"bst/",
+            "secure":true,
+            "pathstyle":true,
+            "timeout":10
+        }`, u.Host),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := b.(*ByteStore).client
+	err = client.MakeBucket(context.Background(), "bytengine", minio.MakeBucketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, client
+}
+
+func TestS3BST(t *testing.T) {
+	b, client := startStore(t)
+
+	txt := "Hello from bst!"
+	db := "bst_test"
+	// add to store
+	info, err := b.Add(db, strings.NewReader(txt), bytengine.FileHint{Size: int64(len(txt))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := info["name"].(string)
+
+	// object is stored under the database prefix
+	stat, err := client.StatObject(context.Background(), "bytengine", "bst/bst_test/"+name, minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.ContentType != info["mime"].(string) {
+		t.Fatal("Content type not stored")
+	}
+
+	// read from store
+	var buf bytes.Buffer
+	err = b.Read(db, name, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txt != buf.String() {
+		t.Fatal("Content in files is different")
+	}
+
+	// update content
+	txt2 := "Updated content"
+	// content of unknown size is uploaded in parts
+	_, err = b.Update(db, name, strings.NewReader(txt2), bytengine.FileHint{Size: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	err = b.Read(db, name, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txt2 != buf.String() {
+		t.Fatal("Content not updated")
+	}
+
+	// delete from store
+	err = b.Delete(db, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Read(db, name, ioutil.Discard)
+	if err == nil {
+		t.Fatal("Deleted item still readable")
+	}
+
+	// drop database
+	for i := 0; i < 3; i++ {
+		_, err = b.Add(db, strings.NewReader(txt), bytengine.FileHint{Size: int64(len(txt))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = b.DropDatabase(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := minio.ListObjectsOptions{Prefix: "bst/", Recursive: true}
+	for obj := range client.ListObjects(context.Background(), "bytengine", opts) {
+		t.Fatal("Object not deleted:", obj.Key)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	bytestoretest.Run(t, func(t *testing.T) bytengine.ByteStore {
+		b, _ := startStore(t)
+		return b
+	})
+}
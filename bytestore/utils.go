@@ -1,50 +1,126 @@
 package bytestore
 
 import (
-	"net/http"
-	"os"
-	"path"
-	"strings"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"github.com/johnwilson/bytengine"
 )
 
-// to be expanded
-var MimeList = map[string]string{
-	".js":  "text/javascript",
-	".css": "text/css",
+// sniffLen is the number of bytes used to detect the mime type
+const sniffLen = 512
+
+// Reader wraps content passed to a byte store and computes its size, mime
+// type and sha256 checksum while it is read so that plugins can stream
+// uploads without spooling them to a temporary file first.
+type Reader struct {
+	r    *bufio.Reader
+	hint bytengine.FileHint
+	hash hash.Hash
+	size int64
+	mime string
 }
 
-func GetFileInfo(fpath string) (map[string]interface{}, error) {
-	// try and get uploaded file mime type
-	tmpfile, err := os.Open(fpath)
-	if err != nil {
-		return nil, err
+// NewReader returns a Reader for r. The mime type is detected from the
+// first bytes of the content which are buffered without being consumed.
+func NewReader(r io.Reader, hint bytengine.FileHint) *Reader {
+	br := bufio.NewReaderSize(r, sniffLen)
+	head, _ := br.Peek(sniffLen) // read errors are returned by Read
+	return &Reader{
+		r:    br,
+		hint: hint,
+		hash: sha256.New(),
+		mime: DetectMime(head, hint),
 	}
-	defer tmpfile.Close()
+}
 
-	// read 1024 bytes to enable mime type retrieval
-	mimebuffer := make([]byte, 1024)
-	_, err = tmpfile.Read(mimebuffer)
-	if err != nil {
-		return nil, err
+// Read reads from the underlying reader and fails if the content length
+// doesn't match the size hint.
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.size += int64(n)
+	r.hash.Write(p[:n])
+	if r.hint.Size < 0 {
+		return n, err
 	}
-	mime := http.DetectContentType(mimebuffer)
-
-	// if mime is 'text/plain' try and get exact mime from file extension
-	prefix := "text/plain;"
-	if strings.HasPrefix(mime, prefix) {
-		ext := path.Ext(fpath)
-		mval, exists := MimeList[ext]
-		if exists {
-			mime = strings.Replace(mime, prefix, mval, 1)
+	if r.size > r.hint.Size {
+		return n, fmt.Errorf("content exceeds expected size of %d bytes", r.hint.Size)
+	}
+	if r.size == r.hint.Size && err == nil {
+		// stores may stop reading once the expected size is reached
+		_, perr := r.r.Peek(1)
+		if perr == nil {
+			return n, fmt.Errorf("content exceeds expected size of %d bytes", r.hint.Size)
 		}
+		if perr != io.EOF {
+			return n, perr
+		}
+		return n, io.EOF
+	}
+	if err == io.EOF && r.size != r.hint.Size {
+		return n, fmt.Errorf("content is shorter than expected size of %d bytes", r.hint.Size)
 	}
+	return n, err
+}
+
+// Mime returns the detected mime type
+func (r *Reader) Mime() string {
+	return r.mime
+}
+
+// Size returns the number of bytes read so far
+func (r *Reader) Size() int64 {
+	return r.size
+}
 
-	// get total file size
-	f_info, _ := tmpfile.Stat()
-	size := f_info.Size()
+// Hash returns the hex encoded sha256 checksum of the bytes read so far
+func (r *Reader) Hash() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
 
+// Info returns the 'mime', 'size' and 'hash' of the content once it has
+// been read
+func (r *Reader) Info() map[string]interface{} {
 	val := make(map[string]interface{})
-	val["mime"] = mime
-	val["size"] = size
-	return val, nil
+	val["mime"] = r.Mime()
+	val["size"] = r.Size()
+	val["hash"] = r.Hash()
+	return val
+}
+
+// CopyRange copies length bytes of r starting at offset to w. Everything
+// after offset is copied if length is negative. Readers implementing
+// io.Seeker are moved to offset instead of being read.
+func CopyRange(w io.Writer, r io.Reader, offset, length int64) error {
+	if offset < 0 {
+		return fmt.Errorf("invalid range offset %d", offset)
+	}
+
+	var err error
+	if s, ok := r.(io.Seeker); ok {
+		_, err = s.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, r, offset)
+	}
+	if err == io.EOF {
+		return fmt.Errorf("range exceeds content size")
+	}
+	if err != nil {
+		return err
+	}
+
+	if length < 0 {
+		_, err = io.Copy(w, r)
+		return err
+	}
+	_, err = io.CopyN(w, r, length)
+	if err == io.EOF {
+		return fmt.Errorf("range exceeds content size")
+	}
+	return err
 }
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"os"
 
 	"github.com/gin-gonic/gin"
@@ -24,6 +26,7 @@ const (
 type EngineRequest struct {
 	Token        string
 	Script       string
+	Atomic       bool // all script commands succeed or none
 	Command      *bytengine.Command
 	ResponseChan chan EngineResponse
 }
@@ -51,6 +54,9 @@ func errorResponse(err error) []byte {
 		"status": "error",
 		"msg":    err.Error(),
 	}
+	if _, ok := err.(*bytengine.ConflictError); ok {
+		val["code"] = "conflict"
+	}
 
 	b, e := json.Marshal(val)
 	if e != nil {
@@ -87,6 +93,10 @@ func Worker(config *Config, requests chan *EngineRequest) {
 			r, err := engine.ExecuteCommand(req.Token, *req.Command)
 			rep := EngineResponse{r, err}
 			req.ResponseChan <- rep
+		} else if req.Atomic {
+			r, err := engine.ExecuteAtomicScript(req.Token, req.Script)
+			rep := EngineResponse{r, err}
+			req.ResponseChan <- rep
 		} else {
 			r, err := engine.ExecuteScript(req.Token, req.Script)
 			rep := EngineResponse{r, err}
@@ -111,8 +121,9 @@ func welcomeHandler(ctx *gin.Context) {
 
 func runScriptHandler(ctx *gin.Context) {
 	var form struct {
-		Token string `form:"token" binding:"required"`
-		Query string `form:"query" binding:"required"`
+		Token  string `form:"token" binding:"required"`
+		Query  string `form:"query" binding:"required"`
+		Atomic bool   `form:"atomic"`
 	}
 
 	ok := ctx.Bind(&form)
@@ -125,19 +136,28 @@ func runScriptHandler(ctx *gin.Context) {
 	req := EngineRequest{
 		Token:        form.Token,
 		Script:       form.Query,
+		Atomic:       form.Atomic,
 		ResponseChan: make(chan EngineResponse),
 	}
 	EngineRequestChan <- &req
 	rep := <-req.ResponseChan
 	if rep.Error != nil {
 		data := errorResponse(rep.Error)
-		ctx.Data(400, "application/json", data)
+		ctx.Data(errorStatus(rep.Error), "application/json", data)
 		return
 	}
 
 	ctx.Data(200, "application/json", okResponse(rep.Response))
 }
 
+// errorStatus returns the http status of a failed script
+func errorStatus(err error) int {
+	if _, ok := err.(*bytengine.ConflictError); ok {
+		return 409
+	}
+	return 400
+}
+
 func getTokenHandler(ctx *gin.Context) {
 	var form struct {
 		Username string `form:"username" binding:"required"`
@@ -183,6 +203,8 @@ func getUploadTicketHandler(ctx *gin.Context) {
 		Token    string `form:"token" binding:"required"`
 		Database string `form:"database" binding:"required"`
 		Path     string `form:"path" binding:"required"`
+		Filename string `form:"filename"`
+		Mime     string `form:"mime"`
 	}
 	ok := ctx.Bind(&form)
 	if ok != nil {
@@ -199,6 +221,12 @@ func getUploadTicketHandler(ctx *gin.Context) {
 	}
 	cmd.Database = form.Database
 	cmd.Args["path"] = form.Path
+	if form.Filename != "" {
+		cmd.Args["filename"] = form.Filename
+	}
+	if form.Mime != "" {
+		cmd.Args["mime"] = form.Mime
+	}
 
 	duration := Configuration.Timeout.UploadTicket // in minutes
 	cmd.Args["duration"] = duration
@@ -219,65 +247,49 @@ func getUploadTicketHandler(ctx *gin.Context) {
 	ctx.Data(200, "application/json", okResponse(rep.Response))
 }
 
-func uploadFileHelper(max int, ctx *gin.Context) (string, int, error) {
-	total := 0             // total bytes read/written
-	maxbytes := 1024 * max // maximum upload size in bytes
+// uploadLimitReader fails once more than max bytes have been read
+type uploadLimitReader struct {
+	r     io.Reader
+	total int64 // total bytes read
+	max   int64 // maximum upload size in bytes
+}
 
-	tmpfile, err := ioutil.TempFile("", "bytengine_upload_") // upload file name from header
-	if err != nil {
-		return "", 0, err
+func (l *uploadLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.total += int64(n)
+	if l.total > l.max {
+		return n, fmt.Errorf("exceeded maximum file size of %d bytes", l.max)
 	}
-	defer tmpfile.Close()
+	return n, err
+}
 
-	// create read buffer
-	var bsize int64 = 16 * 1024 // 16 kb
-	buffer := make([]byte, bsize)
+// uploadFileHelper returns the first part of the multipart upload and a
+// reader which streams its content up to the maximum size (in kb)
+func uploadFileHelper(max int, ctx *gin.Context) (*multipart.Part, io.Reader, error) {
+	maxbytes := int64(1024 * max) // maximum upload size in bytes
 
 	// get stream
 	mr, err := ctx.Request.MultipartReader()
 	if err != nil {
-		return "", 0, err
+		return nil, nil, err
 	}
 	in_f, err := mr.NextPart()
 	if err != nil {
-		return "", 0, err
-	}
-	defer in_f.Close()
-
-	// start reading/writing
-
-	for {
-		// read
-		n, err := in_f.Read(buffer)
-		if n == 0 {
-			break
-		}
-		if err != nil {
-			return "", 0, err
-		}
-		// update total bytes
-		total += n
-		if total > maxbytes {
-			return "", 0, fmt.Errorf("exceeded maximum file size of %d bytes", maxbytes)
-		}
-		// write
-		n, err = tmpfile.Write(buffer[:n])
-		if err != nil {
-			return "", 0, err
-		}
+		return nil, nil, err
 	}
 
-	return tmpfile.Name(), total, nil
+	return in_f, &uploadLimitReader{r: in_f, max: maxbytes}, nil
 }
 
 func uploadFileHandler(ctx *gin.Context) {
 	ticket := ctx.Params.ByName("ticket")
-	filename, _, err := uploadFileHelper(300, ctx)
+	part, r, err := uploadFileHelper(300, ctx)
 	if err != nil {
 		data := errorResponse(fmt.Errorf("upload failed: %s", err.Error()))
 		ctx.Data(400, "application/json", data)
 		return
 	}
+	defer part.Close()
 
 	cmd := bytengine.Command{
 		Name:    "writebytes",
@@ -286,7 +298,13 @@ func uploadFileHandler(ctx *gin.Context) {
 		Options: make(map[string]interface{}),
 	}
 	cmd.Args["ticket"] = ticket
-	cmd.Args["tmpfile"] = filename
+	// content is streamed to the byte store by the command handler
+	cmd.Args["reader"] = r
+	cmd.Args["filename"] = part.FileName()
+	cmd.Args["contenttype"] = part.Header.Get("Content-Type")
+	cmd.Args["size"] = int64(-1) // unknown
+	// overrides the detected mime type
+	cmd.Args["mime"] = ctx.Request.URL.Query().Get("mime")
 
 	req := EngineRequest{
 		Token:        "",
@@ -309,6 +327,7 @@ func downloadFileHandler(ctx *gin.Context) {
 		Token    string `form:"token" binding:"required"`
 		Database string `form:"database" binding:"required"`
 		Path     string `form:"path" binding:"required"`
+		Version  int64  `form:"version"` // previous attachment version
 	}
 	ok := ctx.Bind(&form)
 	if ok != nil {
@@ -325,7 +344,11 @@ func downloadFileHandler(ctx *gin.Context) {
 	}
 	cmd.Database = form.Database
 	cmd.Args["path"] = form.Path
+	if form.Version > 0 {
+		cmd.Args["version"] = form.Version
+	}
 	cmd.Args["writer"] = ctx.Writer
+	cmd.Args["serve"] = serveContent(ctx, true)
 
 	ctx.Writer.Header().Set("Content-Type", "application/octet-stream")
 	req := EngineRequest{
@@ -357,7 +380,9 @@ func directaccessHandler(ctx *gin.Context) {
 	cmd.Args["path"] = path
 	cmd.Args["layer"] = layer
 	cmd.Args["writer"] = ctx.Writer
+	cmd.Args["serve"] = serveContent(ctx, false)
 
+	// known attachment mime types are set by serveContent
 	if layer == "json" {
 		ctx.Writer.Header().Set("Content-Type", "application/json")
 	} else {
@@ -376,6 +401,16 @@ func directaccessHandler(ctx *gin.Context) {
 		ctx.String(404, string(data))
 		return
 	}
+
+	// json layer content, nil if the client's copy is up to date
+	if content, ok := rep.Response.(map[string]interface{}); ok {
+		data, err := json.Marshal(content)
+		if err != nil {
+			ctx.String(500, string(errorResponse(err)))
+			return
+		}
+		ctx.Data(200, "application/json", data)
+	}
 }
 
 func readConfigFile(pth string) error {
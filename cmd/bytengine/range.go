@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errRangeInvalid       = errors.New("invalid range")
+	errRangeUnsatisfiable = errors.New("range not satisfiable")
+)
+
+// parseRange parses a 'Range' header for content of the given size and
+// returns the offset and length of the requested bytes. Only single ranges
+// are supported, requests for multiple ranges get the full content.
+func parseRange(s string, size int64) (offset, length int64, err error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return 0, 0, errRangeInvalid
+	}
+	s = strings.TrimSpace(s[len(prefix):])
+	if strings.Contains(s, ",") {
+		return 0, 0, errRangeInvalid
+	}
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return 0, 0, errRangeInvalid
+	}
+	start, end := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+
+	// suffix range: last n bytes
+	if start == "" {
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errRangeInvalid
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeUnsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	offset, err = strconv.ParseInt(start, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, errRangeInvalid
+	}
+	last := size - 1
+	if end != "" {
+		last, err = strconv.ParseInt(end, 10, 64)
+		if err != nil || last < offset {
+			return 0, 0, errRangeInvalid
+		}
+		if last >= size {
+			last = size - 1
+		}
+	}
+	if offset >= size {
+		return 0, 0, errRangeUnsatisfiable
+	}
+	return offset, last - offset + 1, nil
+}
+
+// ifRangeMatches reports whether a range request with the given 'If-Range'
+// header should be honoured. It holds either the strong entity tag or the
+// modification date of the content.
+func ifRangeMatches(s, etag string, modified time.Time) bool {
+	if s == "" {
+		return true
+	}
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "W/") {
+		return etag != "" && s == etag
+	}
+	t, err := http.ParseTime(s)
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return modified.Truncate(time.Second).Equal(t)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		offset int64
+		length int64
+		err    error
+	}{
+		{"bytes=0-99", 0, 100, nil},
+		{"bytes=0-0", 0, 1, nil},
+		{"bytes=100-199", 100, 100, nil},
+		{"bytes=900-", 900, 100, nil},
+		{"bytes=900-5000", 900, 100, nil},
+		{"bytes=-100", 900, 100, nil},
+		{"bytes=-5000", 0, 1000, nil},
+		{"bytes= 10 - 19", 10, 10, nil},
+		{"bytes=1000-", 0, 0, errRangeUnsatisfiable},
+		{"bytes=-0", 0, 0, errRangeUnsatisfiable},
+		{"bytes=0-9,20-29", 0, 0, errRangeInvalid},
+		{"bytes=20-10", 0, 0, errRangeInvalid},
+		{"bytes=a-b", 0, 0, errRangeInvalid},
+		{"bytes=--5", 0, 0, errRangeInvalid},
+		{"bytes=5", 0, 0, errRangeInvalid},
+		{"items=0-9", 0, 0, errRangeInvalid},
+	}
+	for _, tc := range tests {
+		offset, length, err := parseRange(tc.header, 1000)
+		assert.Equal(t, tc.err, err, "wrong error for %q", tc.header)
+		assert.Equal(t, tc.offset, offset, "wrong offset for %q", tc.header)
+		assert.Equal(t, tc.length, length, "wrong length for %q", tc.header)
+	}
+
+	_, _, err := parseRange("bytes=-10", 0)
+	assert.Equal(t, errRangeUnsatisfiable, err, "range of empty content satisfied")
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	assert.True(t, ifRangeMatches("", `"abc"`, modified), "missing If-Range should match")
+	assert.True(t, ifRangeMatches(`"abc"`, `"abc"`, modified), "same entity tag should match")
+	assert.False(t, ifRangeMatches(`"abd"`, `"abc"`, modified), "other entity tag matched")
+	assert.False(t, ifRangeMatches(`W/"abc"`, `"abc"`, modified), "weak entity tag matched")
+	assert.False(t, ifRangeMatches(`"abc"`, "", modified), "missing entity tag matched")
+	assert.True(t, ifRangeMatches("Wed, 21 Oct 2015 07:28:00 GMT", `"abc"`, modified), "same date should match")
+	assert.False(t, ifRangeMatches("Wed, 21 Oct 2015 07:29:00 GMT", `"abc"`, modified), "other date matched")
+	assert.False(t, ifRangeMatches("Wed, 21 Oct 2015 07:28:00 GMT", `"abc"`, time.Time{}), "unknown date matched")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johnwilson/bytengine"
+)
+
+// etagMatches reports whether etag is in the list of entity tags of an
+// 'If-None-Match' header using the weak comparison
+func etagMatches(list, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" || strings.TrimPrefix(item, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether the client's cached copy is still valid.
+// 'If-Modified-Since' is ignored when 'If-None-Match' is present.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(t)
+}
+
+// serveContent returns the function used by the readbytes and directaccess
+// commands to answer conditional and 'Range' requests. It writes the
+// response headers of byte layer requests. Downloads are sent as
+// attachments with their original file name, otherwise the content is
+// sent with its mime type.
+func serveContent(ctx *gin.Context, download bool) bytengine.ServeFunc {
+	return func(c bytengine.ContentInfo) (int64, int64, bool) {
+		r := ctx.Request
+		h := ctx.Writer.Header()
+		etag := ""
+		if c.Hash != "" {
+			etag = `"` + c.Hash + `"`
+			h.Set("ETag", etag)
+		}
+		if !c.Modified.IsZero() {
+			h.Set("Last-Modified", c.Modified.UTC().Format(http.TimeFormat))
+		}
+
+		if notModified(r, etag, c.Modified) {
+			h.Del("Content-Type")
+			ctx.Writer.WriteHeader(304)
+			return 0, 0, false
+		}
+
+		// json content is written by the http handler
+		if c.Size < 0 {
+			return 0, -1, true
+		}
+
+		h.Set("Accept-Ranges", "bytes")
+		if download {
+			if c.Filename != "" {
+				h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": c.Filename}))
+			}
+		} else if c.Mime != "" {
+			h.Set("Content-Type", c.Mime)
+		}
+		rng := r.Header.Get("Range")
+		if rng != "" && ifRangeMatches(r.Header.Get("If-Range"), etag, c.Modified) {
+			offset, length, err := parseRange(rng, c.Size)
+			switch err {
+			case nil:
+				h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, c.Size))
+				h.Set("Content-Length", strconv.FormatInt(length, 10))
+				ctx.Writer.WriteHeader(206)
+				return offset, length, true
+			case errRangeUnsatisfiable:
+				h.Set("Content-Range", fmt.Sprintf("bytes */%d", c.Size))
+				ctx.Writer.WriteHeader(416)
+				return 0, 0, false
+			}
+			// invalid ranges are ignored
+		}
+
+		h.Set("Content-Length", strconv.FormatInt(c.Size, 10))
+		ctx.Writer.WriteHeader(200)
+		return 0, -1, true
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	request := func(headers ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/bfs/direct/bytes/db/file", nil)
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		return r
+	}
+
+	assert.False(t, notModified(request(), `"abc"`, modified), "unconditional request not modified")
+	assert.True(t, notModified(request("If-None-Match", `"abc"`), `"abc"`, modified), "same entity tag modified")
+	assert.True(t, notModified(request("If-None-Match", `"xyz", W/"abc"`), `"abc"`, modified), "entity tag in list modified")
+	assert.True(t, notModified(request("If-None-Match", "*"), `"abc"`, modified), "wildcard modified")
+	assert.False(t, notModified(request("If-None-Match", `"xyz"`), `"abc"`, modified), "other entity tag not modified")
+	assert.False(t, notModified(request("If-None-Match", `"abc"`), "", modified), "missing entity tag not modified")
+
+	assert.True(t, notModified(request("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT"), `"abc"`, modified), "same date modified")
+	assert.True(t, notModified(request("If-Modified-Since", "Thu, 22 Oct 2015 07:28:00 GMT"), `"abc"`, modified.Add(time.Millisecond)), "later date modified")
+	assert.False(t, notModified(request("If-Modified-Since", "Tue, 20 Oct 2015 07:28:00 GMT"), `"abc"`, modified), "earlier date not modified")
+	assert.False(t, notModified(request("If-Modified-Since", "yesterday"), `"abc"`, modified), "invalid date not modified")
+	assert.False(t, notModified(request("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT"), `"abc"`, time.Time{}), "unknown date not modified")
+
+	// If-None-Match takes precedence
+	r := request("If-None-Match", `"xyz"`, "If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+	assert.False(t, notModified(r, `"abc"`, modified), "If-Modified-Since used with If-None-Match")
+}
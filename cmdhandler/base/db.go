@@ -19,7 +19,7 @@ func DbNewFile(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engin
 	path := cmd.Args["path"].(string)
 	data := cmd.Args["data"].(map[string]interface{})
 	db := cmd.Database
-	if err := eng.FileSystem.NewFile(path, db, data); err != nil {
+	if err := eng.FileSystem.NewFile(path, db, data, user.Username); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -72,9 +72,22 @@ func DbCopy(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine)
 
 // handler for: database.delete
 func DbDelete(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
-	path := cmd.Args["path"].(string)
 	db := cmd.Database
-	if err := eng.FileSystem.Delete(path, db); err != nil {
+	if _, ok := cmd.Args["dirs"]; ok {
+		// delete files matching a query
+		cmd.Args["ifrev"] = ifRevision(cmd)
+		paths, err := eng.FileSystem.BQLDelete(db, cmd.Args)
+		if err != nil {
+			return nil, err
+		}
+		r := map[string]interface{}{"count": len(paths)}
+		if _, ok := cmd.Options["paths"]; ok {
+			r["paths"] = paths
+		}
+		return r, nil
+	}
+	path := cmd.Args["path"].(string)
+	if err := eng.FileSystem.Delete(path, db, ifRevision(cmd)); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -112,7 +125,17 @@ func DbReadFile(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engi
 	path := cmd.Args["path"].(string)
 	fields := cmd.Args["fields"].([]string)
 	db := cmd.Database
-	return eng.FileSystem.ReadJson(path, db, fields)
+	if rev, ok := cmd.Options["rev"].(int64); ok {
+		return eng.FileSystem.ReadRevision(path, db, rev, fields)
+	}
+	content, rev, err := eng.FileSystem.ReadJson(path, db, fields)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cmd.Options["withrev"]; ok {
+		return map[string]interface{}{"revision": rev, "content": content}, nil
+	}
+	return content, nil
 }
 
 // handler for: database.modfile
@@ -120,7 +143,7 @@ func DbModFile(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engin
 	path := cmd.Args["path"].(string)
 	data := cmd.Args["data"].(map[string]interface{})
 	db := cmd.Database
-	if err := eng.FileSystem.UpdateJson(path, db, data); err != nil {
+	if err := eng.FileSystem.UpdateJson(path, db, data, user.Username, ifRevision(cmd)); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -162,13 +185,80 @@ func DbSelect(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine
 // handler for: database.set
 func DbSet(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
 	db := cmd.Database
-	return eng.FileSystem.BQLSet(db, cmd.Args)
+	cmd.Args["ifrev"] = ifRevision(cmd)
+	return eng.FileSystem.BQLSet(db, cmd.Args, user.Username)
 }
 
 // handler for: database.unset
 func DbUnset(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
 	db := cmd.Database
-	return eng.FileSystem.BQLUnset(db, cmd.Args)
+	cmd.Args["ifrev"] = ifRevision(cmd)
+	return eng.FileSystem.BQLUnset(db, cmd.Args, user.Username)
+}
+
+// handler for: database.history
+func DbHistory(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	path := cmd.Args["path"].(string)
+	db := cmd.Database
+	return eng.FileSystem.ListRevisions(path, db)
+}
+
+// handler for: database.revert
+func DbRevert(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	path := cmd.Args["path"].(string)
+	rev := cmd.Args["revision"].(int64)
+	db := cmd.Database
+	if err := eng.FileSystem.RevertJson(path, db, rev, user.Username); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handler for: database.byteversions
+func DbByteVersions(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	path := cmd.Args["path"].(string)
+	db := cmd.Database
+	return eng.FileSystem.ListBytesVersions(path, db)
+}
+
+// handler for: database.prunebytes
+func DbPruneBytes(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	path := cmd.Args["path"].(string)
+	var keep int64
+	if val, ok := cmd.Options["keep"]; ok {
+		keep = val.(int64)
+	}
+	db := cmd.Database
+	return eng.FileSystem.PruneBytesVersions(path, db, keep)
+}
+
+// handler for: database.trash
+func DbTrash(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	db := cmd.Database
+	return eng.FileSystem.ListTrash(db)
+}
+
+// handler for: database.restore
+func DbRestore(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	id := cmd.Args["id"].(string)
+	path, _ := cmd.Args["path"].(string)
+	db := cmd.Database
+	return eng.FileSystem.RestoreTrash(id, path, db)
+}
+
+// handler for: database.purge
+func DbPurge(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	id, _ := cmd.Args["id"].(string) // whole trash is purged with --all
+	db := cmd.Database
+	return eng.FileSystem.PurgeTrash(id, db)
+}
+
+// ifRevision returns the file revision expected by the command
+func ifRevision(cmd bytengine.Command) int64 {
+	if rev, ok := cmd.Options["ifrev"].(int64); ok {
+		return rev
+	}
+	return bytengine.AnyRevision
 }
 
 func init() {
@@ -189,4 +279,11 @@ func init() {
 	bytengine.RegisterCommandHandler("database.select", DbSelect)
 	bytengine.RegisterCommandHandler("database.set", DbSet)
 	bytengine.RegisterCommandHandler("database.unset", DbUnset)
+	bytengine.RegisterCommandHandler("database.history", DbHistory)
+	bytengine.RegisterCommandHandler("database.revert", DbRevert)
+	bytengine.RegisterCommandHandler("database.byteversions", DbByteVersions)
+	bytengine.RegisterCommandHandler("database.prunebytes", DbPruneBytes)
+	bytengine.RegisterCommandHandler("database.trash", DbTrash)
+	bytengine.RegisterCommandHandler("database.restore", DbRestore)
+	bytengine.RegisterCommandHandler("database.purge", DbPurge)
 }
@@ -4,10 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"os"
+	"time"
 
 	"github.com/johnwilson/bytengine"
 	"github.com/johnwilson/bytengine/auth"
+	"github.com/johnwilson/bytengine/bytestore"
 )
 
 const (
@@ -69,6 +70,18 @@ func UploadTicketHandler(cmd bytengine.Command, user *bytengine.User, eng *byten
 	val := map[string]string{
 		"database": db,
 		"path":     path,
+		"user":     user.Username, // uploader
+	}
+	// defaults for the upload
+	if name, ok := cmd.Args["filename"].(string); ok {
+		val["filename"] = name
+	}
+	if mt, ok := cmd.Args["mime"].(string); ok {
+		mt, err = bytestore.ParseMime(mt)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid mime type: %s", err)
+		}
+		val["mime"] = mt
 	}
 	b, err := json.Marshal(val)
 	if err != nil {
@@ -87,11 +100,17 @@ func UploadTicketHandler(cmd bytengine.Command, user *bytengine.User, eng *byten
 // handler for: writebytes
 func WritebytesHandler(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
 	ticket := cmd.Args["ticket"].(string)
-	tmpfile := cmd.Args["tmpfile"].(string)
+	r := cmd.Args["reader"].(io.Reader)
+	hint := bytengine.FileHint{Size: -1}
+	if size, ok := cmd.Args["size"].(int64); ok {
+		hint.Size = size
+	}
+	if ct, ok := cmd.Args["contenttype"].(string); ok {
+		hint.ContentType = ct
+	}
 	// get ticket
 	content, err := eng.StateStore.CacheGet(ticket)
 	if err != nil {
-		os.Remove(tmpfile)
 		err := fmt.Errorf("Invalid ticket")
 		return nil, err
 	}
@@ -99,18 +118,31 @@ func WritebytesHandler(cmd bytengine.Command, user *bytengine.User, eng *bytengi
 	var val struct {
 		Database string
 		Path     string
+		Filename string
+		Mime     string
+		User     string
 	}
 	b := []byte(content)
 	err = json.Unmarshal(b, &val)
 	if err != nil {
-		os.Remove(tmpfile)
 		err := fmt.Errorf("Ticket data invalid")
 		return nil, err
 	}
 
-	r, err := eng.FileSystem.WriteBytes(val.Path, tmpfile, val.Database)
-	os.Remove(tmpfile)
-	return r, err
+	// upload values take precedence over the ticket's
+	hint.Name = val.Filename
+	if name, ok := cmd.Args["filename"].(string); ok && name != "" {
+		hint.Name = name
+	}
+	hint.Mime = val.Mime
+	if mt, ok := cmd.Args["mime"].(string); ok && mt != "" {
+		hint.Mime, err = bytestore.ParseMime(mt)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid mime type: %s", err)
+		}
+	}
+
+	return eng.FileSystem.WriteBytes(val.Path, r, hint, val.Database, val.User)
 }
 
 // handler for: readbytes
@@ -124,13 +156,31 @@ func ReadbytesHandler(cmd bytengine.Command, user *bytengine.User, eng *bytengin
 	db := cmd.Database
 	w := cmd.Args["writer"].(io.Writer)
 	path := cmd.Args["path"].(string)
+
+	// previous attachment version
+	if version, ok := cmd.Args["version"].(int64); ok {
+		bstoreid, info, err := eng.FileSystem.ReadBytesVersion(path, db, version)
+		if err != nil {
+			return nil, err
+		}
+		c := attachmentInfo(info)
+		err = writeAttachment(cmd, eng, db, bstoreid, w, func() (bytengine.ContentInfo, error) {
+			return c, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return true, nil
+	}
+
 	bstoreid, err := eng.FileSystem.ReadBytes(path, db)
 	if err != nil {
 		return nil, err
 	}
 
-	// get file pointer
-	err = eng.ByteStore.Read(db, bstoreid, w)
+	err = writeAttachment(cmd, eng, db, bstoreid, w, func() (bytengine.ContentInfo, error) {
+		return contentInfo(eng, path, db, "bytes")
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -151,17 +201,91 @@ func DirecaccessHandler(cmd bytengine.Command, user *bytengine.User, eng *byteng
 
 	// json layer request
 	if content != nil {
+		fn, ok := cmd.Args["serve"].(bytengine.ServeFunc)
+		if !ok {
+			return content, nil
+		}
+		c, err := contentInfo(eng, path, db, "json")
+		if err != nil {
+			return nil, err
+		}
+		if _, _, ok := fn(c); !ok {
+			return nil, nil
+		}
 		return content, nil
 	}
 
 	// byte layer request
-	err = eng.ByteStore.Read(db, bstoreid, w)
+	err = writeAttachment(cmd, eng, db, bstoreid, w, func() (bytengine.ContentInfo, error) {
+		return contentInfo(eng, path, db, "bytes")
+	})
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+// contentInfo describes the json or bytes layer of the file using its info
+func contentInfo(eng *bytengine.Engine, path, db, layer string) (bytengine.ContentInfo, error) {
+	c := bytengine.ContentInfo{Size: -1, Mime: "application/json"}
+	info, err := eng.FileSystem.Info(path, db)
+	if err != nil {
+		return c, err
+	}
+	if layer == "bytes" {
+		info, _ = info["bytes"].(map[string]interface{})
+		return attachmentInfo(info), nil
+	}
+	c.Hash, _ = info["hash"].(string)
+	if val, ok := info["modified"].(string); ok {
+		c.Modified, _ = time.Parse(time.RFC3339, val)
+	}
+	return c, nil
+}
+
+// attachmentInfo describes an attachment using the 'bytes' info of its file
+func attachmentInfo(info map[string]interface{}) bytengine.ContentInfo {
+	c := bytengine.ContentInfo{Size: -1}
+	switch size := info["size"].(type) {
+	case int64:
+		c.Size = size
+	case int:
+		c.Size = int64(size)
+	case float64:
+		c.Size = int64(size)
+	}
+	c.Mime, _ = info["mime"].(string)
+	c.Filename, _ = info["filename"].(string)
+	c.Hash, _ = info["hash"].(string)
+	if val, ok := info["modified"].(string); ok {
+		c.Modified, _ = time.Parse(time.RFC3339, val)
+	}
+	return c
+}
+
+// writeAttachment writes the content of an attachment to w. If the command
+// has a 'serve' argument only the requested part is written and info is
+// called to describe the attachment.
+func writeAttachment(cmd bytengine.Command, eng *bytengine.Engine, db, bstoreid string, w io.Writer, info func() (bytengine.ContentInfo, error)) error {
+	fn, ok := cmd.Args["serve"].(bytengine.ServeFunc)
+	if !ok {
+		return eng.ByteStore.Read(db, bstoreid, w)
+	}
+
+	c, err := info()
+	if err != nil {
+		return err
+	}
+	offset, length, ok := fn(c)
+	if !ok {
+		return nil
+	}
+	if offset == 0 && length < 0 {
+		return eng.ByteStore.Read(db, bstoreid, w)
+	}
+	return eng.ByteStore.ReadRange(db, bstoreid, offset, length, w)
+}
+
 func init() {
 	bytengine.RegisterCommandHandler("login", LoginHandler)
 	bytengine.RegisterCommandHandler("uploadticket", UploadTicketHandler)
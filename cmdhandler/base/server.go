@@ -1,6 +1,9 @@
 package base
 
 import (
+	"sort"
+	"time"
+
 	"github.com/johnwilson/bytengine"
 )
 
@@ -37,9 +40,114 @@ func ServerDropDb(cmd bytengine.Command, user *bytengine.User, eng *bytengine.En
 	return true, nil
 }
 
+// gcGracePeriod is the age byte store items must reach before they are
+// considered orphans. Attachments are stored before the file makes
+// reference to them so recent items may still be in use.
+const gcGracePeriod = time.Hour
+
+// handler for: server.gc
+//
+// Orphans are byte store items which no file makes reference to anymore and
+// dangling files make reference to missing items. Orphans are removed unless
+// the 'dryrun' option is set, dangling files are only reported.
+func ServerGc(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	db := cmd.Args["database"].(string)
+	_, dryrun := cmd.Options["dryrun"]
+
+	// items are listed first so that attachments uploaded in the meantime
+	// are already referenced when the files are listed
+	items, err := eng.ByteStore.List(db)
+	if err != nil {
+		return nil, err
+	}
+	pointers, err := eng.FileSystem.ListBytes(db)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-gcGracePeriod)
+	orphans := []string{}
+	for id, modified := range items {
+		if _, ok := pointers[id]; !ok && modified.Before(cutoff) {
+			orphans = append(orphans, id)
+		}
+	}
+	dangling := []string{}
+	for id, paths := range pointers {
+		if _, ok := items[id]; !ok {
+			dangling = append(dangling, paths...)
+		}
+	}
+	sort.Strings(orphans)
+	sort.Strings(dangling)
+
+	removed := 0
+	if !dryrun {
+		for _, id := range orphans {
+			err = eng.ByteStore.Delete(db, id)
+			if err != nil {
+				return nil, err
+			}
+			removed++
+		}
+	}
+
+	val := map[string]interface{}{
+		"orphans":  orphans,
+		"removed":  removed,
+		"dangling": dangling,
+	}
+	return val, nil
+}
+
+// handler for: server.versioning
+//
+// Versioning is enabled or disabled if the 'enable' argument is set,
+// otherwise the current setting is returned.
+func ServerVersioning(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	db := cmd.Args["database"].(string)
+	enable, ok := cmd.Args["enable"].(bool)
+	if !ok {
+		return eng.FileSystem.Versioning(db)
+	}
+	if err := eng.FileSystem.SetVersioning(db, enable); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// handler for: server.trash
+//
+// The trash is enabled or disabled if the 'enable' argument is set,
+// otherwise the current setting is returned. Expiry is given in days.
+func ServerTrash(cmd bytengine.Command, user *bytengine.User, eng *bytengine.Engine) (interface{}, error) {
+	db := cmd.Args["database"].(string)
+	enable, ok := cmd.Args["enable"].(bool)
+	if !ok {
+		on, expiry, err := eng.FileSystem.Trash(db)
+		if err != nil {
+			return nil, err
+		}
+		days := int64(expiry / (24 * time.Hour))
+		return map[string]interface{}{"enabled": on, "expiry": days}, nil
+	}
+	var days int64
+	if val, ok := cmd.Options["expiry"]; ok {
+		days = val.(int64)
+	}
+	expiry := time.Duration(days) * 24 * time.Hour
+	if err := eng.FileSystem.SetTrash(db, enable, expiry); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
 func init() {
 	bytengine.RegisterCommandHandler("server.listdb", ServerListDb)
 	bytengine.RegisterCommandHandler("server.newdb", ServerNewDb)
 	bytengine.RegisterCommandHandler("server.init", ServerInit)
 	bytengine.RegisterCommandHandler("server.dropdb", ServerDropDb)
+	bytengine.RegisterCommandHandler("server.gc", ServerGc)
+	bytengine.RegisterCommandHandler("server.versioning", ServerVersioning)
+	bytengine.RegisterCommandHandler("server.trash", ServerTrash)
 }
@@ -0,0 +1,86 @@
+package bytengine
+
+// Condition is a node of the condition tree built from a BQL where
+// statement. Parsers produce it in Command.Args["where"] and every
+// FileSystem plugin translates it to its own query representation.
+//
+// Fields are dot separated paths in a bfs document: json content fields
+// start with 'content.' and file attributes with '__header__.' or
+// '__bytes__.' (e.g. 'content.name', '__bytes__.size').
+type Condition interface {
+	condition()
+}
+
+// Comparison operators
+const (
+	OpEqual        = "=="
+	OpNotEqual     = "!="
+	OpLesser       = "<"
+	OpLesserEqual  = "<="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+)
+
+// Value types supported by TypeOf conditions. Numbers without fractional
+// part are of type int.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+)
+
+// Comparison matches fields compared to a value with one of the comparison
+// operators. Fields holding arrays match if any element matches.
+type Comparison struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// In matches fields equal to one of the values or, if Negate is set, to
+// none of them.
+type In struct {
+	Field  string        `json:"field"`
+	Values []interface{} `json:"values"`
+	Negate bool          `json:"negate"`
+}
+
+// Exists matches documents which have (or don't have) the field
+type Exists struct {
+	Field  string `json:"field"`
+	Exists bool   `json:"exists"`
+}
+
+// TypeOf matches fields holding a value of the given type or, if Negate is
+// set, documents where the field doesn't hold a value of that type.
+type TypeOf struct {
+	Field  string `json:"field"`
+	Type   string `json:"type"`
+	Negate bool   `json:"negate"`
+}
+
+// Regex matches string fields against a regular expression. Options are
+// the letters 'i' (case insensitive), 'm' (multi line) and 's' (dot
+// matches new lines).
+type Regex struct {
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	Options string `json:"options"`
+}
+
+// And matches if all conditions match
+type And struct {
+	Conditions []Condition `json:"and"`
+}
+
+// Or matches if at least one condition matches
+type Or struct {
+	Conditions []Condition `json:"or"`
+}
+
+func (Comparison) condition() {}
+func (In) condition()         {}
+func (Exists) condition()     {}
+func (TypeOf) condition()     {}
+func (Regex) condition()      {}
+func (And) condition()        {}
+func (Or) condition()         {}
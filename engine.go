@@ -134,8 +134,121 @@ func (eng *Engine) Start(b []byte) error {
 	return nil
 }
 
+// commands delimiting the atomic blocks of a script
+const (
+	BeginCommand  = "begin"
+	CommitCommand = "commit"
+)
+
+// scriptBlock is a list of commands executed one after the other. Changes
+// made by the commands of an atomic block are all applied or none of them.
+type scriptBlock struct {
+	commands []Command
+	atomic   bool
+}
+
+// scriptBlocks splits the commands of a script into atomic and regular
+// blocks. All commands are in a single atomic block if atomic is set.
+func scriptBlocks(cmdlist []Command, atomic bool) ([]scriptBlock, error) {
+	blocks := []scriptBlock{}
+	current := scriptBlock{}
+	for _, cmd := range cmdlist {
+		switch cmd.Name {
+		case BeginCommand:
+			if current.atomic {
+				return nil, errors.New("atomic blocks can't be nested")
+			}
+			blocks = append(blocks, current)
+			current = scriptBlock{atomic: true}
+		case CommitCommand:
+			if !current.atomic {
+				return nil, errors.New("commit without begin")
+			}
+			blocks = append(blocks, current)
+			current = scriptBlock{}
+		default:
+			current.commands = append(current.commands, cmd)
+		}
+	}
+	if current.atomic {
+		return nil, errors.New("begin without commit")
+	}
+	blocks = append(blocks, current)
+
+	if atomic {
+		all := scriptBlock{atomic: true}
+		for _, b := range blocks {
+			all.commands = append(all.commands, b.commands...)
+		}
+		blocks = []scriptBlock{all}
+	}
+	return blocks, nil
+}
+
+// executeScriptCommand runs a command of a script. Variables in its
+// arguments are replaced by their values and the result is assigned to the
+// variable named by the command if any.
+func (eng *Engine) executeScriptCommand(cmd Command, user *User, vars map[string]interface{}) (interface{}, error) {
+	cmd, err := resolveCommand(cmd, vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := eng.execute(cmd, user)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.Assign != "" {
+		val, err := variableValue(r)
+		if err != nil {
+			return nil, err
+		}
+		vars[cmd.Assign] = val
+	}
+	return r, nil
+}
+
+// executeAtomic runs the commands with a file system which applies their
+// changes once all of them have succeeded
+func (eng *Engine) executeAtomic(cmdlist []Command, user *User, vars map[string]interface{}) ([]interface{}, error) {
+	// only the file system can undo changes
+	for _, cmd := range cmdlist {
+		if cmd.Database == "" {
+			return nil, fmt.Errorf("command '%s' can't be run atomically", cmd.Name)
+		}
+	}
+
+	var resultset []interface{}
+	err := eng.FileSystem.Atomic(func(fs FileSystem) error {
+		e := *eng
+		e.FileSystem = fs
+		resultset = []interface{}{}
+		for _, cmd := range cmdlist {
+			r, err := e.executeScriptCommand(cmd, user, vars)
+			if err != nil {
+				return err
+			}
+			resultset = append(resultset, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resultset, nil
+}
+
 // script is parsed into commands before execution
 func (eng *Engine) ExecuteScript(token, script string) (interface{}, error) {
+	return eng.executeScript(token, script, false)
+}
+
+// ExecuteAtomicScript runs all commands of the script as a single atomic
+// block: if one of them fails, changes made by the others are undone
+func (eng *Engine) ExecuteAtomicScript(token, script string) (interface{}, error) {
+	return eng.executeScript(token, script, true)
+}
+
+func (eng *Engine) executeScript(token, script string, atomic bool) (interface{}, error) {
 	// check user
 	user, err := eng.checkUser(token)
 	// check anonymous login
@@ -151,15 +264,33 @@ func (eng *Engine) ExecuteScript(token, script string) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	blocks, err := scriptBlocks(cmdlist, atomic)
+	if err != nil {
+		return nil, err
+	}
 
 	// execute command(s)
 	resultset := []interface{}{}
-	for _, cmd := range cmdlist {
-		r, err := eng.execute(cmd, user)
-		if err != nil {
-			return nil, err
+	vars := map[string]interface{}{}
+	for _, b := range blocks {
+		if b.atomic {
+			r, err := eng.executeAtomic(b.commands, user, vars)
+			if err != nil {
+				return nil, err
+			}
+			resultset = append(resultset, r...)
+			continue
+		}
+		for _, cmd := range b.commands {
+			r, err := eng.executeScriptCommand(cmd, user, vars)
+			if err != nil {
+				return nil, err
+			}
+			resultset = append(resultset, r)
 		}
-		resultset = append(resultset, r)
+	}
+	if len(resultset) == 0 {
+		return nil, errors.New("no command found")
 	}
 
 	if len(resultset) > 1 {
@@ -2,11 +2,33 @@ package bytengine
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"time"
 )
 
 var bfsPlugins = make(map[string]FileSystem)
 
+// AnyRevision disables the revision check of file updates and deletions
+const AnyRevision int64 = -1
+
+// RecursiveSuffix marks the directories of a BQL query whose files at any
+// depth are in scope, e.g. '/docs/**'
+const RecursiveSuffix = "/**"
+
+// ConflictError is returned when a file isn't at the revision expected by
+// an update or deletion, i.e. it has been changed by another client since
+// it was read.
+type ConflictError struct {
+	Path     string
+	Expected int64
+	Current  int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("revision conflict on '%s': expected revision %d but found %d", e.Path, e.Expected, e.Current)
+}
+
 type FileSystem interface {
 	Start(config string, b *ByteStore) error
 	ClearAll() ([]string, error)
@@ -14,10 +36,10 @@ type FileSystem interface {
 	CreateDatabase(db string) error
 	DropDatabase(db string) error
 	NewDir(p, db string) error
-	NewFile(p, db string, jsondata map[string]interface{}) error
+	NewFile(p, db string, jsondata map[string]interface{}, user string) error
 	ListDir(p, filter, db string) (map[string][]string, error)
-	ReadJson(p, db string, fields []string) (interface{}, error)
-	Delete(p, db string) error
+	ReadJson(p, db string, fields []string) (interface{}, int64, error)
+	Delete(p, db string, ifrev int64) error
 	Rename(p, newname, db string) error
 	Move(from, to, db string) error
 	Copy(from, to, db string) error
@@ -25,14 +47,32 @@ type FileSystem interface {
 	FileAccess(p, db string, protect bool) error
 	SetCounter(counter, action string, value int64, db string) (int64, error)
 	ListCounter(filter, db string) (map[string]int64, error)
-	WriteBytes(p, ap, db string) (int64, error)
+	WriteBytes(p string, r io.Reader, hint FileHint, db, user string) (int64, error)
 	ReadBytes(fp, db string) (string, error)
 	DirectAccess(fp, db, layer string) (map[string]interface{}, string, error)
 	DeleteBytes(p, db string) error
-	UpdateJson(p, db string, j map[string]interface{}) error
+	ListBytes(db string) (map[string][]string, error)
+	UpdateJson(p, db string, j map[string]interface{}, user string, ifrev int64) error
 	BQLSearch(db string, query map[string]interface{}) (interface{}, error)
-	BQLSet(db string, query map[string]interface{}) (int, error)
-	BQLUnset(db string, query map[string]interface{}) (int, error)
+	BQLSet(db string, query map[string]interface{}, user string) (int, error)
+	BQLUnset(db string, query map[string]interface{}, user string) (int, error)
+	BQLDelete(db string, query map[string]interface{}) ([]string, error)
+	Versioning(db string) (bool, error)
+	SetVersioning(db string, enabled bool) error
+	ListRevisions(p, db string) ([]map[string]interface{}, error)
+	ReadRevision(p, db string, rev int64, fields []string) (interface{}, error)
+	RevertJson(p, db string, rev int64, user string) error
+	ListBytesVersions(p, db string) ([]map[string]interface{}, error)
+	ReadBytesVersion(fp, db string, version int64) (string, map[string]interface{}, error)
+	PruneBytesVersions(p, db string, keep int64) (int, error)
+	Trash(db string) (bool, time.Duration, error)
+	SetTrash(db string, enabled bool, expiry time.Duration) error
+	ListTrash(db string) ([]map[string]interface{}, error)
+	RestoreTrash(id, to, db string) (string, error)
+	PurgeTrash(id, db string) (int, error)
+	// Atomic calls fn with a file system whose changes are all applied if
+	// fn returns nil and all discarded otherwise
+	Atomic(fn func(fs FileSystem) error) error
 }
 
 func RegisterFileSystem(name string, plugin FileSystem) {
@@ -0,0 +1,88 @@
+package filesystem
+
+import (
+	"errors"
+	"io"
+
+	"github.com/johnwilson/bytengine"
+)
+
+type storeItem struct {
+	db, id string
+}
+
+// DeferredStore is the byte store used by the file systems passed to the
+// function of FileSystem.Atomic. Deleted attachments are only removed by
+// Flush once all changes have been applied so that rolled back files keep
+// their content. Updates are stored as new attachments for the same reason.
+// Discard removes the attachments added in the meantime.
+type DeferredStore struct {
+	bytengine.ByteStore
+	added   []storeItem
+	deleted []storeItem
+}
+
+func NewDeferredStore(b bytengine.ByteStore) *DeferredStore {
+	return &DeferredStore{ByteStore: b}
+}
+
+func (d *DeferredStore) Add(db string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	info, err := d.ByteStore.Add(db, r, hint)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := info["name"].(string); ok {
+		d.added = append(d.added, storeItem{db, id})
+	}
+	return info, nil
+}
+
+// Update adds the content as a new attachment and deletes the previous one.
+// The 'name' of the new attachment is returned.
+func (d *DeferredStore) Update(db, id string, r io.Reader, hint bytengine.FileHint) (map[string]interface{}, error) {
+	info, err := d.Add(db, r, hint)
+	if err != nil {
+		return nil, err
+	}
+	return info, d.Delete(db, id)
+}
+
+func (d *DeferredStore) Delete(db, id string) error {
+	item := storeItem{db, id}
+	for _, deleted := range d.deleted {
+		if deleted == item {
+			return nil
+		}
+	}
+	d.deleted = append(d.deleted, item)
+	return nil
+}
+
+// DropDatabase fails as dropped content can't be restored
+func (d *DeferredStore) DropDatabase(db string) error {
+	return errors.New("databases can't be dropped in an atomic script")
+}
+
+// Flush removes the deleted attachments
+func (d *DeferredStore) Flush() error {
+	for _, item := range d.deleted {
+		err := d.ByteStore.Delete(item.db, item.id)
+		if err != nil {
+			return err
+		}
+	}
+	d.added, d.deleted = nil, nil
+	return nil
+}
+
+// Discard removes the added attachments
+func (d *DeferredStore) Discard() error {
+	for _, item := range d.added {
+		err := d.ByteStore.Delete(item.db, item.id)
+		if err != nil {
+			return err
+		}
+	}
+	d.added, d.deleted = nil, nil
+	return nil
+}
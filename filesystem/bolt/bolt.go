@@ -0,0 +1,441 @@
+// Package bolt provides a file system plugin that stores all databases in a
+// single file using the embedded bbolt key/value store. It doesn't require
+// any external service which makes it suitable for small deployments.
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/filesystem/docfs"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	nodesBucket    = []byte("nodes")
+	pathsBucket    = []byte("paths")
+	countersBucket = []byte("counters")
+	recordsBucket  = []byte("records")
+)
+
+type Config struct {
+	Path    string        `json:"path"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// pathKey builds the key used to find a node by its parent and name.
+// Keys of nodes in the same directory share the same prefix and are sorted
+// by name.
+func pathKey(parent, name string) []byte {
+	return []byte(parent + "\x00" + name)
+}
+
+// Backend stores every database in its own top level bucket with nested
+// buckets for nodes, the path index, counters and record collections.
+type Backend struct {
+	mu   sync.Mutex
+	path string
+	DB   *bolt.DB
+}
+
+func NewBackend() *Backend {
+	return &Backend{}
+}
+
+func (b *Backend) Start(config string) error {
+	var c Config
+	err := json.Unmarshal([]byte(config), &c)
+	if err != nil {
+		return err
+	}
+	if len(c.Path) == 0 {
+		return errors.New("bolt: database file path required")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the plugin is started by every worker so the open database is reused
+	if b.DB != nil {
+		if b.path == c.Path {
+			return nil
+		}
+		b.DB.Close()
+		b.DB = nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.Path), 0755)
+	if err != nil {
+		return err
+	}
+	opts := &bolt.Options{Timeout: c.Timeout * time.Second}
+	db, err := bolt.Open(c.Path, 0600, opts)
+	if err != nil {
+		return err
+	}
+	b.DB = db
+	b.path = c.Path
+	return nil
+}
+
+func (b *Backend) View(fn func(tx docfs.Tx) error) error {
+	return b.DB.View(func(btx *bolt.Tx) error {
+		return fn(&tx{btx})
+	})
+}
+
+func (b *Backend) Update(fn func(tx docfs.Tx) error) error {
+	return b.DB.Update(func(btx *bolt.Tx) error {
+		return fn(&tx{btx})
+	})
+}
+
+type tx struct {
+	btx *bolt.Tx
+}
+
+func (t *tx) bucket(db string, name []byte) (*bolt.Bucket, error) {
+	d := t.btx.Bucket([]byte(db))
+	if d == nil {
+		return nil, docfs.ErrNotFound
+	}
+	return d.Bucket(name), nil
+}
+
+// collection returns the bucket of a record collection which is nil if
+// nothing was stored in it yet
+func (t *tx) collection(db, name string) (*bolt.Bucket, error) {
+	records, err := t.bucket(db, recordsBucket)
+	if err != nil || records == nil {
+		return nil, err
+	}
+	return records.Bucket([]byte(name)), nil
+}
+
+func decodeNode(data []byte) (*docfs.Node, error) {
+	var n docfs.Node
+	err := json.Unmarshal(data, &n)
+	if err != nil {
+		return nil, err
+	}
+	// empty json content is omitted when encoded
+	if !n.IsDir() && n.Content == nil {
+		n.Content = map[string]interface{}{}
+	}
+	return &n, nil
+}
+
+// scan decodes the nodes referenced by all path keys starting with prefix
+func (t *tx) scan(db string, prefix []byte, fn func(n *docfs.Node) error) error {
+	paths, err := t.bucket(db, pathsBucket)
+	if err != nil {
+		return err
+	}
+	nodes, err := t.bucket(db, nodesBucket)
+	if err != nil {
+		return err
+	}
+	c := paths.Cursor()
+	for k, id := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, id = c.Next() {
+		data := nodes.Get(id)
+		if data == nil {
+			continue
+		}
+		n, err := decodeNode(data)
+		if err != nil {
+			return err
+		}
+		err = fn(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tx) Databases() ([]string, error) {
+	list := []string{}
+	err := t.btx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		list = append(list, string(name))
+		return nil
+	})
+	return list, err
+}
+
+func (t *tx) HasDatabase(db string) (bool, error) {
+	return t.btx.Bucket([]byte(db)) != nil, nil
+}
+
+func (t *tx) CreateDatabase(db string) error {
+	d, err := t.btx.CreateBucketIfNotExists([]byte(db))
+	if err != nil {
+		return err
+	}
+	for _, name := range [][]byte{nodesBucket, pathsBucket, countersBucket, recordsBucket} {
+		_, err = d.CreateBucketIfNotExists(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tx) DropDatabase(db string) error {
+	err := t.btx.DeleteBucket([]byte(db))
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+	return err
+}
+
+func (t *tx) Node(db, id string) (*docfs.Node, error) {
+	nodes, err := t.bucket(db, nodesBucket)
+	if err != nil {
+		return nil, err
+	}
+	data := nodes.Get([]byte(id))
+	if data == nil {
+		return nil, docfs.ErrNotFound
+	}
+	return decodeNode(data)
+}
+
+func (t *tx) Lookup(db, parent, name string) (*docfs.Node, error) {
+	paths, err := t.bucket(db, pathsBucket)
+	if err != nil {
+		return nil, err
+	}
+	id := paths.Get(pathKey(parent, name))
+	if id == nil {
+		return nil, docfs.ErrNotFound
+	}
+	return t.Node(db, string(id))
+}
+
+func (t *tx) Children(db, parent string) ([]*docfs.Node, error) {
+	list := []*docfs.Node{}
+	err := t.scan(db, pathKey(parent, ""), func(n *docfs.Node) error {
+		list = append(list, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (t *tx) Descendants(db, p string) ([]*docfs.Node, error) {
+	list := []*docfs.Node{}
+	add := func(n *docfs.Node) error {
+		list = append(list, n)
+		return nil
+	}
+
+	p = path.Clean(p)
+	if p == "/" {
+		// every node except the root directory has a parent starting with "/"
+		err := t.scan(db, []byte("/"), add)
+		if err != nil {
+			return nil, err
+		}
+		return list, nil
+	}
+
+	// direct children followed by nodes in sub directories
+	err := t.scan(db, pathKey(p, ""), add)
+	if err != nil {
+		return nil, err
+	}
+	err = t.scan(db, []byte(p+"/"), add)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (t *tx) Walk(db string, fn func(n *docfs.Node) error) error {
+	nodes, err := t.bucket(db, nodesBucket)
+	if err != nil {
+		return err
+	}
+	return nodes.ForEach(func(_, data []byte) error {
+		n, err := decodeNode(data)
+		if err != nil {
+			return err
+		}
+		return fn(n)
+	})
+}
+
+func (t *tx) Put(db string, n *docfs.Node) error {
+	nodes, err := t.bucket(db, nodesBucket)
+	if err != nil {
+		return err
+	}
+	paths, err := t.bucket(db, pathsBucket)
+	if err != nil {
+		return err
+	}
+
+	id := []byte(n.Id)
+	if data := nodes.Get(id); data != nil {
+		old, err := decodeNode(data)
+		if err != nil {
+			return err
+		}
+		err = paths.Delete(pathKey(old.Header.Parent, old.Header.Name))
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	err = nodes.Put(id, data)
+	if err != nil {
+		return err
+	}
+	return paths.Put(pathKey(n.Header.Parent, n.Header.Name), id)
+}
+
+func (t *tx) Remove(db, id string) error {
+	nodes, err := t.bucket(db, nodesBucket)
+	if err != nil {
+		return err
+	}
+	paths, err := t.bucket(db, pathsBucket)
+	if err != nil {
+		return err
+	}
+
+	data := nodes.Get([]byte(id))
+	if data == nil {
+		return nil
+	}
+	old, err := decodeNode(data)
+	if err != nil {
+		return err
+	}
+	err = paths.Delete(pathKey(old.Header.Parent, old.Header.Name))
+	if err != nil {
+		return err
+	}
+	return nodes.Delete([]byte(id))
+}
+
+func (t *tx) Counter(db, name string) (int64, bool, error) {
+	counters, err := t.bucket(db, countersBucket)
+	if err != nil {
+		return 0, false, err
+	}
+	data := counters.Get([]byte(name))
+	if data == nil {
+		return 0, false, nil
+	}
+	v, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return v, true, nil
+}
+
+func (t *tx) PutCounter(db, name string, value int64) error {
+	counters, err := t.bucket(db, countersBucket)
+	if err != nil {
+		return err
+	}
+	return counters.Put([]byte(name), []byte(strconv.FormatInt(value, 10)))
+}
+
+func (t *tx) Counters(db string) (map[string]int64, error) {
+	counters, err := t.bucket(db, countersBucket)
+	if err != nil {
+		return nil, err
+	}
+	list := map[string]int64{}
+	err = counters.ForEach(func(k, data []byte) error {
+		v, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return err
+		}
+		list[string(k)] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (t *tx) Record(db, collection, key string) ([]byte, error) {
+	c, err := t.collection(db, collection)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, docfs.ErrNotFound
+	}
+	data := c.Get([]byte(key))
+	if data == nil {
+		return nil, docfs.ErrNotFound
+	}
+	// values are only valid for the life of the transaction
+	return append([]byte(nil), data...), nil
+}
+
+func (t *tx) PutRecord(db, collection, key string, value []byte) error {
+	records, err := t.bucket(db, recordsBucket)
+	if err != nil {
+		return err
+	}
+	if records == nil {
+		return docfs.ErrNotFound
+	}
+	c, err := records.CreateBucketIfNotExists([]byte(collection))
+	if err != nil {
+		return err
+	}
+	return c.Put([]byte(key), value)
+}
+
+func (t *tx) RemoveRecord(db, collection, key string) error {
+	c, err := t.collection(db, collection)
+	if err != nil || c == nil {
+		return err
+	}
+	return c.Delete([]byte(key))
+}
+
+func (t *tx) Records(db, collection, prefix string, fn func(key string, value []byte) error) error {
+	c, err := t.collection(db, collection)
+	if err != nil || c == nil {
+		return err
+	}
+	p := []byte(prefix)
+	cur := c.Cursor()
+	for k, v := cur.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = cur.Next() {
+		err = fn(string(k), append([]byte(nil), v...))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewFileSystem() *docfs.FileSystem {
+	return docfs.NewFileSystem(NewBackend())
+}
+
+func init() {
+	bytengine.RegisterFileSystem("bolt", NewFileSystem())
+}
@@ -0,0 +1,67 @@
+package bolt
+
+import (
+	"os"
+	"testing"
+
+	"github.com/johnwilson/bytengine"
+	_ "github.com/johnwilson/bytengine/bytestore/diskv"
+	"github.com/johnwilson/bytengine/filesystem/docfs"
+	"github.com/johnwilson/bytengine/filesystem/filesystemtest"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	BFS_CONFIG    = `{"path":"/tmp/bytengine_bolt_test/bfs.db"}`
+	BSTORE_CONFIG = `
+    {
+        "rootdir":"/tmp/diskv_bolt_data",
+        "cachesize": 1
+    }`
+)
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) (bytengine.FileSystem, bytengine.ByteStore) {
+		bstore, err := bytengine.NewByteStore("diskv", BSTORE_CONFIG)
+		assert.Nil(t, err, "bst not created")
+		bfs, err := bytengine.NewFileSystem("bolt", BFS_CONFIG, &bstore)
+		assert.Nil(t, err, "bfs not created")
+		return bfs, bstore
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	bstore, err := bytengine.NewByteStore("diskv", BSTORE_CONFIG)
+	assert.Nil(t, err, "bst not created")
+
+	config := `{"path":"/tmp/bytengine_bolt_test/persist.db"}`
+	os.Remove("/tmp/bytengine_bolt_test/persist.db")
+
+	// write content and close the database file
+	b1 := NewBackend()
+	fs1 := docfs.NewFileSystem(b1)
+	err = fs1.Start(config, &bstore)
+	assert.Nil(t, err, "bfs not started")
+	err = fs1.CreateDatabase("db1")
+	assert.Nil(t, err, "db1 not created")
+	err = fs1.NewDir("/var", "db1")
+	assert.Nil(t, err, "directory not created")
+	err = fs1.NewFile("/var/index.html", "db1", map[string]interface{}{"title": "welcome"}, "test")
+	assert.Nil(t, err, "file not created")
+	b1.DB.Close()
+
+	// reopen and check content
+	b2 := NewBackend()
+	fs2 := docfs.NewFileSystem(b2)
+	err = fs2.Start(config, &bstore)
+	assert.Nil(t, err, "bfs not restarted")
+	defer b2.DB.Close()
+
+	list, err := fs2.ListDir("/var", "", "db1")
+	assert.Nil(t, err, "directory listing failed")
+	assert.Equal(t, []string{"index.html"}, list["files"], "file not persisted")
+
+	val, _, err := fs2.ReadJson("/var/index.html", "db1", []string{"title"})
+	assert.Nil(t, err, "read json failed")
+	assert.Equal(t, "welcome", val.(map[string]interface{})["title"], "content not persisted")
+}
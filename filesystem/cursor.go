@@ -0,0 +1,109 @@
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+var errInvalidCursor = errors.New("Invalid select query: cursor isn't valid.")
+
+// cursor is the position of the next page of a select query. The query
+// hash prevents a cursor from being used with another query.
+type cursor struct {
+	Offset int64  `json:"o"`
+	Query  string `json:"q"`
+}
+
+// queryHash identifies the results of a select query independently of
+// the page requested
+func queryHash(query map[string]interface{}) (string, error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"fields": query["fields"],
+		"dirs":   query["dirs"],
+		"where":  query["where"],
+		"sort":   query["sort"],
+		"limit":  query["limit"],
+	})
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:8]), nil
+}
+
+func encodeCursor(query map[string]interface{}, offset int64) (string, error) {
+	h, err := queryHash(query)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(cursor{offset, h})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(query map[string]interface{}, s string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	var c cursor
+	if err = json.Unmarshal(b, &c); err != nil || c.Offset < 0 {
+		return 0, errInvalidCursor
+	}
+	h, err := queryHash(query)
+	if err != nil {
+		return 0, err
+	}
+	if c.Query != h {
+		return 0, errors.New("Invalid select query: cursor belongs to another query.")
+	}
+	return c.Offset, nil
+}
+
+// PageOffset returns the number of results a select query skips and
+// whether it pages through the results with cursors. The first page is
+// requested with an empty cursor.
+func PageOffset(query map[string]interface{}) (int64, bool, error) {
+	val, hascursor := query["cursor"]
+	if !hascursor {
+		offset, _ := query["offset"].(int64)
+		if offset < 0 {
+			return 0, false, errors.New("Invalid select query: offset can't be negative.")
+		}
+		return offset, false, nil
+	}
+	if limit, haslimit := query["limit"].(int64); !haslimit || limit <= 0 {
+		return 0, false, errors.New("Invalid select query: cursor requires a positive limit.")
+	}
+	s, ok := val.(string)
+	if !ok {
+		return 0, false, errInvalidCursor
+	}
+	if s == "" {
+		return 0, true, nil
+	}
+	offset, err := decodeCursor(query, s)
+	return offset, true, err
+}
+
+// PageResult returns the results of a select query which pages with
+// cursors. items holds the results of the page starting at offset followed
+// by at most one more result if the query has more pages.
+func PageResult(query map[string]interface{}, items []interface{}, offset int64) (interface{}, error) {
+	limit := query["limit"].(int64)
+	rep := map[string]interface{}{"results": items, "cursor": nil}
+	if int64(len(items)) > limit {
+		next, err := encodeCursor(query, offset+limit)
+		if err != nil {
+			return nil, err
+		}
+		rep["results"] = items[:limit]
+		rep["cursor"] = next
+	}
+	return rep, nil
+}
@@ -0,0 +1,98 @@
+package docfs
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/johnwilson/bytengine"
+)
+
+// group is a row of the result of a group query
+type group struct {
+	values []interface{} // values of the group fields
+	docs   []map[string]interface{}
+}
+
+// aggregate computes an aggregate function over the documents of a group
+func aggregate(docs []map[string]interface{}, a bytengine.Aggregate) interface{} {
+	var sum float64
+	var count int
+	var result interface{}
+	for _, doc := range docs {
+		val, ok := lookup(doc, a.Field)
+		if !ok || val == nil {
+			continue
+		}
+		switch a.Func {
+		case bytengine.AggSum, bytengine.AggAvg:
+			if n, isNumber := toNumber(val); isNumber {
+				sum += n
+				count++
+			}
+		case bytengine.AggMin:
+			if result == nil || compareValues(val, result) < 0 {
+				result = val
+			}
+		case bytengine.AggMax:
+			if result == nil || compareValues(val, result) > 0 {
+				result = val
+			}
+		}
+	}
+	switch a.Func {
+	case bytengine.AggSum:
+		return sum
+	case bytengine.AggAvg:
+		if count == 0 {
+			return nil
+		}
+		return sum / float64(count)
+	}
+	return result
+}
+
+// groupDocuments returns one row per distinct combination of values of the
+// group fields, ordered by these values
+func groupDocuments(docs []map[string]interface{}, fields []bytengine.GroupField, aggregates []bytengine.Aggregate) ([]interface{}, error) {
+	groups := []*group{}
+	index := map[string]*group{}
+	for _, doc := range docs {
+		values := make([]interface{}, len(fields))
+		for i, f := range fields {
+			values[i], _ = lookup(doc, f.Field)
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := index[string(b)]
+		if !ok {
+			g = &group{values: values}
+			index[string(b)] = g
+			groups = append(groups, g)
+		}
+		g.docs = append(g.docs, doc)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		for k := range fields {
+			if c := compareValues(groups[i].values[k], groups[j].values[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	rows := []interface{}{}
+	for _, g := range groups {
+		key := map[string]interface{}{}
+		for i, f := range fields {
+			key[f.Name] = g.values[i]
+		}
+		row := map[string]interface{}{"group": key, "count": len(g.docs)}
+		for _, a := range aggregates {
+			row[a.Name] = aggregate(g.docs, a)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -0,0 +1,43 @@
+package docfs
+
+import (
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/filesystem"
+)
+
+// txBackend runs all transactions of an atomic file system in the update
+// transaction of the Atomic call
+type txBackend struct {
+	tx Tx
+}
+
+func (b txBackend) Start(config string) error {
+	return nil
+}
+
+func (b txBackend) View(fn func(tx Tx) error) error {
+	return fn(b.tx)
+}
+
+func (b txBackend) Update(fn func(tx Tx) error) error {
+	return fn(b.tx)
+}
+
+/*
+============================================================================
+    BFS Interface Methods
+============================================================================
+*/
+
+func (f *FileSystem) Atomic(fn func(fs bytengine.FileSystem) error) error {
+	store := filesystem.NewDeferredStore(f.bstore)
+	// other engines wait until the changes are applied or discarded
+	err := f.backend.Update(func(tx Tx) error {
+		return fn(&FileSystem{backend: txBackend{tx}, bstore: store})
+	})
+	if err != nil {
+		store.Discard()
+		return err
+	}
+	return store.Flush()
+}
@@ -0,0 +1,137 @@
+package docfs
+
+import (
+	"errors"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// BFS Node Header
+type NodeHeader struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	IsPublic bool   `json:"ispublic"`
+	Created  string `json:"created"`
+	Parent   string `json:"parent"`
+	Modified string `json:"modified,omitempty"` // last json content change
+	Revision int64  `json:"revision,omitempty"` // number of json content changes
+}
+
+// BFS Bytes Header
+type BytesHeader struct {
+	Filepointer string `json:"filepointer"`
+	Mime        string `json:"mime"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash,omitempty"`
+	Modified    string `json:"modified,omitempty"` // last bytes upload
+	Filename    string `json:"filename,omitempty"` // original upload name
+	User        string `json:"user,omitempty"`     // uploader
+}
+
+// BFS Node (directory or file)
+type Node struct {
+	Header  NodeHeader             `json:"__header__"`
+	AHeader BytesHeader            `json:"__bytes__"`
+	Id      string                 `json:"_id"`
+	Content map[string]interface{} `json:"content,omitempty"`
+}
+
+func (n *Node) IsDir() bool {
+	return n.Header.Type == "Directory"
+}
+
+// LastModified returns when the json content was last changed. Nodes
+// stored before the time was recorded report their creation time.
+func (n *Node) LastModified() string {
+	if n.Header.Modified == "" {
+		return n.Header.Created
+	}
+	return n.Header.Modified
+}
+
+// Path returns the absolute bfs path of the node
+func (n *Node) Path() string {
+	if n.Header.Parent == "" {
+		return "/"
+	}
+	if n.Header.Parent == "/" {
+		return "/" + n.Header.Name
+	}
+	return n.Header.Parent + "/" + n.Header.Name
+}
+
+// Copy returns a deep copy of the node
+func (n *Node) Copy() *Node {
+	c := *n
+	if n.Content != nil {
+		c.Content = copyValue(n.Content).(map[string]interface{})
+	}
+	return &c
+}
+
+// Tx gives access to the nodes, counters and records of all databases.
+// Nodes returned by a Tx are copies and changes are only stored with Put.
+// Records are encoded values kept in named collections of a database such
+// as its settings or the history of its files.
+type Tx interface {
+	Databases() ([]string, error)
+	HasDatabase(db string) (bool, error)
+	CreateDatabase(db string) error
+	DropDatabase(db string) error
+
+	// Node returns ErrNotFound if the id doesn't exist
+	Node(db, id string) (*Node, error)
+	// Lookup returns ErrNotFound if no node has the given parent and name
+	Lookup(db, parent, name string) (*Node, error)
+	// Children returns the direct children of parent sorted by name
+	Children(db, parent string) ([]*Node, error)
+	// Descendants returns all nodes below the directory p
+	Descendants(db, p string) ([]*Node, error)
+	// Walk calls fn for every node in the database
+	Walk(db string, fn func(n *Node) error) error
+	// Put inserts or replaces the node with the same id
+	Put(db string, n *Node) error
+	Remove(db, id string) error
+
+	Counter(db, name string) (int64, bool, error)
+	PutCounter(db, name string, value int64) error
+	Counters(db string) (map[string]int64, error)
+
+	// Record returns ErrNotFound if the key doesn't exist in the collection
+	Record(db, collection, key string) ([]byte, error)
+	// PutRecord inserts or replaces the record with the same key
+	PutRecord(db, collection, key string, value []byte) error
+	RemoveRecord(db, collection, key string) error
+	// Records calls fn for every record of the collection whose key starts
+	// with prefix in key order
+	Records(db, collection, prefix string, fn func(key string, value []byte) error) error
+}
+
+// Backend stores the nodes used by the document file system.
+// Update must apply all changes made in fn or none of them if fn returns
+// an error.
+type Backend interface {
+	Start(config string) error
+	View(fn func(tx Tx) error) error
+	Update(fn func(tx Tx) error) error
+}
+
+// copyValue makes a deep copy of json like values
+func copyValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k] = copyValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, item := range val {
+			l[i] = copyValue(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -0,0 +1,1450 @@
+// Package docfs implements the bytengine file system on top of a simple
+// node storage backend so that embedded backends only have to provide
+// storage and indexing.
+package docfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/filesystem"
+)
+
+type FileSystem struct {
+	backend Backend
+	bstore  bytengine.ByteStore
+	mu      sync.Mutex
+}
+
+func NewFileSystem(b Backend) *FileSystem {
+	return &FileSystem{backend: refBackend{b}}
+}
+
+/*
+============================================================================
+    Private Methods
+============================================================================
+*/
+
+func newNode(name, typ, parent string) (*Node, error) {
+	id, err := filesystem.NewNodeID()
+	if err != nil {
+		return nil, err
+	}
+	dt := filesystem.FormatDatetime(time.Now())
+	n := &Node{
+		Header: NodeHeader{name, typ, false, dt, parent, dt, 0},
+		Id:     id,
+	}
+	return n, nil
+}
+
+func splitPath(p string) (parent, name string) {
+	if p == "/" {
+		return "", "/"
+	}
+	return path.Dir(p), path.Base(p)
+}
+
+// isSubPath checks if p is equal to or located under dir
+func isSubPath(p, dir string) bool {
+	if dir == "/" {
+		return true
+	}
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// replacePrefix moves a path from the oldprefix directory to newprefix
+func replacePrefix(p, oldprefix, newprefix string) string {
+	if oldprefix == "/" {
+		return path.Join(newprefix, p)
+	}
+	return newprefix + strings.TrimPrefix(p, oldprefix)
+}
+
+// checkRevision fails with a conflict error if the file n isn't at the
+// expected revision
+func checkRevision(n *Node, ifrev int64) error {
+	if ifrev == bytengine.AnyRevision || n.Header.Revision == ifrev {
+		return nil
+	}
+	return &bytengine.ConflictError{Path: n.Path(), Expected: ifrev, Current: n.Header.Revision}
+}
+
+func checkDatabase(tx Tx, db string) error {
+	ok, err := tx.HasDatabase(db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("database '%s' doesn't exist", db)
+	}
+	return nil
+}
+
+func findPath(tx Tx, p, db string) (*Node, error) {
+	if err := checkDatabase(tx, db); err != nil {
+		return nil, err
+	}
+	parent, name := splitPath(p)
+	n, err := tx.Lookup(db, parent, name)
+	if err == ErrNotFound {
+		return nil, fmt.Errorf("'%s' doesn't exist", p)
+	}
+	return n, err
+}
+
+func findFile(tx Tx, p, db string) (*Node, error) {
+	n, err := findPath(tx, p, db)
+	if err != nil {
+		return nil, err
+	}
+	if n.IsDir() {
+		return nil, errors.New("command only valid for files.")
+	}
+	return n, nil
+}
+
+// findDirectory returns the destination directory for a new node
+func findDirectory(tx Tx, p, db string) (*Node, error) {
+	n, err := findPath(tx, p, db)
+	if err != nil {
+		return nil, fmt.Errorf("destination directory not found: %s", err)
+	}
+	if !n.IsDir() {
+		return nil, errors.New("destination isn't a directory")
+	}
+	return n, nil
+}
+
+func pathExists(tx Tx, p, db string) (bool, error) {
+	parent, name := splitPath(p)
+	_, err := tx.Lookup(db, parent, name)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// walkAttachments calls fn for every reference to an attachment made by a
+// file, a replaced attachment version or a file in the trash. ref is the
+// path of the file followed by ' (version n)' or ' (trash)' if needed.
+func walkAttachments(tx Tx, db string, fn func(pointer, ref string) error) error {
+	paths := map[string]string{} // node id -> path
+	err := tx.Walk(db, func(n *Node) error {
+		paths[n.Id] = n.Path()
+		if n.AHeader.Filepointer == "" {
+			return nil
+		}
+		return fn(n.AHeader.Filepointer, n.Path())
+	})
+	if err != nil {
+		return err
+	}
+	err = walkTrash(tx, db, func(item *TrashItem) error {
+		for _, n := range item.Nodes {
+			paths[n.Id] = n.Path()
+			if n.AHeader.Filepointer == "" {
+				continue
+			}
+			err := fn(n.AHeader.Filepointer, n.Path()+" (trash)")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return walkBytesVersions(tx, db, func(node string, v *BytesVersion) error {
+		return fn(v.Bytes.Filepointer, fmt.Sprintf("%s (version %d)", paths[node], v.Version))
+	})
+}
+
+// filesInDirs returns the files located directly in the given directories,
+// or anywhere below recursive ones such as '/docs/**', that match the where
+// statement
+func filesInDirs(tx Tx, db string, dirs []string, where bytengine.Condition) ([]*Node, error) {
+	if err := checkDatabase(tx, db); err != nil {
+		return nil, err
+	}
+	found := []*Node{}
+	seen := map[string]bool{}
+	add := func(list []*Node) error {
+		for _, n := range list {
+			if n.IsDir() || seen[n.Id] {
+				continue
+			}
+			seen[n.Id] = true
+			ok, err := match(document(n), where)
+			if err != nil {
+				return err
+			}
+			if ok {
+				found = append(found, n)
+			}
+		}
+		return nil
+	}
+
+	direct, recursive := filesystem.ScopeDirs(dirs)
+	for _, dir := range direct {
+		list, err := tx.Children(db, dir)
+		if err != nil {
+			return nil, err
+		}
+		if err = add(list); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range recursive {
+		list, err := tx.Descendants(db, dir)
+		if err != nil {
+			return nil, err
+		}
+		// files ordered by path as for directory listings
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Header.Parent != list[j].Header.Parent {
+				return list[i].Header.Parent < list[j].Header.Parent
+			}
+			return list[i].Header.Name < list[j].Header.Name
+		})
+		if err = add(list); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// removeNode deletes the node n located at p and, for directories, all
+// nodes below it. They are moved to the trash if it is enabled, otherwise
+// the pointers of the attachments to release are returned.
+func removeNode(tx Tx, db, p string, n *Node) ([]string, error) {
+	nodes := []*Node{n}
+	if n.IsDir() {
+		children, err := tx.Descendants(db, p)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, children...)
+	}
+	on, _, err := trashSettings(tx, db)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range nodes {
+		err = tx.Remove(db, item.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if on {
+		// history and attachments are kept until the item is purged
+		return nil, putTrashItem(tx, db, &TrashItem{n.Id, p, filesystem.FormatDatetime(time.Now()), nodes})
+	}
+	attachments := []string{}
+	for _, item := range nodes {
+		if item.AHeader.Filepointer != "" {
+			attachments = append(attachments, item.AHeader.Filepointer)
+		}
+		if !item.IsDir() {
+			pointers, err := removeHistory(tx, db, item.Id)
+			if err != nil {
+				return nil, err
+			}
+			attachments = append(attachments, pointers...)
+		}
+	}
+	return attachments, nil
+}
+
+/*
+============================================================================
+    BFS Interface Methods
+============================================================================
+*/
+
+func (f *FileSystem) Start(config string, b *bytengine.ByteStore) error {
+	// plugin instances are shared by all engines in the process
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.backend.Start(config)
+	if err != nil {
+		return err
+	}
+	f.bstore = *b
+	return nil
+}
+
+func (f *FileSystem) ClearAll() ([]string, error) {
+	found := make([]string, 0)
+
+	err := f.backend.Update(func(tx Tx) error {
+		dbs, err := tx.Databases()
+		if err != nil {
+			return err
+		}
+		for _, db := range dbs {
+			err = tx.DropDatabase(db)
+			if err != nil {
+				return err
+			}
+			found = append(found, db)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// drop databases from bytestore
+	for _, db := range found {
+		err = f.bstore.DropDatabase(db)
+		if err != nil {
+			return found, err
+		}
+	}
+
+	return found, nil
+}
+
+func (f *FileSystem) ListDatabase(filter string) ([]string, error) {
+	found := make([]string, 0)
+
+	r, err := regexp.Compile(filter)
+	if err != nil {
+		return found, err
+	}
+
+	var dbs []string
+	err = f.backend.View(func(tx Tx) error {
+		dbs, err = tx.Databases()
+		return err
+	})
+	if err != nil {
+		return found, err
+	}
+
+	for _, db := range dbs {
+		if r.MatchString(db) {
+			found = append(found, db)
+		}
+	}
+	sort.Strings(found)
+
+	return found, nil
+}
+
+func (f *FileSystem) CreateDatabase(db string) error {
+	err := filesystem.ValidateDbName(db)
+	if err != nil {
+		return err
+	}
+
+	// create root node
+	rn, err := newNode("/", "Directory", "")
+	if err != nil {
+		return err
+	}
+	rn.Header.IsPublic = true
+
+	return f.backend.Update(func(tx Tx) error {
+		ok, err := tx.HasDatabase(db)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("database '%s' already exists", db)
+		}
+		err = tx.CreateDatabase(db)
+		if err != nil {
+			return err
+		}
+		return tx.Put(db, rn)
+	})
+}
+
+func (f *FileSystem) DropDatabase(db string) error {
+	err := f.backend.Update(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		return tx.DropDatabase(db)
+	})
+	if err != nil {
+		return err
+	}
+
+	// drop database from bst
+	return f.bstore.DropDatabase(db)
+}
+
+func (f *FileSystem) NewDir(p, db string) error {
+	// check path
+	p = path.Clean(p)
+	if p == "/" {
+		return errors.New("root directory already exists")
+	}
+	parent, name := splitPath(p)
+	err := filesystem.ValidateDirName(name)
+	if err != nil {
+		return err
+	}
+	dir, err := newNode(name, "Directory", parent)
+	if err != nil {
+		return err
+	}
+
+	return f.backend.Update(func(tx Tx) error {
+		pdir, err := findPath(tx, parent, db)
+		if err != nil {
+			return err
+		}
+		if !pdir.IsDir() {
+			return fmt.Errorf("directory '%s' couldn't be created: destination isn't a directory.", p)
+		}
+		// check if name already taken
+		exists, err := pathExists(tx, p, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("directory '%s' already exists", p)
+		}
+		return tx.Put(db, dir)
+	})
+}
+
+func (f *FileSystem) NewFile(p, db string, j map[string]interface{}, user string) error {
+	// check path
+	p = path.Clean(p)
+	parent, name := splitPath(p)
+	err := filesystem.ValidateFileName(name)
+	if err != nil {
+		return err
+	}
+	file, err := newNode(name, "File", parent)
+	if err != nil {
+		return err
+	}
+	file.Header.Revision = 1
+	file.Content = map[string]interface{}{}
+	if j != nil {
+		file.Content = copyValue(j).(map[string]interface{})
+	}
+
+	return f.backend.Update(func(tx Tx) error {
+		_, err := findDirectory(tx, parent, db)
+		if err != nil {
+			return err
+		}
+		// check if name already taken
+		exists, err := pathExists(tx, p, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("file '%s' already exists", p)
+		}
+		err = tx.Put(db, file)
+		if err != nil {
+			return err
+		}
+		on, err := versioning(tx, db)
+		if err != nil || !on {
+			return err
+		}
+		return recordRevision(tx, db, nil, file, user)
+	})
+}
+
+func (f *FileSystem) ListDir(p, filter, db string) (map[string][]string, error) {
+	// check path
+	p = path.Clean(p)
+
+	// case insensitive regex
+	r, err := regexp.Compile("(?i)" + filter)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0)
+	files := make([]string, 0)
+	bfiles := make([]string, 0) // files with attachments
+
+	err = f.backend.View(func(tx Tx) error {
+		n, err := findPath(tx, p, db)
+		if err != nil {
+			return fmt.Errorf("path '%s' doesn't exist.", p)
+		}
+		if !n.IsDir() {
+			return nil
+		}
+		list, err := tx.Children(db, p)
+		if err != nil {
+			return err
+		}
+		for _, item := range list {
+			if !r.MatchString(item.Header.Name) {
+				continue
+			}
+			switch {
+			case item.IsDir():
+				dirs = append(dirs, item.Header.Name)
+			case item.AHeader.Filepointer == "":
+				files = append(files, item.Header.Name)
+			default:
+				bfiles = append(bfiles, item.Header.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string][]string{
+		"dirs":   dirs,
+		"files":  files,
+		"bfiles": bfiles,
+	}
+	return res, nil
+}
+
+func (f *FileSystem) ReadJson(p, db string, fields []string) (interface{}, int64, error) {
+	// check path
+	p = path.Clean(p)
+
+	var content map[string]interface{}
+	var rev int64
+	err := f.backend.View(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		content = n.Content
+		rev = n.Header.Revision
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(fields) == 0 {
+		return content, rev, nil
+	}
+	return project(content, fields), rev, nil
+}
+
+func (f *FileSystem) Delete(p, db string, ifrev int64) error {
+	// check path
+	p = path.Clean(p)
+	if p == "/" {
+		return errors.New("root directory can't be deleted")
+	}
+
+	err := f.expireTrash(db)
+	if err != nil {
+		return err
+	}
+
+	var attachments []string // unused attachments of deleted files
+	err = f.backend.Update(func(tx Tx) error {
+		n, err := findPath(tx, p, db)
+		if err != nil {
+			return err
+		}
+		if ifrev != bytengine.AnyRevision && n.IsDir() {
+			return errors.New("revision checks are only valid for files.")
+		}
+		if err = checkRevision(n, ifrev); err != nil {
+			return err
+		}
+		pointers, err := removeNode(tx, db, p, n)
+		if err != nil {
+			return err
+		}
+		attachments, err = releaseBytes(tx, db, pointers)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return f.deleteBytes(db, attachments)
+}
+
+func (f *FileSystem) Rename(p, newname, db string) error {
+	// check path
+	p = path.Clean(p)
+	if p == "/" {
+		return errors.New("root directory cannot be renamed.")
+	}
+
+	return f.backend.Update(func(tx Tx) error {
+		n, err := findPath(tx, p, db)
+		if err != nil {
+			return err
+		}
+
+		np := path.Join(path.Dir(p), newname)
+		if n.IsDir() {
+			// check if name is valid
+			if err = filesystem.ValidateDirName(newname); err != nil {
+				return err
+			}
+		} else {
+			if err = filesystem.ValidateFileName(newname); err != nil {
+				return err
+			}
+		}
+		// check if name isn't already in use
+		exists, err := pathExists(tx, np, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if n.IsDir() {
+				return fmt.Errorf("directory '%s' already exists", np)
+			}
+			return fmt.Errorf("file '%s' already exists", np)
+		}
+
+		if n.IsDir() {
+			// update affected child nodes
+			children, err := tx.Descendants(db, p)
+			if err != nil {
+				return err
+			}
+			for _, item := range children {
+				item.Header.Parent = replacePrefix(item.Header.Parent, p, np)
+				err = tx.Put(db, item)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		n.Header.Name = newname
+		return tx.Put(db, n)
+	})
+}
+
+func (f *FileSystem) Move(from, to, db string) error {
+	// check path
+	from = path.Clean(from)
+	to = path.Clean(to)
+	if from == "/" {
+		return errors.New("root directory can't be moved")
+	}
+	// check illegal move operation i.e. moving from parent to sub directory
+	if isSubPath(to, from) {
+		return errors.New("illegal move operation.")
+	}
+
+	return f.backend.Update(func(tx Tx) error {
+		// check if destination dir exists
+		dest, err := findPath(tx, to, db)
+		if err != nil {
+			return errors.New("Destination directory doesn't exist")
+		}
+		if !dest.IsDir() {
+			return errors.New("Destination must be a directory")
+		}
+
+		n, err := findPath(tx, from, db)
+		if err != nil {
+			return err
+		}
+
+		// check if name isn't already in use
+		np := path.Join(to, path.Base(from))
+		exists, err := pathExists(tx, np, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if n.IsDir() {
+				return fmt.Errorf("directory '%s' already exists", np)
+			}
+			return fmt.Errorf("file '%s' already exists", np)
+		}
+
+		if n.IsDir() {
+			// update affected child nodes
+			children, err := tx.Descendants(db, from)
+			if err != nil {
+				return err
+			}
+			for _, item := range children {
+				item.Header.Parent = replacePrefix(item.Header.Parent, from, np)
+				err = tx.Put(db, item)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		n.Header.Parent = to
+		return tx.Put(db, n)
+	})
+}
+
+func (f *FileSystem) Copy(from, to, db string) error {
+	// setup paths
+	from = path.Clean(from)
+	to = path.Clean(to)
+	toparent, toname := splitPath(to)
+
+	if from == "/" {
+		return errors.New("root directory cannot be copied.")
+	}
+	// check illegal copy operation i.e. copy from parent to sub directory
+	if isSubPath(toparent, from) {
+		return errors.New("illegal copy operation.")
+	}
+
+	return f.backend.Update(func(tx Tx) error {
+		// check if destination dir exists
+		dest, err := findPath(tx, toparent, db)
+		if err != nil {
+			return errors.New("Destination directory doesn't exist")
+		}
+		if !dest.IsDir() {
+			return errors.New("Destination must be a directory")
+		}
+
+		// check if item to copy exists
+		n, err := findPath(tx, from, db)
+		if err != nil {
+			return err
+		}
+
+		// check if name isn't already in use
+		exists, err := pathExists(tx, to, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("'%s' already exists.", to)
+		}
+
+		if n.IsDir() {
+			err = filesystem.ValidateDirName(toname)
+		} else {
+			err = filesystem.ValidateFileName(toname)
+		}
+		if err != nil {
+			return err
+		}
+
+		nodes := []*Node{n}
+		if n.IsDir() {
+			children, err := tx.Descendants(db, from)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, children...)
+		}
+
+		// both the original and copy will point to the same attachment id
+		// in the bst
+		dt := filesystem.FormatDatetime(time.Now())
+		for _, item := range nodes {
+			id, err := filesystem.NewNodeID()
+			if err != nil {
+				return err
+			}
+			if item.Id == n.Id {
+				item.Header.Parent = toparent
+				item.Header.Name = toname
+			} else {
+				item.Header.Parent = replacePrefix(item.Header.Parent, from, to)
+			}
+			item.Header.Created = dt
+			item.Header.Modified = dt
+			if !item.IsDir() {
+				item.Header.Revision = 1
+			}
+			item.Id = id
+			err = tx.Put(db, item)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (f *FileSystem) Info(p, db string) (map[string]interface{}, error) {
+	p = path.Clean(p)
+
+	var info map[string]interface{}
+	err := f.backend.View(func(tx Tx) error {
+		n, err := findPath(tx, p, db)
+		if err != nil {
+			return err
+		}
+
+		info = map[string]interface{}{
+			"name":    n.Header.Name,
+			"created": n.Header.Created,
+			"public":  n.Header.IsPublic,
+			"parent":  n.Header.Parent,
+		}
+
+		if n.IsDir() {
+			// count child nodes
+			children, err := tx.Children(db, p)
+			if err != nil {
+				return err
+			}
+			info["type"] = "directory"
+			info["content_count"] = len(children)
+		} else {
+			info["type"] = "file"
+			hash, err := filesystem.ContentHash(n.Content)
+			if err != nil {
+				return err
+			}
+			info["hash"] = hash
+			info["modified"] = n.LastModified()
+			info["revision"] = n.Header.Revision
+			if n.AHeader.Filepointer != "" {
+				info["bytes"] = bytesInfo(n.AHeader)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (f *FileSystem) FileAccess(p, db string, protect bool) error {
+	// check path
+	p = path.Clean(p)
+
+	return f.backend.Update(func(tx Tx) error {
+		n, err := findPath(tx, p, db)
+		if err != nil {
+			return err
+		}
+		nodes := []*Node{n}
+		if n.IsDir() {
+			// automatically cascade to sub nodes
+			children, err := tx.Descendants(db, p)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, children...)
+		}
+		for _, item := range nodes {
+			item.Header.IsPublic = !protect
+			err = tx.Put(db, item)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (f *FileSystem) SetCounter(counter, action string, value int64, db string) (int64, error) {
+	// update value 'v'
+	nv := math.Abs(float64(value))
+	value = int64(nv)
+
+	err := f.backend.Update(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		current, exists, err := tx.Counter(db, counter)
+		if err != nil {
+			return err
+		}
+		// if not exists create new counter
+		if !exists {
+			err = filesystem.ValidateCounterName(counter)
+			if err != nil {
+				return err
+			}
+			return tx.PutCounter(db, counter, value)
+		}
+
+		switch action {
+		case "incr":
+			value = current + value
+		case "decr":
+			value = current - value
+		case "reset":
+			// value is new counter value
+		default: // shouldn't reach here
+			return errors.New("counter action failed")
+		}
+		return tx.PutCounter(db, counter, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+func (f *FileSystem) ListCounter(filter, db string) (map[string]int64, error) {
+	// case insensitive regex
+	r, err := regexp.Compile("(?i)" + filter)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make(map[string]int64)
+	err = f.backend.View(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		counters, err := tx.Counters(db)
+		if err != nil {
+			return err
+		}
+		for name, value := range counters {
+			if r.MatchString(name) {
+				list[name] = value
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+func (f *FileSystem) WriteBytes(p string, r io.Reader, hint bytengine.FileHint, db, user string) (int64, error) {
+	var nbytes int64 // number of bytes written
+
+	// check path
+	p = path.Clean(p)
+
+	err := f.backend.View(func(tx Tx) error {
+		_, err := findFile(tx, p, db)
+		return err
+	})
+	if err != nil {
+		return nbytes, err
+	}
+
+	// content is always stored as a new attachment as copies and versions
+	// of the file may make reference to the current one
+	info, err := f.bstore.Add(db, r, hint)
+	if err != nil {
+		return nbytes, err
+	}
+	nbytes = info["size"].(int64)
+	pointer, _ := info["name"].(string)
+
+	var unused []string // attachments nothing makes reference to anymore
+	err = f.backend.Update(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		old := n.AHeader
+		n.AHeader.Filepointer = pointer
+		n.AHeader.Size = info["size"].(int64)
+		n.AHeader.Mime = info["mime"].(string)
+		// content digest if provided by the bst
+		n.AHeader.Hash, _ = info["hash"].(string)
+		n.AHeader.Modified = filesystem.FormatDatetime(time.Now())
+		n.AHeader.Filename = hint.Name
+		n.AHeader.User = user
+		err = tx.Put(db, n)
+		if err != nil {
+			return err
+		}
+
+		if old.Filepointer == "" || old.Filepointer == pointer {
+			return nil
+		}
+		on, err := versioning(tx, db)
+		if err != nil {
+			return err
+		}
+		if on {
+			return recordBytesVersion(tx, db, n.Id, old)
+		}
+		unused, err = releaseBytes(tx, db, []string{old.Filepointer})
+		return err
+	})
+	if err != nil {
+		// the new attachment isn't referenced by the file
+		if pointer != "" {
+			f.bstore.Delete(db, pointer)
+		}
+		return nbytes, err
+	}
+
+	return nbytes, f.deleteBytes(db, unused)
+}
+
+func (f *FileSystem) ReadBytes(fp, db string) (string, error) {
+	var id string // bytestore file id
+
+	// check path
+	fp = path.Clean(fp)
+
+	err := f.backend.View(func(tx Tx) error {
+		n, err := findFile(tx, fp, db)
+		if err != nil {
+			return err
+		}
+		id = n.AHeader.Filepointer
+		return nil
+	})
+	if err != nil {
+		return id, err
+	}
+
+	if len(id) == 0 {
+		return id, errors.New("byte layer is empty")
+	}
+	return id, nil
+}
+
+func (f *FileSystem) DirectAccess(fp, db, layer string) (map[string]interface{}, string, error) {
+	// check path
+	fp = path.Clean(fp)
+
+	var n *Node
+	err := f.backend.View(func(tx Tx) error {
+		var err error
+		n, err = findPath(tx, fp, db)
+		return err
+	})
+	if err != nil {
+		return nil, "", errors.New("file not found")
+	}
+
+	if n.IsDir() {
+		return nil, "", errors.New("command only valid for files.")
+	}
+	if !n.Header.IsPublic {
+		return nil, "", errors.New("file isn't public")
+	}
+
+	id := n.AHeader.Filepointer
+	switch layer {
+	case "json":
+		return n.Content, id, nil
+	case "bytes":
+		if len(id) == 0 {
+			return nil, id, errors.New("byte layer is empty")
+		}
+		return nil, id, nil
+	}
+
+	return nil, "", errors.New("data not found")
+}
+
+func (f *FileSystem) DeleteBytes(p, db string) error {
+	// check path
+	p = path.Clean(p)
+
+	var unused []string
+	err := f.backend.Update(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		old := n.AHeader
+		n.AHeader = BytesHeader{}
+		err = tx.Put(db, n)
+		if err != nil || old.Filepointer == "" {
+			return err
+		}
+		keep, err := versioning(tx, db)
+		if err != nil {
+			return err
+		}
+		if keep {
+			// attachment is kept as a version
+			return recordBytesVersion(tx, db, n.Id, old)
+		}
+		// copies of the file may still make reference to the attachment
+		unused, err = releaseBytes(tx, db, []string{old.Filepointer})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return f.deleteBytes(db, unused)
+}
+
+// ListBytes returns the paths of the files in the database grouped by
+// the attachment they make reference to. Replaced attachment versions are
+// listed as 'path (version n)' and files in the trash as 'path (trash)'.
+func (f *FileSystem) ListBytes(db string) (map[string][]string, error) {
+	list := make(map[string][]string)
+	err := f.backend.View(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		return walkAttachments(tx, db, func(pointer, ref string) error {
+			list[pointer] = append(list[pointer], ref)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (f *FileSystem) UpdateJson(p, db string, j map[string]interface{}, user string, ifrev int64) error {
+	// check path
+	p = path.Clean(p)
+
+	return f.backend.Update(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		if err = checkRevision(n, ifrev); err != nil {
+			return err
+		}
+		prev := n.Copy()
+		n.Content = map[string]interface{}{}
+		if j != nil {
+			n.Content = copyValue(j).(map[string]interface{})
+		}
+		n.Header.Modified = filesystem.FormatDatetime(time.Now())
+		n.Header.Revision++
+		err = tx.Put(db, n)
+		if err != nil {
+			return err
+		}
+		on, err := versioning(tx, db)
+		if err != nil || !on {
+			return err
+		}
+		return recordRevision(tx, db, prev, n, user)
+	})
+}
+
+func (f *FileSystem) BQLSearch(db string, query map[string]interface{}) (interface{}, error) {
+	// check fields and paths
+	fields, hasfields := query["fields"].([]string)
+	paths, haspaths := query["dirs"].([]string)
+	where, _ := query["where"].(bytengine.Condition)
+	limit, haslimit := query["limit"].(int64)
+	sortfields, hassort := query["sort"].([]string)
+	_, hascount := query["count"]
+	distinct, hasdistinct := query["distinct"].(string)
+
+	if !hasfields && !haspaths {
+		err := errors.New("Invalid select query: No fields or document paths.")
+		return nil, err
+	}
+	offset, paged, err := filesystem.PageOffset(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*Node
+	err = f.backend.View(func(tx Tx) error {
+		var err error
+		nodes, err = filesInDirs(tx, db, paths, where)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// check count
+	if hascount {
+		return len(nodes), nil
+	}
+
+	docs := make([]map[string]interface{}, len(nodes))
+	for i, n := range nodes {
+		docs[i] = document(n)
+	}
+
+	// check group
+	if group, hasgroup := query["group"].([]bytengine.GroupField); hasgroup {
+		aggregates, _ := query["aggregates"].([]bytengine.Aggregate)
+		return groupDocuments(docs, group, aggregates)
+	}
+
+	// check distinct
+	if hasdistinct {
+		distinctlist := []interface{}{}
+		for _, doc := range docs {
+			val, ok := lookup(doc, distinct)
+			if !ok {
+				continue
+			}
+			values := []interface{}{val}
+			if list, isList := val.([]interface{}); isList {
+				values = list
+			}
+			for _, v := range values {
+				found := false
+				for _, item := range distinctlist {
+					if equalValues(item, v) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					distinctlist = append(distinctlist, v)
+				}
+			}
+		}
+		return distinctlist, nil
+	}
+
+	// check sort
+	if hassort {
+		sortDocuments(docs, sortfields)
+	}
+	// check offset
+	if offset > int64(len(docs)) {
+		offset = int64(len(docs))
+	}
+	docs = docs[offset:]
+	// check limit, the next page starts after one more result
+	if paged {
+		limit++
+	}
+	if haslimit && limit > 0 && int(limit) < len(docs) {
+		docs = docs[:limit]
+	}
+
+	// get results
+	itemlist := []interface{}{}
+	for _, doc := range docs {
+		h := doc["__header__"].(map[string]interface{})
+		_path := path.Join(h["parent"].(string), h["name"].(string))
+		content, _ := doc["content"].(map[string]interface{})
+		if hasfields && len(fields) > 0 {
+			content = project(content, fields)
+		}
+		itemlist = append(itemlist, map[string]interface{}{"path": _path, "content": content})
+	}
+
+	if paged {
+		return filesystem.PageResult(query, itemlist, offset)
+	}
+	return itemlist, nil
+}
+
+func (f *FileSystem) BQLDelete(db string, query map[string]interface{}) ([]string, error) {
+	// check paths
+	paths, haspaths := query["dirs"].([]string)
+	where, _ := query["where"].(bytengine.Condition)
+	ifrev, hasrev := query["ifrev"].(int64)
+	if !hasrev {
+		ifrev = bytengine.AnyRevision
+	}
+
+	if !haspaths {
+		err := errors.New("Invalid delete command: No document paths.")
+		return nil, err
+	}
+
+	err := f.expireTrash(db)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := []string{}
+	attachments := []string{} // attachments of deleted files
+	err = f.backend.Update(func(tx Tx) error {
+		nodes, err := filesInDirs(tx, db, paths, where)
+		if err != nil {
+			return err
+		}
+		// no file is deleted if one of them has been changed by another
+		// client
+		for _, n := range nodes {
+			if err = checkRevision(n, ifrev); err != nil {
+				return err
+			}
+		}
+		for _, n := range nodes {
+			p := path.Join(n.Header.Parent, n.Header.Name)
+			pointers, err := removeNode(tx, db, p, n)
+			if err != nil {
+				return err
+			}
+			attachments = append(attachments, pointers...)
+			deleted = append(deleted, p)
+		}
+		attachments, err = releaseBytes(tx, db, attachments)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(deleted)
+	return deleted, f.deleteBytes(db, attachments)
+}
+
+func (f *FileSystem) BQLSet(db string, query map[string]interface{}, user string) (int, error) {
+	var count int // number of items updated
+
+	// check fields and paths
+	fields, hasfields := query["fields"].(map[string]interface{})
+	incr, _ := query["incr"].(map[string]interface{})
+	paths, haspaths := query["dirs"].([]string)
+	where, _ := query["where"].(bytengine.Condition)
+	ifrev, hasrev := query["ifrev"].(int64)
+	if !hasrev {
+		ifrev = bytengine.AnyRevision
+	}
+
+	if !hasfields && !haspaths {
+		err := errors.New("Invalid set command: No fields or document paths.")
+		return count, err
+	}
+
+	dt := filesystem.FormatDatetime(time.Now())
+	err := f.backend.Update(func(tx Tx) error {
+		nodes, err := filesInDirs(tx, db, paths, where)
+		if err != nil {
+			return err
+		}
+		on, err := versioning(tx, db)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			// no file is changed if one of them has been changed by
+			// another client
+			if err = checkRevision(n, ifrev); err != nil {
+				return err
+			}
+			prev := n.Copy()
+			for field, value := range fields {
+				err = setField(n.Content, field, value)
+				if err != nil {
+					return err
+				}
+			}
+			for field, value := range incr {
+				v, ok := toNumber(value)
+				if !ok {
+					return fmt.Errorf("invalid increment value for field '%s'", field)
+				}
+				err = incrField(n.Content, field, v)
+				if err != nil {
+					return err
+				}
+			}
+			err = applyOperators(n.Content, query, dt)
+			if err != nil {
+				return err
+			}
+			n.Header.Modified = dt
+			n.Header.Revision++
+			err = tx.Put(db, n)
+			if err != nil {
+				return err
+			}
+			if on {
+				err = recordRevision(tx, db, prev, n, user)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		count = len(nodes)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (f *FileSystem) BQLUnset(db string, query map[string]interface{}, user string) (int, error) {
+	var count int // number of items updated
+
+	// check fields and paths
+	fields, hasfields := query["fields"].(map[string]interface{})
+	paths, haspaths := query["dirs"].([]string)
+	where, _ := query["where"].(bytengine.Condition)
+	ifrev, hasrev := query["ifrev"].(int64)
+	if !hasrev {
+		ifrev = bytengine.AnyRevision
+	}
+
+	if !hasfields && !haspaths {
+		err := errors.New("Invalid unset command: No fields or document paths.")
+		return count, err
+	}
+
+	dt := filesystem.FormatDatetime(time.Now())
+	err := f.backend.Update(func(tx Tx) error {
+		nodes, err := filesInDirs(tx, db, paths, where)
+		if err != nil {
+			return err
+		}
+		on, err := versioning(tx, db)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			// no file is changed if one of them has been changed by
+			// another client
+			if err = checkRevision(n, ifrev); err != nil {
+				return err
+			}
+			prev := n.Copy()
+			for field := range fields {
+				err = unsetField(n.Content, field)
+				if err != nil {
+					return err
+				}
+			}
+			n.Header.Modified = dt
+			n.Header.Revision++
+			err = tx.Put(db, n)
+			if err != nil {
+				return err
+			}
+			if on {
+				err = recordRevision(tx, db, prev, n, user)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		count = len(nodes)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,458 @@
+package docfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnwilson/bytengine/filesystem"
+)
+
+// record collections
+const (
+	settingsCollection     = "settings"
+	historyCollection      = "history"
+	bytesHistoryCollection = "byteshistory"
+)
+
+// Revision is a recorded version of the json content of a file
+type Revision struct {
+	Revision int64                  `json:"revision"`
+	Created  string                 `json:"created"`
+	User     string                 `json:"user"`
+	Content  map[string]interface{} `json:"content"`
+}
+
+// BytesVersion is a replaced attachment of a file
+type BytesVersion struct {
+	Version  int64       `json:"version"`
+	Bytes    BytesHeader `json:"bytes"`
+	Replaced string      `json:"replaced"`
+}
+
+// revisionKey builds the history key of a revision or attachment version.
+// Revisions of a file share the same prefix and are sorted by number.
+func revisionKey(id string, rev int64) string {
+	return fmt.Sprintf("%s/%020d", id, rev)
+}
+
+// versioning checks if changes to the files of the database are recorded
+func versioning(tx Tx, db string) (bool, error) {
+	data, err := tx.Record(db, settingsCollection, "versioning")
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(data) == "true", nil
+}
+
+// revisions returns the recorded revisions of a file, oldest first
+func revisions(tx Tx, db, id string) ([]*Revision, error) {
+	list := []*Revision{}
+	err := tx.Records(db, historyCollection, id+"/", func(_ string, data []byte) error {
+		var r Revision
+		if err := json.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		list = append(list, &r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// readRevision returns the revision rev of the file p with the given id
+func readRevision(tx Tx, db, p, id string, rev int64) (*Revision, error) {
+	data, err := tx.Record(db, historyCollection, revisionKey(id, rev))
+	if err == ErrNotFound {
+		return nil, fmt.Errorf("revision %d of '%s' doesn't exist", rev, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r Revision
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func putRevision(tx Tx, db, id string, r *Revision) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return tx.PutRecord(db, historyCollection, revisionKey(id, r.Revision), data)
+}
+
+// sameContent compares json content independently of the value types used
+// by the backend
+func sameContent(a, b map[string]interface{}) bool {
+	ha, err := filesystem.ContentHash(a)
+	if err != nil {
+		return false
+	}
+	hb, err := filesystem.ContentHash(b)
+	if err != nil {
+		return false
+	}
+	return ha == hb
+}
+
+// recordRevision adds the content of n as the latest revision of the file.
+// The previous content prev of existing files is recorded first if the
+// history doesn't end with it, i.e. for files changed while versioning was
+// disabled.
+func recordRevision(tx Tx, db string, prev, n *Node, user string) error {
+	list, err := revisions(tx, db, n.Id)
+	if err != nil {
+		return err
+	}
+	var last *Revision
+	if len(list) > 0 {
+		last = list[len(list)-1]
+	}
+	var num int64
+	if last != nil {
+		num = last.Revision
+	}
+
+	if prev != nil && (last == nil || !sameContent(last.Content, prev.Content)) {
+		num++
+		r := &Revision{num, prev.LastModified(), "", prev.Content}
+		err = putRevision(tx, db, n.Id, r)
+		if err != nil {
+			return err
+		}
+	}
+	num++
+	return putRevision(tx, db, n.Id, &Revision{num, n.LastModified(), user, n.Content})
+}
+
+// bytesVersions returns the replaced attachments of a file, oldest first
+func bytesVersions(tx Tx, db, id string) ([]*BytesVersion, error) {
+	list := []*BytesVersion{}
+	err := tx.Records(db, bytesHistoryCollection, id+"/", func(_ string, data []byte) error {
+		var v BytesVersion
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		list = append(list, &v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// walkBytesVersions calls fn for every replaced attachment in the database
+// together with the id of its file
+func walkBytesVersions(tx Tx, db string, fn func(id string, v *BytesVersion) error) error {
+	return tx.Records(db, bytesHistoryCollection, "", func(key string, data []byte) error {
+		var v BytesVersion
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		return fn(key[:strings.LastIndex(key, "/")], &v)
+	})
+}
+
+// recordBytesVersion keeps the replaced attachment h of a file
+func recordBytesVersion(tx Tx, db, id string, h BytesHeader) error {
+	list, err := bytesVersions(tx, db, id)
+	if err != nil {
+		return err
+	}
+	num := int64(1)
+	if len(list) > 0 {
+		num = list[len(list)-1].Version + 1
+	}
+	v := &BytesVersion{num, h, filesystem.FormatDatetime(time.Now())}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.PutRecord(db, bytesHistoryCollection, revisionKey(id, num), data)
+}
+
+// pruneBytesVersions deletes all but the keep latest replaced attachments
+// of a file and returns their pointers
+func pruneBytesVersions(tx Tx, db, id string, keep int64) ([]string, error) {
+	list, err := bytesVersions(tx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	pointers := []string{}
+	for i := 0; i < len(list)-int(keep); i++ {
+		err = tx.RemoveRecord(db, bytesHistoryCollection, revisionKey(id, list[i].Version))
+		if err != nil {
+			return nil, err
+		}
+		pointers = append(pointers, list[i].Bytes.Filepointer)
+	}
+	return pointers, nil
+}
+
+// removeHistory deletes all revisions and replaced attachments of a file.
+// The pointers of the attachments are returned so that they can be released.
+func removeHistory(tx Tx, db, id string) ([]string, error) {
+	keys := []string{}
+	err := tx.Records(db, historyCollection, id+"/", func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		err = tx.RemoveRecord(db, historyCollection, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pruneBytesVersions(tx, db, id, 0)
+}
+
+// bytesInfo describes an attachment
+func bytesInfo(h BytesHeader) map[string]interface{} {
+	info := map[string]interface{}{
+		"mime": h.Mime,
+		"size": h.Size,
+	}
+	if h.Hash != "" {
+		info["hash"] = h.Hash
+	}
+	if h.Modified != "" {
+		info["modified"] = h.Modified
+	}
+	if h.Filename != "" {
+		info["filename"] = h.Filename
+	}
+	if h.User != "" {
+		info["user"] = h.User
+	}
+	return info
+}
+
+/*
+============================================================================
+    BFS Interface Methods
+============================================================================
+*/
+
+func (f *FileSystem) Versioning(db string) (bool, error) {
+	var enabled bool
+	err := f.backend.View(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		var err error
+		enabled, err = versioning(tx, db)
+		return err
+	})
+	return enabled, err
+}
+
+func (f *FileSystem) SetVersioning(db string, enabled bool) error {
+	return f.backend.Update(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		// recorded history is kept when versioning is disabled
+		return tx.PutRecord(db, settingsCollection, "versioning", []byte(strconv.FormatBool(enabled)))
+	})
+}
+
+func (f *FileSystem) ListRevisions(p, db string) ([]map[string]interface{}, error) {
+	// check path
+	p = path.Clean(p)
+
+	list := []map[string]interface{}{}
+	err := f.backend.View(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		revs, err := revisions(tx, db, n.Id)
+		if err != nil {
+			return err
+		}
+		for _, r := range revs {
+			item := map[string]interface{}{
+				"revision": r.Revision,
+				"created":  r.Created,
+				"user":     r.User,
+			}
+			list = append(list, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (f *FileSystem) ReadRevision(p, db string, rev int64, fields []string) (interface{}, error) {
+	// check path
+	p = path.Clean(p)
+
+	var content map[string]interface{}
+	err := f.backend.View(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		r, err := readRevision(tx, db, p, n.Id, rev)
+		if err != nil {
+			return err
+		}
+		content = r.Content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+	if len(fields) == 0 {
+		return content, nil
+	}
+	return project(content, fields), nil
+}
+
+func (f *FileSystem) RevertJson(p, db string, rev int64, user string) error {
+	// check path
+	p = path.Clean(p)
+
+	return f.backend.Update(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		r, err := readRevision(tx, db, p, n.Id, rev)
+		if err != nil {
+			return err
+		}
+		on, err := versioning(tx, db)
+		if err != nil {
+			return err
+		}
+
+		prev := n.Copy()
+		n.Content = map[string]interface{}{}
+		if r.Content != nil {
+			n.Content = r.Content
+		}
+		n.Header.Modified = filesystem.FormatDatetime(time.Now())
+		n.Header.Revision++
+		err = tx.Put(db, n)
+		if err != nil {
+			return err
+		}
+		if !on {
+			return nil
+		}
+		return recordRevision(tx, db, prev, n, user)
+	})
+}
+
+func (f *FileSystem) ListBytesVersions(p, db string) ([]map[string]interface{}, error) {
+	// check path
+	p = path.Clean(p)
+
+	list := []map[string]interface{}{}
+	err := f.backend.View(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		versions, err := bytesVersions(tx, db, n.Id)
+		if err != nil {
+			return err
+		}
+		for _, v := range versions {
+			item := bytesInfo(v.Bytes)
+			item["version"] = v.Version
+			item["replaced"] = v.Replaced
+			list = append(list, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (f *FileSystem) ReadBytesVersion(fp, db string, version int64) (string, map[string]interface{}, error) {
+	// check path
+	fp = path.Clean(fp)
+
+	var h BytesHeader
+	err := f.backend.View(func(tx Tx) error {
+		n, err := findFile(tx, fp, db)
+		if err != nil {
+			return err
+		}
+		data, err := tx.Record(db, bytesHistoryCollection, revisionKey(n.Id, version))
+		if err == ErrNotFound {
+			return fmt.Errorf("version %d of '%s' doesn't exist", version, fp)
+		}
+		if err != nil {
+			return err
+		}
+		var v BytesVersion
+		err = json.Unmarshal(data, &v)
+		if err != nil {
+			return err
+		}
+		h = v.Bytes
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return h.Filepointer, bytesInfo(h), nil
+}
+
+func (f *FileSystem) PruneBytesVersions(p, db string, keep int64) (int, error) {
+	// check path
+	p = path.Clean(p)
+	if keep < 0 {
+		return 0, errors.New("number of versions to keep can't be negative")
+	}
+
+	var count int
+	var unused []string
+	err := f.backend.Update(func(tx Tx) error {
+		n, err := findFile(tx, p, db)
+		if err != nil {
+			return err
+		}
+		pointers, err := pruneBytesVersions(tx, db, n.Id, keep)
+		if err != nil {
+			return err
+		}
+		count = len(pointers)
+		unused, err = releaseBytes(tx, db, pointers)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, f.deleteBytes(db, unused)
+}
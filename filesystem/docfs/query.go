@@ -0,0 +1,715 @@
+package docfs
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/johnwilson/bytengine"
+)
+
+// field prefix for file json content
+const ContentPrefix = "content."
+
+// document returns the node as a generic document so that query fields
+// such as 'content.name' or '__header__.name' can be looked up
+func document(n *Node) map[string]interface{} {
+	return map[string]interface{}{
+		"_id": n.Id,
+		"__header__": map[string]interface{}{
+			"name":     n.Header.Name,
+			"type":     n.Header.Type,
+			"ispublic": n.Header.IsPublic,
+			"created":  n.Header.Created,
+			"parent":   n.Header.Parent,
+			"modified": n.LastModified(),
+		},
+		"__bytes__": map[string]interface{}{
+			"filepointer": n.AHeader.Filepointer,
+			"mime":        n.AHeader.Mime,
+			"size":        n.AHeader.Size,
+			"hash":        n.AHeader.Hash,
+			"modified":    n.AHeader.Modified,
+			"filename":    n.AHeader.Filename,
+		},
+		"content": n.Content,
+	}
+}
+
+// lookup gets the value of a dot separated field. Arrays can be indexed with
+// a number or traversed in which case all matching values are returned.
+func lookup(v interface{}, field string) (interface{}, bool) {
+	if field == "" {
+		return v, true
+	}
+	key := field
+	rest := ""
+	if i := strings.Index(field, "."); i != -1 {
+		key = field[:i]
+		rest = field[i+1:]
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		item, ok := val[key]
+		if !ok {
+			return nil, false
+		}
+		return lookup(item, rest)
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil {
+			if i < 0 || i >= len(val) {
+				return nil, false
+			}
+			return lookup(val[i], rest)
+		}
+		found := []interface{}{}
+		for _, item := range val {
+			if r, ok := lookup(item, field); ok {
+				found = append(found, r)
+			}
+		}
+		if len(found) == 0 {
+			return nil, false
+		}
+		return found, true
+	}
+	return nil, false
+}
+
+// contentField removes the content prefix from a field name
+func contentField(field string) (string, error) {
+	if !strings.HasPrefix(field, ContentPrefix) || len(field) == len(ContentPrefix) {
+		return "", fmt.Errorf("field '%s' can't be modified", field)
+	}
+	return strings.TrimPrefix(field, ContentPrefix), nil
+}
+
+// setField assigns a value to a dot separated content field creating
+// intermediate objects when required
+func setField(content map[string]interface{}, field string, value interface{}) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(name, ".")
+	m := content
+	for _, key := range parts[:len(parts)-1] {
+		item, ok := m[key]
+		if !ok || item == nil {
+			child := map[string]interface{}{}
+			m[key] = child
+			m = child
+			continue
+		}
+		child, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field '%s' couldn't be set: '%s' isn't an object", name, key)
+		}
+		m = child
+	}
+	m[parts[len(parts)-1]] = copyValue(value)
+	return nil
+}
+
+// incrField adds value to a numeric content field
+func incrField(content map[string]interface{}, field string, value float64) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	current, exists := lookup(content, name)
+	if !exists {
+		return setField(content, field, value)
+	}
+	n, ok := toNumber(current)
+	if !ok {
+		return fmt.Errorf("field '%s' couldn't be incremented: value isn't a number", name)
+	}
+	return setField(content, field, n+value)
+}
+
+// arrayField returns the array held by a content field. Missing fields are
+// returned as empty arrays.
+func arrayField(content map[string]interface{}, name string) ([]interface{}, bool, error) {
+	current, exists := lookup(content, name)
+	if !exists || current == nil {
+		return []interface{}{}, false, nil
+	}
+	list, ok := current.([]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("field '%s' couldn't be updated: value isn't an array", name)
+	}
+	return list, true, nil
+}
+
+// pushField appends value to an array content field or, if unique is set,
+// only adds it if the array doesn't hold it yet
+func pushField(content map[string]interface{}, field string, value interface{}, unique bool) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	list, _, err := arrayField(content, name)
+	if err != nil {
+		return err
+	}
+	if unique {
+		for _, item := range list {
+			if equalValues(item, value) {
+				return setField(content, field, list)
+			}
+		}
+	}
+	return setField(content, field, append(list, value))
+}
+
+// pullField removes all occurrences of value from an array content field
+func pullField(content map[string]interface{}, field string, value interface{}) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	list, exists, err := arrayField(content, name)
+	if err != nil || !exists {
+		return err
+	}
+	kept := []interface{}{}
+	for _, item := range list {
+		if !equalValues(item, value) {
+			kept = append(kept, item)
+		}
+	}
+	return setField(content, field, kept)
+}
+
+// popField removes the first (end < 0) or last element of an array content
+// field
+func popField(content map[string]interface{}, field string, end int64) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	list, exists, err := arrayField(content, name)
+	if err != nil || !exists || len(list) == 0 {
+		return err
+	}
+	if end < 0 {
+		return setField(content, field, list[1:])
+	}
+	return setField(content, field, list[:len(list)-1])
+}
+
+// multiplyField multiplies a numeric content field by value. Missing fields
+// are set to 0.
+func multiplyField(content map[string]interface{}, field string, value float64) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	current, exists := lookup(content, name)
+	if !exists {
+		return setField(content, field, 0)
+	}
+	n, ok := toNumber(current)
+	if !ok {
+		return fmt.Errorf("field '%s' couldn't be multiplied: value isn't a number", name)
+	}
+	return setField(content, field, n*value)
+}
+
+// limitField replaces a content field by value if value is lower (sign < 0)
+// or greater than the current value
+func limitField(content map[string]interface{}, field string, value interface{}, sign int) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	current, exists := lookup(content, name)
+	if exists && compareValues(value, current)*sign <= 0 {
+		return nil
+	}
+	return setField(content, field, value)
+}
+
+// renameField moves a content field to a new name
+func renameField(content map[string]interface{}, field, to string) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	current, exists := lookup(content, name)
+	if !exists {
+		return nil
+	}
+	if err = unsetField(content, field); err != nil {
+		return err
+	}
+	return setField(content, to, current)
+}
+
+// applyOperators applies the update operators of a set command other than
+// assignments and increments to content. dt is the current timestamp.
+func applyOperators(content map[string]interface{}, query map[string]interface{}, dt string) error {
+	values := func(op string) map[string]interface{} {
+		m, _ := query[op].(map[string]interface{})
+		return m
+	}
+	var err error
+	for field, value := range values("multiply") {
+		v, ok := toNumber(value)
+		if !ok {
+			return fmt.Errorf("invalid multiplication value for field '%s'", field)
+		}
+		if err = multiplyField(content, field, v); err != nil {
+			return err
+		}
+	}
+	for field, value := range values("min") {
+		if err = limitField(content, field, value, -1); err != nil {
+			return err
+		}
+	}
+	for field, value := range values("max") {
+		if err = limitField(content, field, value, 1); err != nil {
+			return err
+		}
+	}
+	for field, value := range values("push") {
+		if err = pushField(content, field, value, false); err != nil {
+			return err
+		}
+	}
+	for field, value := range values("addtoset") {
+		if err = pushField(content, field, value, true); err != nil {
+			return err
+		}
+	}
+	for field, value := range values("pull") {
+		if err = pullField(content, field, value); err != nil {
+			return err
+		}
+	}
+	for field, value := range values("pop") {
+		end, _ := toNumber(value)
+		if err = popField(content, field, int64(end)); err != nil {
+			return err
+		}
+	}
+	for field, value := range values("rename") {
+		to, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("invalid new name for field '%s'", field)
+		}
+		if err = renameField(content, field, to); err != nil {
+			return err
+		}
+	}
+	now, _ := query["now"].([]string)
+	for _, field := range now {
+		if err = setField(content, field, dt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsetField removes a dot separated content field
+func unsetField(content map[string]interface{}, field string) error {
+	name, err := contentField(field)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(name, ".")
+	m := content
+	for _, key := range parts[:len(parts)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		m = child
+	}
+	delete(m, parts[len(parts)-1])
+	return nil
+}
+
+// project returns a copy of content with only the given fields
+func project(content map[string]interface{}, fields []string) map[string]interface{} {
+	r := map[string]interface{}{}
+	for _, field := range fields {
+		name := strings.TrimPrefix(field, ContentPrefix)
+		val, ok := lookup(content, name)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(name, ".")
+		m := r
+		for _, key := range parts[:len(parts)-1] {
+			child, ok := m[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				m[key] = child
+			}
+			m = child
+		}
+		m[parts[len(parts)-1]] = copyValue(val)
+	}
+	return r
+}
+
+/*
+============================================================================
+    Where statement matching
+============================================================================
+*/
+
+// match checks a document against a where statement condition tree
+func match(doc map[string]interface{}, cond bytengine.Condition) (bool, error) {
+	switch c := cond.(type) {
+	case nil:
+		return true, nil
+	case bytengine.And:
+		for _, item := range c.Conditions {
+			ok, err := match(doc, item)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case bytengine.Or:
+		for _, item := range c.Conditions {
+			ok, err := match(doc, item)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	case bytengine.Comparison:
+		val, exists := lookup(doc, c.Field)
+		switch c.Op {
+		case bytengine.OpEqual:
+			return matchEqual(val, exists, c.Value), nil
+		case bytengine.OpNotEqual:
+			return !matchEqual(val, exists, c.Value), nil
+		case bytengine.OpLesser, bytengine.OpLesserEqual, bytengine.OpGreater, bytengine.OpGreaterEqual:
+			ok := exists && matchAny(val, func(v interface{}) bool {
+				r, comparable := compareSameType(v, c.Value)
+				if !comparable {
+					return false
+				}
+				switch c.Op {
+				case bytengine.OpLesser:
+					return r < 0
+				case bytengine.OpLesserEqual:
+					return r <= 0
+				case bytengine.OpGreater:
+					return r > 0
+				}
+				return r >= 0
+			})
+			return ok, nil
+		}
+		return false, fmt.Errorf("unsupported comparison operator %s", c.Op)
+	case bytengine.In:
+		val, exists := lookup(doc, c.Field)
+		in := false
+		for _, item := range c.Values {
+			if matchEqual(val, exists, item) {
+				in = true
+				break
+			}
+		}
+		return in != c.Negate, nil
+	case bytengine.Exists:
+		_, exists := lookup(doc, c.Field)
+		return exists == c.Exists, nil
+	case bytengine.TypeOf:
+		val, exists := lookup(doc, c.Field)
+		ok := exists && matchAny(val, func(v interface{}) bool {
+			return isType(v, c.Type)
+		})
+		return ok != c.Negate, nil
+	case bytengine.Regex:
+		re, err := compileRegex(c.Pattern, c.Options)
+		if err != nil {
+			return false, err
+		}
+		val, exists := lookup(doc, c.Field)
+		ok := exists && matchAny(val, func(v interface{}) bool {
+			s, isString := v.(string)
+			return isString && re.MatchString(s)
+		})
+		return ok, nil
+	}
+	return false, fmt.Errorf("unsupported condition %T", cond)
+}
+
+// isType checks the type of a value. Numbers without fractional part are
+// considered as int.
+func isType(v interface{}, typ string) bool {
+	switch typ {
+	case bytengine.TypeString:
+		_, ok := v.(string)
+		return ok
+	case bytengine.TypeInt:
+		n, ok := toNumber(v)
+		return ok && n == math.Trunc(n)
+	}
+	return false
+}
+
+// compileRegex converts regex options into go regex flags
+func compileRegex(pattern, options string) (*regexp.Regexp, error) {
+	flags := ""
+	for _, o := range options {
+		switch o {
+		case 'i', 'm', 's':
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// matchAny calls fn on the value and if it is an array on all its elements
+func matchAny(val interface{}, fn func(v interface{}) bool) bool {
+	if fn(val) {
+		return true
+	}
+	if list, ok := val.([]interface{}); ok {
+		for _, item := range list {
+			if fn(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchEqual follows mongodb semantics: null matches missing fields and
+// arrays match if any element is equal
+func matchEqual(val interface{}, exists bool, arg interface{}) bool {
+	if !exists {
+		return arg == nil
+	}
+	return matchAny(val, func(v interface{}) bool {
+		return equalValues(v, arg)
+	})
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func equalValues(a, b interface{}) bool {
+	na, aok := toNumber(a)
+	nb, bok := toNumber(b)
+	if aok || bok {
+		return aok && bok && na == nb
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, item := range av {
+			other, ok := bv[k]
+			if !ok || !equalValues(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equalValues(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareSameType compares numbers with numbers and strings with strings
+func compareSameType(a, b interface{}) (int, bool) {
+	if typeRank(a) != typeRank(b) {
+		return 0, false
+	}
+	switch typeRank(a) {
+	case rankNumber, rankString, rankBool:
+		return compareValues(a, b), true
+	}
+	return 0, false
+}
+
+// sort order of value types
+const (
+	rankMissing = iota
+	rankNull
+	rankNumber
+	rankString
+	rankObject
+	rankArray
+	rankBool
+	rankOther
+)
+
+func typeRank(v interface{}) int {
+	if _, ok := toNumber(v); ok {
+		return rankNumber
+	}
+	switch v.(type) {
+	case nil:
+		return rankNull
+	case string:
+		return rankString
+	case map[string]interface{}:
+		return rankObject
+	case []interface{}:
+		return rankArray
+	case bool:
+		return rankBool
+	}
+	return rankOther
+}
+
+// compareValues gives a total order over json values
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch ra {
+	case rankNumber:
+		na, _ := toNumber(a)
+		nb, _ := toNumber(b)
+		switch {
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	case rankString:
+		return strings.Compare(a.(string), b.(string))
+	case rankBool:
+		ba, bb := a.(bool), b.(bool)
+		switch {
+		case ba == bb:
+			return 0
+		case !ba:
+			return -1
+		}
+		return 1
+	case rankArray:
+		la, lb := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(la) && i < len(lb); i++ {
+			if c := compareValues(la[i], lb[i]); c != 0 {
+				return c
+			}
+		}
+		return len(la) - len(lb)
+	case rankObject:
+		ma, mb := a.(map[string]interface{}), b.(map[string]interface{})
+		keys := []string{}
+		for k := range ma {
+			keys = append(keys, k)
+		}
+		for k := range mb {
+			if _, ok := ma[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			va, oka := ma[k]
+			vb, okb := mb[k]
+			if !oka {
+				return -1
+			}
+			if !okb {
+				return 1
+			}
+			if c := compareValues(va, vb); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	return 0
+}
+
+// sortDocuments orders documents using mongodb style sort fields
+// i.e. 'content.name' for ascending and '-content.name' for descending
+func sortDocuments(docs []map[string]interface{}, fields []string) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range fields {
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			a, aok := lookup(docs[i], field)
+			b, bok := lookup(docs[j], field)
+			var c int
+			switch {
+			case !aok && !bok:
+				c = 0
+			case !aok:
+				c = -1
+			case !bok:
+				c = 1
+			default:
+				c = compareValues(a, b)
+			}
+			if c == 0 {
+				continue
+			}
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
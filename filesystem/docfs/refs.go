@@ -0,0 +1,212 @@
+package docfs
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// refsCollection counts the references made to each attachment by files,
+// files in the trash and replaced attachment versions so that unused
+// attachments are found without walking the database
+const refsCollection = "refs"
+
+// refBackend keeps the reference counts of the attachments up to date in
+// every update transaction of the wrapped backend
+type refBackend struct {
+	Backend
+}
+
+func (b refBackend) View(fn func(tx Tx) error) error {
+	return b.Backend.View(func(tx Tx) error {
+		return fn(refTx{tx})
+	})
+}
+
+func (b refBackend) Update(fn func(tx Tx) error) error {
+	return b.Backend.Update(func(tx Tx) error {
+		return fn(refTx{tx})
+	})
+}
+
+// refTx counts the attachment references added and removed by node and
+// record changes
+type refTx struct {
+	Tx
+}
+
+func (t refTx) Put(db string, n *Node) error {
+	old, err := t.Tx.Node(db, n.Id)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	err = t.Tx.Put(db, n)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		if old.AHeader.Filepointer == n.AHeader.Filepointer {
+			return nil
+		}
+		err = addRefs(t.Tx, db, []string{old.AHeader.Filepointer}, -1)
+		if err != nil {
+			return err
+		}
+	}
+	return addRefs(t.Tx, db, []string{n.AHeader.Filepointer}, 1)
+}
+
+func (t refTx) Remove(db, id string) error {
+	old, err := t.Tx.Node(db, id)
+	if err == ErrNotFound {
+		return t.Tx.Remove(db, id)
+	}
+	if err != nil {
+		return err
+	}
+	err = t.Tx.Remove(db, id)
+	if err != nil {
+		return err
+	}
+	return addRefs(t.Tx, db, []string{old.AHeader.Filepointer}, -1)
+}
+
+func (t refTx) PutRecord(db, collection, key string, value []byte) error {
+	old, err := t.recordPointers(db, collection, key)
+	if err != nil {
+		return err
+	}
+	err = t.Tx.PutRecord(db, collection, key, value)
+	if err != nil {
+		return err
+	}
+	err = addRefs(t.Tx, db, old, -1)
+	if err != nil {
+		return err
+	}
+	pointers, err := recordPointers(collection, value)
+	if err != nil {
+		return err
+	}
+	return addRefs(t.Tx, db, pointers, 1)
+}
+
+func (t refTx) RemoveRecord(db, collection, key string) error {
+	old, err := t.recordPointers(db, collection, key)
+	if err != nil {
+		return err
+	}
+	err = t.Tx.RemoveRecord(db, collection, key)
+	if err != nil {
+		return err
+	}
+	return addRefs(t.Tx, db, old, -1)
+}
+
+// recordPointers returns the attachments the stored record makes
+// reference to
+func (t refTx) recordPointers(db, collection, key string) ([]string, error) {
+	if collection != trashCollection && collection != bytesHistoryCollection {
+		return nil, nil
+	}
+	data, err := t.Tx.Record(db, collection, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return recordPointers(collection, data)
+}
+
+// recordPointers returns the attachments an encoded trash item or replaced
+// attachment version makes reference to
+func recordPointers(collection string, data []byte) ([]string, error) {
+	pointers := []string{}
+	switch collection {
+	case trashCollection:
+		var item TrashItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		for _, n := range item.Nodes {
+			pointers = append(pointers, n.AHeader.Filepointer)
+		}
+	case bytesHistoryCollection:
+		var v BytesVersion
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		pointers = append(pointers, v.Bytes.Filepointer)
+	}
+	return pointers, nil
+}
+
+// refCount returns the number of references made to an attachment
+func refCount(tx Tx, db, pointer string) (int64, error) {
+	data, err := tx.Record(db, refsCollection, pointer)
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+// addRefs adds delta to the reference counts of the attachments. Counts
+// are removed once they drop to zero.
+func addRefs(tx Tx, db string, pointers []string, delta int64) error {
+	for _, pointer := range pointers {
+		if pointer == "" {
+			continue
+		}
+		count, err := refCount(tx, db, pointer)
+		if err != nil {
+			return err
+		}
+		count += delta
+		if count <= 0 {
+			err = tx.RemoveRecord(db, refsCollection, pointer)
+		} else {
+			err = tx.PutRecord(db, refsCollection, pointer, []byte(strconv.FormatInt(count, 10)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseBytes returns the attachments nothing makes reference to anymore
+// once the changes of the transaction are applied. They have to be deleted
+// from the bst with deleteBytes after the transaction is committed.
+func releaseBytes(tx Tx, db string, pointers []string) ([]string, error) {
+	unused := []string{}
+	seen := map[string]bool{}
+	for _, pointer := range pointers {
+		if pointer == "" || seen[pointer] {
+			continue
+		}
+		seen[pointer] = true
+		count, err := refCount(tx, db, pointer)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			unused = append(unused, pointer)
+		}
+	}
+	return unused, nil
+}
+
+// deleteBytes removes attachments released by a committed transaction from
+// the bst
+func (f *FileSystem) deleteBytes(db string, pointers []string) error {
+	for _, pointer := range pointers {
+		err := f.bstore.Delete(db, pointer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package docfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/johnwilson/bytengine/filesystem"
+)
+
+// trashCollection keeps the deleted items of a database while the trash is
+// enabled
+const trashCollection = "trash"
+
+// TrashItem is a deleted file or directory together with the nodes below
+// it. The item id is the id of the deleted node.
+type TrashItem struct {
+	Id      string  `json:"id"`
+	Path    string  `json:"path"`
+	Deleted string  `json:"deleted"`
+	Nodes   []*Node `json:"nodes"`
+}
+
+// trashSettings checks if deleted items are moved to the trash and how long
+// they are kept. Items don't expire if expiry is 0.
+func trashSettings(tx Tx, db string) (bool, time.Duration, error) {
+	data, err := tx.Record(db, settingsCollection, "trash")
+	if err == ErrNotFound {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	var expiry int64
+	val, err := tx.Record(db, settingsCollection, "trashexpiry")
+	if err == nil {
+		expiry, err = strconv.ParseInt(string(val), 10, 64)
+	}
+	if err != nil && err != ErrNotFound {
+		return false, 0, err
+	}
+	return string(data) == "true", time.Duration(expiry), nil
+}
+
+// expired checks if the trash item has to be purged
+func expired(item *TrashItem, expiry time.Duration, now time.Time) bool {
+	if expiry <= 0 {
+		return false
+	}
+	deleted, err := time.Parse(time.RFC3339, item.Deleted)
+	if err != nil {
+		return false
+	}
+	return now.After(deleted.Add(expiry))
+}
+
+func putTrashItem(tx Tx, db string, item *TrashItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return tx.PutRecord(db, trashCollection, item.Id, data)
+}
+
+// walkTrash calls fn for every item in the trash of the database
+func walkTrash(tx Tx, db string, fn func(item *TrashItem) error) error {
+	return tx.Records(db, trashCollection, "", func(_ string, data []byte) error {
+		var item TrashItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		return fn(&item)
+	})
+}
+
+func readTrashItem(tx Tx, db, id string) (*TrashItem, error) {
+	data, err := tx.Record(db, trashCollection, id)
+	if err == ErrNotFound {
+		return nil, fmt.Errorf("trash item '%s' doesn't exist", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var item TrashItem
+	err = json.Unmarshal(data, &item)
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// purgeTrashItem permanently deletes an item and the history of its files.
+// The pointers of the attachments are returned so that they can be released.
+func purgeTrashItem(tx Tx, db string, item *TrashItem) ([]string, error) {
+	err := tx.RemoveRecord(db, trashCollection, item.Id)
+	if err != nil {
+		return nil, err
+	}
+	pointers := []string{}
+	for _, n := range item.Nodes {
+		if n.AHeader.Filepointer != "" {
+			pointers = append(pointers, n.AHeader.Filepointer)
+		}
+		if n.IsDir() {
+			continue
+		}
+		versions, err := removeHistory(tx, db, n.Id)
+		if err != nil {
+			return nil, err
+		}
+		pointers = append(pointers, versions...)
+	}
+	return pointers, nil
+}
+
+// purgeTrash permanently deletes the trash items accepted by fn and returns
+// their number
+func (f *FileSystem) purgeTrash(db string, fn func(item *TrashItem) bool) (int, error) {
+	count := 0
+	pointers := []string{}
+	err := f.backend.Update(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		items := []*TrashItem{}
+		err := walkTrash(tx, db, func(item *TrashItem) error {
+			if fn(item) {
+				items = append(items, item)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			list, err := purgeTrashItem(tx, db, item)
+			if err != nil {
+				return err
+			}
+			pointers = append(pointers, list...)
+		}
+		count = len(items)
+		pointers, err = releaseBytes(tx, db, pointers)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, f.deleteBytes(db, pointers)
+}
+
+// expireTrash purges the trash items which are older than the expiry set
+// for the database
+func (f *FileSystem) expireTrash(db string) error {
+	// expiry applies even if the trash has been disabled since
+	_, expiry, err := f.Trash(db)
+	if err != nil || expiry == 0 {
+		return err
+	}
+	now := time.Now()
+	_, err = f.purgeTrash(db, func(item *TrashItem) bool {
+		return expired(item, expiry, now)
+	})
+	return err
+}
+
+/*
+============================================================================
+    BFS Interface Methods
+============================================================================
+*/
+
+func (f *FileSystem) Trash(db string) (bool, time.Duration, error) {
+	var enabled bool
+	var expiry time.Duration
+	err := f.backend.View(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		var err error
+		enabled, expiry, err = trashSettings(tx, db)
+		return err
+	})
+	return enabled, expiry, err
+}
+
+func (f *FileSystem) SetTrash(db string, enabled bool, expiry time.Duration) error {
+	if expiry < 0 {
+		return errors.New("trash expiry can't be negative")
+	}
+	return f.backend.Update(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		// items in the trash are kept when it is disabled
+		err := tx.PutRecord(db, settingsCollection, "trash", []byte(strconv.FormatBool(enabled)))
+		if err != nil {
+			return err
+		}
+		return tx.PutRecord(db, settingsCollection, "trashexpiry", []byte(strconv.FormatInt(int64(expiry), 10)))
+	})
+}
+
+func (f *FileSystem) ListTrash(db string) ([]map[string]interface{}, error) {
+	err := f.expireTrash(db)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []map[string]interface{}{}
+	err = f.backend.View(func(tx Tx) error {
+		_, expiry, err := trashSettings(tx, db)
+		if err != nil {
+			return err
+		}
+		return walkTrash(tx, db, func(item *TrashItem) error {
+			info := map[string]interface{}{
+				"id":      item.Id,
+				"path":    item.Path,
+				"type":    "file",
+				"deleted": item.Deleted,
+			}
+			if item.Nodes[0].IsDir() {
+				info["type"] = "directory"
+				info["content_count"] = len(item.Nodes) - 1
+			}
+			if deleted, err := time.Parse(time.RFC3339, item.Deleted); err == nil && expiry > 0 {
+				info["expires"] = filesystem.FormatDatetime(deleted.Add(expiry))
+			}
+			list = append(list, info)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// latest deletions first
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i]["deleted"].(string), list[j]["deleted"].(string)
+		if a != b {
+			return a > b
+		}
+		return list[i]["path"].(string) < list[j]["path"].(string)
+	})
+	return list, nil
+}
+
+func (f *FileSystem) RestoreTrash(id, to, db string) (string, error) {
+	err := f.backend.Update(func(tx Tx) error {
+		if err := checkDatabase(tx, db); err != nil {
+			return err
+		}
+		item, err := readTrashItem(tx, db, id)
+		if err != nil {
+			return err
+		}
+		// restore to original location by default
+		if to == "" {
+			to = item.Path
+		}
+		to = path.Clean(to)
+		if to == "/" {
+			return errors.New("root directory can't be replaced")
+		}
+		parent, name := splitPath(to)
+
+		root := item.Nodes[0]
+		if root.IsDir() {
+			err = filesystem.ValidateDirName(name)
+		} else {
+			err = filesystem.ValidateFileName(name)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = findDirectory(tx, parent, db)
+		if err != nil {
+			return err
+		}
+		exists, err := pathExists(tx, to, db)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("'%s' already exists", to)
+		}
+
+		for _, n := range item.Nodes {
+			if n.Id == root.Id {
+				n.Header.Parent = parent
+				n.Header.Name = name
+			} else {
+				n.Header.Parent = replacePrefix(n.Header.Parent, item.Path, to)
+			}
+			err = tx.Put(db, n)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.RemoveRecord(db, trashCollection, id)
+	})
+	if err != nil {
+		return "", err
+	}
+	return to, nil
+}
+
+func (f *FileSystem) PurgeTrash(id, db string) (int, error) {
+	if id == "" {
+		// empty trash
+		return f.purgeTrash(db, func(item *TrashItem) bool {
+			return true
+		})
+	}
+	count, err := f.purgeTrash(db, func(item *TrashItem) bool {
+		return item.Id == id
+	})
+	if err == nil && count == 0 {
+		return 0, fmt.Errorf("trash item '%s' doesn't exist", id)
+	}
+	return count, err
+}
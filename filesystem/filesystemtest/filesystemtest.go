@@ -0,0 +1,1350 @@
+// Package filesystemtest provides a conformance test suite for
+// bytengine.FileSystem plugins.
+//
+// Plugin packages run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		filesystemtest.Run(t, func(t *testing.T) (bytengine.FileSystem, bytengine.ByteStore) {
+//			...
+//		})
+//	}
+//
+// Every sub test removes all existing databases with ClearAll so the plugin
+// must be configured to use a dedicated test server or location.
+package filesystemtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	_ "github.com/johnwilson/bytengine/parser/base"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a started file system plugin together with the byte store
+// it was started with
+type Factory func(t *testing.T) (bytengine.FileSystem, bytengine.ByteStore)
+
+const (
+	db   = "fstest"
+	user = "fstester"
+)
+
+// Run runs all conformance tests against the plugin created by newFS
+func Run(t *testing.T, newFS Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore)
+	}{
+		{"Databases", testDatabases},
+		{"Content", testContent},
+		{"RootProtection", testRootProtection},
+		{"RenameMoveCopy", testRenameMoveCopy},
+		{"Delete", testDelete},
+		{"FileAccess", testFileAccess},
+		{"Counters", testCounters},
+		{"Search", testSearch},
+		{"SetUnset", testSetUnset},
+		{"History", testHistory},
+		{"Attachments", testAttachments},
+		{"SharedAttachments", testSharedAttachments},
+		{"BytesVersions", testBytesVersions},
+		{"Trash", testTrash},
+		{"Revisions", testRevisions},
+		{"Atomic", testAtomic},
+		{"Pagination", testPagination},
+		{"Group", testGroup},
+		{"RecursiveScope", testRecursiveScope},
+		{"BulkDelete", testBulkDelete},
+		{"SetOperators", testSetOperators},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, bst := newFS(t)
+			_, err := fs.ClearAll()
+			require.Nil(t, err, "clear all failed")
+			tc.fn(t, fs, bst)
+		})
+	}
+}
+
+/*
+============================================================================
+    Helpers
+============================================================================
+*/
+
+// Normalize converts plugin specific map and number types into the types
+// used by encoding/json so that results of different plugins can be
+// compared.
+func Normalize(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var r interface{}
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return v
+	}
+	return r
+}
+
+func setup(t *testing.T, fs bytengine.FileSystem) {
+	require.Nil(t, fs.CreateDatabase(db), "database not created")
+}
+
+func newFile(t *testing.T, fs bytengine.FileSystem, p string, content map[string]interface{}) {
+	require.Nil(t, fs.NewFile(p, db, content, user), "file '%s' not created", p)
+}
+
+func readJson(t *testing.T, fs bytengine.FileSystem, p string, fields ...string) map[string]interface{} {
+	j, _, err := fs.ReadJson(p, db, fields)
+	require.Nil(t, err, "file '%s' couldn't be read", p)
+	val, ok := Normalize(j).(map[string]interface{})
+	require.True(t, ok, "file '%s' content isn't an object", p)
+	return val
+}
+
+func listDir(t *testing.T, fs bytengine.FileSystem, p string) map[string][]string {
+	list, err := fs.ListDir(p, "", db)
+	require.Nil(t, err, "directory '%s' listing failed", p)
+	return list
+}
+
+func writeBytes(t *testing.T, fs bytengine.FileSystem, p, data string) {
+	hint := bytengine.FileHint{Name: "data.txt", Size: int64(len(data))}
+	n, err := fs.WriteBytes(p, strings.NewReader(data), hint, db, user)
+	require.Nil(t, err, "write bytes to '%s' failed", p)
+	assert.Equal(t, int64(len(data)), n, "wrong number of bytes written")
+}
+
+func readBytes(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore, p string) string {
+	id, err := fs.ReadBytes(p, db)
+	require.Nil(t, err, "read bytes from '%s' failed", p)
+	var buf bytes.Buffer
+	err = bst.Read(db, id, &buf)
+	require.Nil(t, err, "attachment of '%s' couldn't be read", p)
+	return buf.String()
+}
+
+func search(t *testing.T, fs bytengine.FileSystem, script string) interface{} {
+	parser, err := bytengine.NewParser("base", "")
+	require.Nil(t, err, "parser not created")
+	cmd, err := parser.Parse(script)
+	require.Nil(t, err, "couldn't parse script")
+	rep, err := fs.BQLSearch(db, cmd[0].Args)
+	require.Nil(t, err, "search failed")
+	return rep
+}
+
+func searchPaths(t *testing.T, fs bytengine.FileSystem, script string) []string {
+	rep, ok := Normalize(search(t, fs, script)).([]interface{})
+	require.True(t, ok, "search result isn't a list")
+	paths := []string{}
+	for _, item := range rep {
+		paths = append(paths, item.(map[string]interface{})["path"].(string))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func parse(t *testing.T, script string) bytengine.Command {
+	parser, err := bytengine.NewParser("base", "")
+	require.Nil(t, err, "parser not created")
+	cmd, err := parser.Parse(script)
+	require.Nil(t, err, "couldn't parse script")
+	return cmd[0]
+}
+
+/*
+============================================================================
+    Tests
+============================================================================
+*/
+
+func testDatabases(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	assert.Nil(t, fs.CreateDatabase("fstest1"), "fstest1 not created")
+	assert.Nil(t, fs.CreateDatabase("fstest2"), "fstest2 not created")
+	assert.NotNil(t, fs.CreateDatabase("1fstest"), "invalid database name accepted")
+
+	list, err := fs.ListDatabase("")
+	assert.Nil(t, err, "listing dbs failed")
+	sort.Strings(list)
+	assert.Equal(t, []string{"fstest1", "fstest2"}, list, "wrong database list")
+
+	list, err = fs.ListDatabase("1$")
+	assert.Nil(t, err, "listing dbs failed")
+	assert.Equal(t, []string{"fstest1"}, list, "wrong filtered database list")
+
+	assert.Nil(t, fs.DropDatabase("fstest2"), "fstest2 not dropped")
+	assert.NotNil(t, fs.DropDatabase("fstest3"), "unknown database dropped")
+	list, err = fs.ListDatabase("")
+	assert.Nil(t, err, "listing dbs failed")
+	assert.Equal(t, []string{"fstest1"}, list, "wrong database list")
+
+	cleared, err := fs.ClearAll()
+	assert.Nil(t, err, "clear all failed")
+	assert.Equal(t, []string{"fstest1"}, cleared, "wrong cleared databases")
+	list, err = fs.ListDatabase("")
+	assert.Nil(t, err, "listing dbs failed")
+	assert.Len(t, list, 0, "databases not cleared")
+}
+
+func contentHash(t *testing.T, fs bytengine.FileSystem, p string) string {
+	info, err := fs.Info(p, db)
+	require.Nil(t, err, "info of '%s' failed", p)
+	hash, _ := info["hash"].(string)
+	return hash
+}
+
+func testContent(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+
+	assert.Nil(t, fs.NewDir("/var", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/var/www", db), "directory not created")
+	assert.NotNil(t, fs.NewDir("/var/www", db), "existing directory created")
+	assert.NotNil(t, fs.NewDir("/tmp/www", db), "directory created in missing parent")
+	assert.NotNil(t, fs.NewDir("/var/bad name", db), "invalid directory name accepted")
+
+	data := map[string]interface{}{
+		"title": "welcome",
+		"body":  "Hello world!",
+		"meta":  map[string]interface{}{"views": 10},
+	}
+	newFile(t, fs, "/var/www/index.html", data)
+	assert.NotNil(t, fs.NewFile("/var/www/index.html", db, data, user), "existing file created")
+	assert.NotNil(t, fs.NewFile("/tmp/index.html", db, data, user), "file created in missing directory")
+	assert.NotNil(t, fs.NewFile("/var/www/index.html/a", db, data, user), "file created in file")
+	assert.NotNil(t, fs.NewDir("/var/www/index.html/a", db), "directory created in file")
+
+	// listing
+	list := listDir(t, fs, "/")
+	assert.Equal(t, []string{"var"}, list["dirs"], "wrong root listing")
+	list = listDir(t, fs, "/var/www")
+	assert.Equal(t, []string{"index.html"}, list["files"], "wrong directory listing")
+	assert.Len(t, list["dirs"], 0, "wrong directory listing")
+	assert.Len(t, list["bfiles"], 0, "wrong directory listing")
+	newFile(t, fs, "/var/www/about.html", map[string]interface{}{})
+	list, err := fs.ListDir("/var/www", "^ab", db)
+	assert.Nil(t, err, "filtered listing failed")
+	assert.Equal(t, []string{"about.html"}, list["files"], "wrong filtered listing")
+	_, err = fs.ListDir("/tmp", "", db)
+	assert.NotNil(t, err, "missing directory listed")
+
+	// reading
+	val := readJson(t, fs, "/var/www/index.html")
+	assert.Equal(t, Normalize(data), val, "wrong file content")
+	val = readJson(t, fs, "/var/www/index.html", "title")
+	assert.Equal(t, map[string]interface{}{"title": "welcome"}, val, "wrong projected content")
+	_, _, err = fs.ReadJson("/var/www/missing.html", db, []string{})
+	assert.NotNil(t, err, "missing file read")
+	_, _, err = fs.ReadJson("/var/www", db, []string{})
+	assert.NotNil(t, err, "directory read as file")
+
+	// update
+	hash := contentHash(t, fs, "/var/www/index.html")
+	assert.NotEmpty(t, hash, "missing content hash")
+	err = fs.UpdateJson("/var/www/index.html", db, map[string]interface{}{"title": "updated"}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	val = readJson(t, fs, "/var/www/index.html")
+	assert.Equal(t, map[string]interface{}{"title": "updated"}, val, "file content not replaced")
+	assert.NotEqual(t, hash, contentHash(t, fs, "/var/www/index.html"), "content hash not updated")
+	hash = contentHash(t, fs, "/var/www/index.html")
+	err = fs.UpdateJson("/var/www/index.html", db, map[string]interface{}{"title": "updated"}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	assert.Equal(t, hash, contentHash(t, fs, "/var/www/index.html"), "content hash differs for same content")
+
+	// info
+	info, err := fs.Info("/var/www/index.html", db)
+	assert.Nil(t, err, "file info failed")
+	assert.Equal(t, "file", info["type"], "wrong node type")
+	assert.Equal(t, "index.html", info["name"], "wrong node name")
+	assert.Equal(t, "/var/www", info["parent"], "wrong node parent")
+	assert.Equal(t, false, info["public"], "new files should be private")
+	assert.Nil(t, info["bytes"], "file without attachment has bytes info")
+	modified, ok := info["modified"].(string)
+	assert.True(t, ok, "missing modification time")
+	_, err = time.Parse(time.RFC3339, modified)
+	assert.Nil(t, err, "invalid modification time")
+
+	info, err = fs.Info("/var/www", db)
+	assert.Nil(t, err, "directory info failed")
+	assert.Equal(t, "directory", info["type"], "wrong node type")
+	assert.EqualValues(t, 2, info["content_count"], "wrong directory content count")
+	_, err = fs.Info("/var/missing", db)
+	assert.NotNil(t, err, "missing node info")
+}
+
+func testRootProtection(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/var", db), "directory not created")
+
+	assert.NotNil(t, fs.NewDir("/", db), "root directory created")
+	assert.NotNil(t, fs.Delete("/", db, bytengine.AnyRevision), "root directory deleted")
+	assert.NotNil(t, fs.Rename("/", "root", db), "root directory renamed")
+	assert.NotNil(t, fs.Move("/", "/var", db), "root directory moved")
+	assert.NotNil(t, fs.Copy("/", "/var/root", db), "root directory copied")
+
+	list := listDir(t, fs, "/")
+	assert.Equal(t, []string{"var"}, list["dirs"], "root directory changed")
+}
+
+func testRenameMoveCopy(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/var", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/var/www", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/var/www/img", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/srv", db), "directory not created")
+	newFile(t, fs, "/var/www/index.html", map[string]interface{}{"title": "welcome"})
+	newFile(t, fs, "/var/www/img/logo", map[string]interface{}{"alt": "logo"})
+
+	// rename
+	assert.Nil(t, fs.Rename("/var/www/index.html", "home.html", db), "file rename failed")
+	assert.NotNil(t, fs.Rename("/var/www/img", "home.html", db), "rename to existing name")
+	assert.NotNil(t, fs.Rename("/var/www/missing", "other", db), "missing node renamed")
+	assert.Nil(t, fs.Rename("/var/www", "site", db), "directory rename failed")
+	assert.Equal(t, "welcome", readJson(t, fs, "/var/site/home.html")["title"], "renamed file lost")
+	assert.Equal(t, "logo", readJson(t, fs, "/var/site/img/logo")["alt"], "nested file lost after rename")
+	_, _, err := fs.ReadJson("/var/www/img/logo", db, []string{})
+	assert.NotNil(t, err, "old path still exists")
+
+	// move
+	assert.Nil(t, fs.Move("/var/site/home.html", "/srv", db), "file move failed")
+	assert.Equal(t, "welcome", readJson(t, fs, "/srv/home.html")["title"], "moved file lost")
+	assert.NotNil(t, fs.Move("/var", "/var/site/img", db), "directory moved into itself")
+	assert.NotNil(t, fs.Move("/var/site", "/missing", db), "moved to missing directory")
+	assert.NotNil(t, fs.Move("/var/site", "/srv/home.html", db), "moved into file")
+	assert.Nil(t, fs.Move("/var/site", "/srv", db), "directory move failed")
+	assert.Equal(t, "logo", readJson(t, fs, "/srv/site/img/logo")["alt"], "nested file lost after move")
+	list := listDir(t, fs, "/var")
+	assert.Len(t, list["dirs"], 0, "moved directory still listed")
+
+	// copy
+	assert.Nil(t, fs.Copy("/srv/home.html", "/var/home_copy.html", db), "file copy failed")
+	assert.Equal(t, "welcome", readJson(t, fs, "/var/home_copy.html")["title"], "copied file content differs")
+	assert.NotNil(t, fs.Copy("/srv/home.html", "/var/home_copy.html", db), "copied over existing file")
+	assert.NotNil(t, fs.Copy("/srv/missing", "/var/missing", db), "missing node copied")
+	assert.NotNil(t, fs.Copy("/srv", "/srv/site/srv", db), "directory copied into itself")
+	assert.Nil(t, fs.Copy("/srv/site", "/var/site2", db), "directory copy failed")
+	assert.Equal(t, "logo", readJson(t, fs, "/var/site2/img/logo")["alt"], "nested file not copied")
+	assert.Equal(t, "logo", readJson(t, fs, "/srv/site/img/logo")["alt"], "copy source changed")
+
+	// copies are independent
+	err = fs.UpdateJson("/var/site2/img/logo", db, map[string]interface{}{"alt": "changed"}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	assert.Equal(t, "logo", readJson(t, fs, "/srv/site/img/logo")["alt"], "copy shares content")
+}
+
+func testDelete(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/var", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/var/www", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/var2", db), "directory not created")
+	newFile(t, fs, "/var/index.html", map[string]interface{}{})
+	newFile(t, fs, "/var/www/index.html", map[string]interface{}{})
+	newFile(t, fs, "/var2/index.html", map[string]interface{}{})
+
+	assert.Nil(t, fs.Delete("/var/index.html", db, bytengine.AnyRevision), "file delete failed")
+	assert.NotNil(t, fs.Delete("/var/index.html", db, bytengine.AnyRevision), "missing file deleted")
+	assert.Nil(t, fs.Delete("/var", db, bytengine.AnyRevision), "directory delete failed")
+	_, _, err := fs.ReadJson("/var/www/index.html", db, []string{})
+	assert.NotNil(t, err, "nested file not deleted")
+	_, err = fs.Info("/var/www", db)
+	assert.NotNil(t, err, "nested directory not deleted")
+
+	// directories sharing a name prefix are kept
+	readJson(t, fs, "/var2/index.html")
+	list := listDir(t, fs, "/")
+	assert.Equal(t, []string{"var2"}, list["dirs"], "wrong root listing after delete")
+}
+
+func testFileAccess(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/www", db), "directory not created")
+	newFile(t, fs, "/www/index.html", map[string]interface{}{"title": "welcome"})
+
+	_, _, err := fs.DirectAccess("/www/index.html", db, "json")
+	assert.NotNil(t, err, "private file accessed")
+
+	// cascades to directory content
+	assert.Nil(t, fs.FileAccess("/www", db, false), "file access update failed")
+	info, err := fs.Info("/www/index.html", db)
+	assert.Nil(t, err, "file info failed")
+	assert.Equal(t, true, info["public"], "access not cascaded")
+
+	content, _, err := fs.DirectAccess("/www/index.html", db, "json")
+	assert.Nil(t, err, "direct access failed")
+	assert.Equal(t, map[string]interface{}{"title": "welcome"}, Normalize(content), "wrong direct access content")
+	_, _, err = fs.DirectAccess("/www/index.html", db, "bytes")
+	assert.NotNil(t, err, "empty byte layer accessed")
+	_, _, err = fs.DirectAccess("/www", db, "json")
+	assert.NotNil(t, err, "directory accessed as file")
+	_, _, err = fs.DirectAccess("/www/missing", db, "json")
+	assert.NotNil(t, err, "missing file accessed")
+
+	assert.Nil(t, fs.FileAccess("/www/index.html", db, true), "file access update failed")
+	_, _, err = fs.DirectAccess("/www/index.html", db, "json")
+	assert.NotNil(t, err, "protected file accessed")
+}
+
+func testCounters(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+
+	val, err := fs.SetCounter("users", "incr", 1, db)
+	assert.Nil(t, err, "counter action failed")
+	assert.Equal(t, int64(1), val, "counter action failed")
+
+	val, err = fs.SetCounter("users", "incr", 5, db)
+	assert.Nil(t, err, "counter action failed")
+	assert.Equal(t, int64(6), val, "counter action failed")
+
+	val, err = fs.SetCounter("users", "decr", 2, db)
+	assert.Nil(t, err, "counter action failed")
+	assert.Equal(t, int64(4), val, "counter action failed")
+
+	val, err = fs.SetCounter("users", "reset", 10, db)
+	assert.Nil(t, err, "counter action failed")
+	assert.Equal(t, int64(10), val, "counter action failed")
+
+	_, err = fs.SetCounter("bad name", "incr", 1, db)
+	assert.NotNil(t, err, "invalid counter name accepted")
+
+	_, err = fs.SetCounter("user1.likes", "incr", 1, db)
+	assert.Nil(t, err, "counter action failed")
+	_, err = fs.SetCounter("car.users", "incr", 1, db)
+	assert.Nil(t, err, "counter action failed")
+
+	list, err := fs.ListCounter("", db)
+	assert.Nil(t, err, "counter list failed")
+	assert.Equal(t, map[string]int64{"users": 10, "user1.likes": 1, "car.users": 1}, list, "wrong counter list")
+
+	list, err = fs.ListCounter("^USER", db)
+	assert.Nil(t, err, "counter list failed")
+	assert.Len(t, list, 2, "counter filter should be case insensitive")
+}
+
+func createUsers(t *testing.T, fs bytengine.FileSystem) {
+	assert.Nil(t, fs.NewDir("/users", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/staff", db), "directory not created")
+	newFile(t, fs, "/users/u1", map[string]interface{}{"name": "john", "age": 34, "country": "ghana"})
+	newFile(t, fs, "/users/u2", map[string]interface{}{"name": "jason", "age": 18, "country": "ghana"})
+	newFile(t, fs, "/users/u3", map[string]interface{}{"name": "juliette", "age": 18})
+	newFile(t, fs, "/users/u4", map[string]interface{}{"name": "michelle", "age": 21, "country": "uk"})
+	newFile(t, fs, "/users/u5", map[string]interface{}{"name": "dennis", "age": 22, "country": "france"})
+	newFile(t, fs, "/staff/s1", map[string]interface{}{"name": "jack", "age": 40, "country": "ghana"})
+}
+
+func testSearch(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	createUsers(t, fs)
+
+	paths := searchPaths(t, fs, `@test.select "name" in /users where "country" in ["ghana"]`)
+	assert.Equal(t, []string{"/users/u1", "/users/u2"}, paths, "search with 'in' failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users /staff where "country" == "ghana"`)
+	assert.Equal(t, []string{"/staff/s1", "/users/u1", "/users/u2"}, paths, "search in directories failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where "country" nin ["ghana", "uk"]`)
+	assert.Equal(t, []string{"/users/u3", "/users/u5"}, paths, "search with 'nin' failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where "age" >= 21 "age" < 30`)
+	assert.Equal(t, []string{"/users/u4", "/users/u5"}, paths, "search with comparisons failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where "age" != 18`)
+	assert.Equal(t, []string{"/users/u1", "/users/u4", "/users/u5"}, paths, "search with '!=' failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where regex("name","i") == "^J\\w*n$"`)
+	assert.Equal(t, []string{"/users/u1", "/users/u2"}, paths, "search with regex failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where exists("country") == false`)
+	assert.Equal(t, []string{"/users/u3"}, paths, "search with 'exists' failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where typeof("name") != "string"`)
+	assert.Len(t, paths, 0, "search with 'typeof' failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where "age" > 30 or "country" == "uk"`)
+	assert.Equal(t, []string{"/users/u1", "/users/u4"}, paths, "search with 'or' failed")
+
+	paths = searchPaths(t, fs, `@test.select "name" in /users where file_name == "u3"`)
+	assert.Equal(t, []string{"/users/u3"}, paths, "search on file name failed")
+
+	// count
+	count := search(t, fs, `@test.select "name" in /users where "age" == 18 count`)
+	assert.EqualValues(t, 2, count, "count failed")
+
+	// sort, limit and projection
+	rep := Normalize(search(t, fs, `@test.select "name" in /users sort desc "age" limit 2`))
+	expected := []interface{}{
+		map[string]interface{}{"path": "/users/u1", "content": map[string]interface{}{"name": "john"}},
+		map[string]interface{}{"path": "/users/u5", "content": map[string]interface{}{"name": "dennis"}},
+	}
+	assert.Equal(t, expected, rep, "sorted search failed")
+
+	// distinct
+	rep = Normalize(search(t, fs, `@test.select "country" in /users distinct "country"`))
+	list, ok := rep.([]interface{})
+	assert.True(t, ok, "distinct result isn't a list")
+	assert.ElementsMatch(t, []interface{}{"ghana", "uk", "france"}, list, "distinct failed")
+}
+
+func testSetUnset(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	createUsers(t, fs)
+
+	cmd := parse(t, `
+    @test.set "country"={"name":"ghana","major_cities":["kumasi","accra"]} "active"=true
+    in /users
+    where "country" == "ghana"`)
+	hash := contentHash(t, fs, "/users/u1")
+	count, err := fs.BQLSet(db, cmd.Args, user)
+	assert.Nil(t, err, "set data failed")
+	assert.Equal(t, 2, count, "set data failed")
+	assert.NotEqual(t, hash, contentHash(t, fs, "/users/u1"), "content hash not updated")
+
+	val := readJson(t, fs, "/users/u1")
+	assert.Equal(t, map[string]interface{}{
+		"name": "ghana", "major_cities": []interface{}{"kumasi", "accra"},
+	}, val["country"], "incorrect file content update")
+	assert.Equal(t, true, val["active"], "incorrect file content update")
+	assert.Equal(t, "ghana", readJson(t, fs, "/staff/s1")["country"], "file outside directory updated")
+
+	cmd = parse(t, `@test.set "age"+=2 in /users where "age" == 18`)
+	count, err = fs.BQLSet(db, cmd.Args, user)
+	assert.Nil(t, err, "increment failed")
+	assert.Equal(t, 2, count, "increment failed")
+	assert.EqualValues(t, 20, readJson(t, fs, "/users/u3")["age"], "field not incremented")
+
+	cmd = parse(t, `@test.unset "country" "active" in /users where exists("country") == true`)
+	count, err = fs.BQLUnset(db, cmd.Args, user)
+	assert.Nil(t, err, "unset data failed")
+	assert.Equal(t, 4, count, "unset data failed")
+
+	paths := searchPaths(t, fs, `@test.select "name" in /users where exists("country") == false`)
+	assert.Len(t, paths, 5, "fields not unset")
+	_, exists := readJson(t, fs, "/users/u1")["active"]
+	assert.False(t, exists, "fields not unset")
+}
+
+func history(t *testing.T, fs bytengine.FileSystem, p string) []interface{} {
+	list, err := fs.ListRevisions(p, db)
+	require.Nil(t, err, "history of '%s' couldn't be listed", p)
+	return Normalize(list).([]interface{})
+}
+
+func readRevision(t *testing.T, fs bytengine.FileSystem, p string, rev int64, fields ...string) map[string]interface{} {
+	j, err := fs.ReadRevision(p, db, rev, fields)
+	require.Nil(t, err, "revision %d of '%s' couldn't be read", rev, p)
+	val, ok := Normalize(j).(map[string]interface{})
+	require.True(t, ok, "revision %d of '%s' isn't an object", rev, p)
+	return val
+}
+
+func testHistory(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/docs", db), "directory not created")
+
+	// nothing is recorded by default
+	newFile(t, fs, "/docs/a", map[string]interface{}{"v": 1})
+	assert.Len(t, history(t, fs, "/docs/a"), 0, "history recorded without versioning")
+	on, err := fs.Versioning(db)
+	assert.Nil(t, err, "versioning setting not read")
+	assert.False(t, on, "versioning enabled by default")
+
+	assert.Nil(t, fs.SetVersioning(db, true), "versioning not enabled")
+	on, err = fs.Versioning(db)
+	assert.Nil(t, err, "versioning setting not read")
+	assert.True(t, on, "versioning not enabled")
+	assert.NotNil(t, fs.SetVersioning("missing", true), "versioning enabled for missing database")
+
+	// content of existing files is recorded before the first change
+	err = fs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 2}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	list := history(t, fs, "/docs/a")
+	require.Len(t, list, 2, "wrong number of revisions")
+	first := list[0].(map[string]interface{})
+	last := list[1].(map[string]interface{})
+	assert.EqualValues(t, 1, first["revision"], "wrong revision number")
+	assert.Equal(t, "", first["user"], "wrong revision user")
+	assert.EqualValues(t, 2, last["revision"], "wrong revision number")
+	assert.Equal(t, user, last["user"], "wrong revision user")
+	_, err = time.Parse(time.RFC3339, last["created"].(string))
+	assert.Nil(t, err, "revision time isn't RFC3339")
+	assert.Equal(t, map[string]interface{}{"v": float64(1)}, readRevision(t, fs, "/docs/a", 1), "wrong revision content")
+	assert.Equal(t, map[string]interface{}{"v": float64(2)}, readRevision(t, fs, "/docs/a", 2), "wrong revision content")
+
+	// new files and queries
+	newFile(t, fs, "/docs/b", map[string]interface{}{"v": 1, "name": "b"})
+	assert.Len(t, history(t, fs, "/docs/b"), 1, "new file not recorded")
+	count, err := fs.BQLSet(db, parse(t, `@test.set "v"=3 "name"="a" in /docs where "v" == 2`).Args, user)
+	assert.Nil(t, err, "set data failed")
+	assert.Equal(t, 1, count, "set data failed")
+	assert.Len(t, history(t, fs, "/docs/a"), 3, "set not recorded")
+	assert.Len(t, history(t, fs, "/docs/b"), 1, "unchanged file recorded")
+	assert.Equal(t, map[string]interface{}{"name": "a"}, readRevision(t, fs, "/docs/a", 3, "name"), "wrong projected revision")
+	_, err = fs.BQLUnset(db, parse(t, `@test.unset "name" in /docs`).Args, user)
+	assert.Nil(t, err, "unset data failed")
+	assert.Len(t, history(t, fs, "/docs/a"), 4, "unset not recorded")
+	assert.Len(t, history(t, fs, "/docs/b"), 2, "unset not recorded")
+
+	// revert
+	assert.Nil(t, fs.RevertJson("/docs/a", db, 1, user), "revert failed")
+	assert.Equal(t, map[string]interface{}{"v": float64(1)}, readJson(t, fs, "/docs/a"), "content not reverted")
+	list = history(t, fs, "/docs/a")
+	require.Len(t, list, 5, "revert not recorded")
+	assert.Equal(t, readRevision(t, fs, "/docs/a", 1), readRevision(t, fs, "/docs/a", 5), "wrong reverted revision")
+	assert.NotNil(t, fs.RevertJson("/docs/a", db, 99, user), "reverted to missing revision")
+	_, err = fs.ReadRevision("/docs/a", db, 99, nil)
+	assert.NotNil(t, err, "missing revision read")
+	_, err = fs.ListRevisions("/docs", db)
+	assert.NotNil(t, err, "history of directory listed")
+
+	// changes made while versioning is disabled are kept before the next
+	// recorded change
+	assert.Nil(t, fs.SetVersioning(db, false), "versioning not disabled")
+	err = fs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 9}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	assert.Len(t, history(t, fs, "/docs/a"), 5, "history recorded without versioning")
+	assert.Nil(t, fs.SetVersioning(db, true), "versioning not enabled")
+	err = fs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 10}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	assert.Len(t, history(t, fs, "/docs/a"), 7, "wrong number of revisions")
+	assert.Equal(t, map[string]interface{}{"v": float64(9)}, readRevision(t, fs, "/docs/a", 6), "unrecorded change lost")
+
+	// history follows renamed files and is removed with them
+	assert.Nil(t, fs.Rename("/docs/a", "c", db), "file not renamed")
+	assert.Len(t, history(t, fs, "/docs/c"), 7, "history lost on rename")
+	assert.Nil(t, fs.Delete("/docs", db, bytengine.AnyRevision), "directory not deleted")
+	assert.Nil(t, fs.NewDir("/docs", db), "directory not created")
+	newFile(t, fs, "/docs/c", map[string]interface{}{"v": 1})
+	assert.Len(t, history(t, fs, "/docs/c"), 1, "history of deleted file kept")
+}
+
+func testAttachments(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/files", db), "directory not created")
+	newFile(t, fs, "/files/doc", map[string]interface{}{"title": "document"})
+
+	_, err := fs.ReadBytes("/files/doc", db)
+	assert.NotNil(t, err, "empty byte layer read")
+
+	writeBytes(t, fs, "/files/doc", "Hello from bst!")
+	assert.Equal(t, "Hello from bst!", readBytes(t, fs, bst, "/files/doc"), "attachment content differs")
+
+	list := listDir(t, fs, "/files")
+	assert.Equal(t, []string{"doc"}, list["bfiles"], "file with attachment not listed")
+	assert.Len(t, list["files"], 0, "file with attachment listed as json file")
+
+	info, err := fs.Info("/files/doc", db)
+	assert.Nil(t, err, "file info failed")
+	bytesInfo, ok := Normalize(info["bytes"]).(map[string]interface{})
+	assert.True(t, ok, "missing bytes info")
+	assert.EqualValues(t, 15, bytesInfo["size"], "wrong attachment size")
+	assert.Contains(t, bytesInfo["mime"], "text/plain", "wrong attachment mime type")
+	sum := sha256.Sum256([]byte("Hello from bst!"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), bytesInfo["hash"], "wrong attachment hash")
+	assert.Equal(t, "data.txt", bytesInfo["filename"], "wrong attachment file name")
+	_, err = time.Parse(time.RFC3339, fmt.Sprint(bytesInfo["modified"]))
+	assert.Nil(t, err, "invalid attachment modification time")
+
+	// overwrite
+	writeBytes(t, fs, "/files/doc", "Updated content!")
+	assert.Equal(t, "Updated content!", readBytes(t, fs, bst, "/files/doc"), "attachment not updated")
+
+	// public access
+	assert.Nil(t, fs.FileAccess("/files/doc", db, false), "file access update failed")
+	_, id, err := fs.DirectAccess("/files/doc", db, "bytes")
+	assert.Nil(t, err, "direct access failed")
+	var buf bytes.Buffer
+	assert.Nil(t, bst.Read(db, id, &buf), "attachment couldn't be read")
+	assert.Equal(t, "Updated content!", buf.String(), "wrong direct access attachment")
+
+	// json content is kept
+	assert.Equal(t, "document", readJson(t, fs, "/files/doc")["title"], "json content changed")
+
+	// directories have no attachments
+	_, err = fs.WriteBytes("/files", strings.NewReader("data"), bytengine.FileHint{Size: 4}, db, user)
+	assert.NotNil(t, err, "attachment added to directory")
+
+	// delete attachment
+	assert.Nil(t, fs.DeleteBytes("/files/doc", db), "delete bytes failed")
+	_, err = fs.ReadBytes("/files/doc", db)
+	assert.NotNil(t, err, "deleted attachment read")
+	err = bst.Read(db, id, ioutil.Discard)
+	assert.NotNil(t, err, "attachment not removed from byte store")
+}
+
+func testSharedAttachments(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/files", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/backup", db), "directory not created")
+	newFile(t, fs, "/files/doc", map[string]interface{}{})
+	writeBytes(t, fs, "/files/doc", "shared content")
+
+	// copies share the attachment until one of them is deleted
+	assert.Nil(t, fs.Copy("/files/doc", "/files/doc2", db), "file copy failed")
+	assert.Nil(t, fs.Copy("/files/doc", "/backup/doc", db), "file copy failed")
+	assert.Equal(t, "shared content", readBytes(t, fs, bst, "/files/doc2"), "copied attachment differs")
+
+	// attachments are listed with the files making reference to them
+	id, err := fs.ReadBytes("/files/doc", db)
+	assert.Nil(t, err, "read bytes failed")
+	list, err := fs.ListBytes(db)
+	assert.Nil(t, err, "list bytes failed")
+	assert.Len(t, list, 1, "wrong number of attachments")
+	assert.ElementsMatch(t, []string{"/files/doc", "/files/doc2", "/backup/doc"}, list[id], "wrong attachment references")
+	_, err = fs.ListBytes("missing")
+	assert.NotNil(t, err, "attachments of missing database listed")
+
+	// writing to a copy doesn't change the other files
+	assert.Nil(t, fs.Copy("/files/doc", "/files/doc3", db), "file copy failed")
+	writeBytes(t, fs, "/files/doc3", "new content")
+	assert.Equal(t, "new content", readBytes(t, fs, bst, "/files/doc3"), "attachment not updated")
+	assert.Equal(t, "shared content", readBytes(t, fs, bst, "/files/doc"), "shared attachment overwritten")
+
+	assert.Nil(t, fs.Delete("/files/doc", db, bytengine.AnyRevision), "file delete failed")
+	assert.Equal(t, "shared content", readBytes(t, fs, bst, "/files/doc2"), "shared attachment deleted")
+
+	assert.Nil(t, fs.DeleteBytes("/files/doc2", db), "delete bytes failed")
+	assert.Equal(t, "shared content", readBytes(t, fs, bst, "/backup/doc"), "shared attachment deleted")
+
+	// copy of directory shares attachments with the original files
+	assert.Nil(t, fs.Copy("/backup", "/backup2", db), "directory copy failed")
+	assert.Nil(t, fs.Delete("/backup", db, bytengine.AnyRevision), "directory delete failed")
+	assert.Equal(t, "shared content", readBytes(t, fs, bst, "/backup2/doc"), "shared attachment deleted")
+
+	// last reference releases the attachment
+	id, err = fs.ReadBytes("/backup2/doc", db)
+	assert.Nil(t, err, "read bytes failed")
+	assert.Nil(t, fs.Delete("/backup2", db, bytengine.AnyRevision), "directory delete failed")
+	err = bst.Read(db, id, ioutil.Discard)
+	assert.NotNil(t, err, "unused attachment not removed from byte store")
+
+	// copies in the trash keep the attachment until they are purged
+	assert.Nil(t, fs.SetTrash(db, true, 0), "trash not enabled")
+	id, err = fs.ReadBytes("/files/doc3", db)
+	assert.Nil(t, err, "read bytes failed")
+	assert.Nil(t, fs.Copy("/files/doc3", "/files/doc4", db), "file copy failed")
+	assert.Nil(t, fs.Delete("/files/doc4", db, bytengine.AnyRevision), "file delete failed")
+	writeBytes(t, fs, "/files/doc3", "newer content")
+	assert.Equal(t, "new content", readBytesID(t, bst, id), "attachment of trash item removed")
+	_, err = fs.PurgeTrash("", db)
+	assert.Nil(t, err, "trash not emptied")
+	err = bst.Read(db, id, ioutil.Discard)
+	assert.NotNil(t, err, "attachment of purged item not removed from byte store")
+}
+
+func bytesVersions(t *testing.T, fs bytengine.FileSystem, p string) []map[string]interface{} {
+	list, err := fs.ListBytesVersions(p, db)
+	require.Nil(t, err, "versions of '%s' couldn't be listed", p)
+	return list
+}
+
+func readBytesVersion(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore, p string, version int64) string {
+	id, _, err := fs.ReadBytesVersion(p, db, version)
+	require.Nil(t, err, "version %d of '%s' couldn't be read", version, p)
+	var buf bytes.Buffer
+	err = bst.Read(db, id, &buf)
+	require.Nil(t, err, "version %d of '%s' couldn't be read", version, p)
+	return buf.String()
+}
+
+func testBytesVersions(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/files", db), "directory not created")
+	newFile(t, fs, "/files/doc", map[string]interface{}{})
+	writeBytes(t, fs, "/files/doc", "first")
+
+	// attachments are replaced without versioning
+	writeBytes(t, fs, "/files/doc", "second")
+	assert.Len(t, bytesVersions(t, fs, "/files/doc"), 0, "version kept without versioning")
+
+	assert.Nil(t, fs.SetVersioning(db, true), "versioning not enabled")
+	writeBytes(t, fs, "/files/doc", "third")
+	writeBytes(t, fs, "/files/doc", "fourth")
+	assert.Equal(t, "fourth", readBytes(t, fs, bst, "/files/doc"), "attachment not updated")
+
+	list := bytesVersions(t, fs, "/files/doc")
+	require.Len(t, list, 2, "wrong number of versions")
+	v1 := Normalize(list[0]).(map[string]interface{})
+	assert.EqualValues(t, 1, v1["version"], "wrong version number")
+	assert.EqualValues(t, 6, v1["size"], "wrong version size")
+	assert.Equal(t, user, v1["user"], "wrong uploader")
+	_, err := time.Parse(time.RFC3339, fmt.Sprint(v1["replaced"]))
+	assert.Nil(t, err, "invalid replacement time")
+	assert.Equal(t, "second", readBytesVersion(t, fs, bst, "/files/doc", 1), "wrong version content")
+	assert.Equal(t, "third", readBytesVersion(t, fs, bst, "/files/doc", 2), "wrong version content")
+	_, _, err = fs.ReadBytesVersion("/files/doc", db, 3)
+	assert.NotNil(t, err, "missing version read")
+
+	info, err := fs.Info("/files/doc", db)
+	assert.Nil(t, err, "file info failed")
+	assert.Equal(t, user, Normalize(info["bytes"]).(map[string]interface{})["user"], "uploader not recorded")
+
+	// deleted attachments are kept as a version
+	assert.Nil(t, fs.DeleteBytes("/files/doc", db), "delete bytes failed")
+	assert.Len(t, bytesVersions(t, fs, "/files/doc"), 3, "deleted attachment not kept")
+	assert.Equal(t, "fourth", readBytesVersion(t, fs, bst, "/files/doc", 3), "wrong version content")
+
+	// versions are listed as attachment references
+	id, _, err := fs.ReadBytesVersion("/files/doc", db, 1)
+	assert.Nil(t, err, "version not read")
+	refs, err := fs.ListBytes(db)
+	assert.Nil(t, err, "list bytes failed")
+	assert.Len(t, refs, 3, "wrong number of attachments")
+	assert.Equal(t, []string{"/files/doc (version 1)"}, refs[id], "wrong attachment references")
+
+	// pruning releases the oldest versions
+	_, err = fs.PruneBytesVersions("/files/doc", db, -1)
+	assert.NotNil(t, err, "negative number of versions kept")
+	n, err := fs.PruneBytesVersions("/files/doc", db, 1)
+	assert.Nil(t, err, "versions not pruned")
+	assert.Equal(t, 2, n, "wrong number of pruned versions")
+	err = bst.Read(db, id, ioutil.Discard)
+	assert.NotNil(t, err, "pruned version not removed from byte store")
+	list = bytesVersions(t, fs, "/files/doc")
+	require.Len(t, list, 1, "wrong number of versions")
+	assert.EqualValues(t, 3, list[0]["version"], "wrong version kept")
+
+	// versions are removed with their file
+	id, _, err = fs.ReadBytesVersion("/files/doc", db, 3)
+	assert.Nil(t, err, "version not read")
+	assert.Nil(t, fs.Delete("/files/doc", db, bytengine.AnyRevision), "file delete failed")
+	err = bst.Read(db, id, ioutil.Discard)
+	assert.NotNil(t, err, "version of deleted file not removed from byte store")
+}
+
+func readBytesID(t *testing.T, bst bytengine.ByteStore, id string) string {
+	var buf bytes.Buffer
+	err := bst.Read(db, id, &buf)
+	require.Nil(t, err, "attachment '%s' couldn't be read", id)
+	return buf.String()
+}
+
+func listTrash(t *testing.T, fs bytengine.FileSystem) []map[string]interface{} {
+	list, err := fs.ListTrash(db)
+	require.Nil(t, err, "trash couldn't be listed")
+	return list
+}
+
+func testTrash(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	on, expiry, err := fs.Trash(db)
+	assert.Nil(t, err, "trash setting not read")
+	assert.False(t, on, "trash enabled by default")
+	assert.Equal(t, time.Duration(0), expiry, "wrong default expiry")
+	_, _, err = fs.Trash("missing")
+	assert.NotNil(t, err, "trash setting of missing database read")
+	assert.NotNil(t, fs.SetTrash(db, true, -time.Hour), "negative expiry set")
+
+	assert.Nil(t, fs.SetTrash(db, true, 0), "trash not enabled")
+	on, _, err = fs.Trash(db)
+	assert.Nil(t, err, "trash setting not read")
+	assert.True(t, on, "trash not enabled")
+
+	assert.Nil(t, fs.NewDir("/docs", db), "directory not created")
+	assert.Nil(t, fs.NewDir("/docs/sub", db), "directory not created")
+	newFile(t, fs, "/docs/sub/a", map[string]interface{}{"v": 1})
+	writeBytes(t, fs, "/docs/sub/a", "attachment")
+	newFile(t, fs, "/docs/b", map[string]interface{}{"v": 2})
+	assert.Nil(t, fs.SetVersioning(db, true), "versioning not enabled")
+	err = fs.UpdateJson("/docs/b", db, map[string]interface{}{"v": 3}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	pointer, err := fs.ReadBytes("/docs/sub/a", db)
+	assert.Nil(t, err, "read bytes failed")
+
+	// deleted items keep their content, history and attachments
+	assert.Nil(t, fs.Delete("/docs", db, bytengine.AnyRevision), "directory not deleted")
+	_, err = fs.Info("/docs", db)
+	assert.NotNil(t, err, "deleted directory still exists")
+	list := listTrash(t, fs)
+	require.Len(t, list, 1, "wrong number of trash items")
+	item := Normalize(list[0]).(map[string]interface{})
+	assert.Equal(t, "/docs", item["path"], "wrong trash item path")
+	assert.Equal(t, "directory", item["type"], "wrong trash item type")
+	assert.EqualValues(t, 3, item["content_count"], "wrong trash item content count")
+	_, err = time.Parse(time.RFC3339, fmt.Sprint(item["deleted"]))
+	assert.Nil(t, err, "invalid deletion time")
+	_, hasexpiry := item["expires"]
+	assert.False(t, hasexpiry, "trash item expires without expiry")
+	assert.Equal(t, "attachment", readBytesID(t, bst, pointer), "attachment of trash item removed")
+
+	refs, err := fs.ListBytes(db)
+	assert.Nil(t, err, "list bytes failed")
+	assert.Equal(t, []string{"/docs/sub/a (trash)"}, refs[pointer], "wrong attachment references")
+
+	// restore to original location
+	id := fmt.Sprint(item["id"])
+	p, err := fs.RestoreTrash(id, "", db)
+	assert.Nil(t, err, "trash item not restored")
+	assert.Equal(t, "/docs", p, "wrong restored path")
+	assert.Equal(t, map[string]interface{}{"v": float64(1)}, Normalize(readJson(t, fs, "/docs/sub/a")), "restored content differs")
+	assert.Equal(t, "attachment", readBytes(t, fs, bst, "/docs/sub/a"), "restored attachment differs")
+	assert.Len(t, history(t, fs, "/docs/b"), 2, "history lost in trash")
+	assert.Len(t, listTrash(t, fs), 0, "restored item kept in trash")
+	_, err = fs.RestoreTrash(id, "", db)
+	assert.NotNil(t, err, "trash item restored twice")
+
+	// restore to a new location
+	assert.Nil(t, fs.Delete("/docs/b", db, bytengine.AnyRevision), "file not deleted")
+	newFile(t, fs, "/docs/b", map[string]interface{}{"v": 4})
+	list = listTrash(t, fs)
+	require.Len(t, list, 1, "wrong number of trash items")
+	assert.Equal(t, "file", list[0]["type"], "wrong trash item type")
+	id = fmt.Sprint(list[0]["id"])
+	_, err = fs.RestoreTrash(id, "", db)
+	assert.NotNil(t, err, "existing file replaced by restore")
+	_, err = fs.RestoreTrash(id, "/missing/b", db)
+	assert.NotNil(t, err, "trash item restored to missing directory")
+	p, err = fs.RestoreTrash(id, "/docs/sub/b2", db)
+	assert.Nil(t, err, "trash item not restored")
+	assert.Equal(t, "/docs/sub/b2", p, "wrong restored path")
+	assert.Equal(t, map[string]interface{}{"v": float64(3)}, Normalize(readJson(t, fs, "/docs/sub/b2")), "restored content differs")
+	assert.Equal(t, map[string]interface{}{"v": float64(4)}, Normalize(readJson(t, fs, "/docs/b")), "existing file changed")
+
+	// directories are restored below a new parent
+	assert.Nil(t, fs.Delete("/docs/sub", db, bytengine.AnyRevision), "directory not deleted")
+	id = fmt.Sprint(listTrash(t, fs)[0]["id"])
+	_, err = fs.RestoreTrash(id, "/sub2", db)
+	assert.Nil(t, err, "trash item not restored")
+	assert.Equal(t, "attachment", readBytes(t, fs, bst, "/sub2/a"), "restored attachment differs")
+	assert.Len(t, listDir(t, fs, "/sub2")["files"], 1, "wrong restored directory listing")
+
+	// purged items release their attachments
+	assert.Nil(t, fs.Delete("/sub2", db, bytengine.AnyRevision), "directory not deleted")
+	assert.Nil(t, fs.Delete("/docs/b", db, bytengine.AnyRevision), "file not deleted")
+	list = listTrash(t, fs)
+	require.Len(t, list, 2, "wrong number of trash items")
+	_, err = fs.PurgeTrash("missing", db)
+	assert.NotNil(t, err, "missing trash item purged")
+	for _, item := range list {
+		if item["path"] != "/sub2" {
+			continue
+		}
+		n, err := fs.PurgeTrash(fmt.Sprint(item["id"]), db)
+		assert.Nil(t, err, "trash item not purged")
+		assert.Equal(t, 1, n, "wrong number of purged items")
+	}
+	err = bst.Read(db, pointer, ioutil.Discard)
+	assert.NotNil(t, err, "attachment of purged item not removed from byte store")
+	n, err := fs.PurgeTrash("", db)
+	assert.Nil(t, err, "trash not emptied")
+	assert.Equal(t, 1, n, "wrong number of purged items")
+	assert.Len(t, listTrash(t, fs), 0, "trash not emptied")
+
+	// items are deleted right away once the trash is disabled
+	assert.Nil(t, fs.SetTrash(db, false, 0), "trash not disabled")
+	assert.Nil(t, fs.Delete("/docs", db, bytengine.AnyRevision), "directory not deleted")
+	assert.Len(t, listTrash(t, fs), 0, "item moved to disabled trash")
+
+	// expired items are purged
+	assert.Nil(t, fs.SetTrash(db, true, time.Hour), "trash not enabled")
+	newFile(t, fs, "/c", map[string]interface{}{})
+	assert.Nil(t, fs.Delete("/c", db, bytengine.AnyRevision), "file not deleted")
+	list = listTrash(t, fs)
+	require.Len(t, list, 1, "wrong number of trash items")
+	_, hasexpiry = list[0]["expires"]
+	assert.True(t, hasexpiry, "missing trash item expiry")
+	assert.Nil(t, fs.SetTrash(db, true, time.Millisecond), "trash expiry not set")
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, listTrash(t, fs), 0, "expired item kept in trash")
+}
+
+func revision(t *testing.T, fs bytengine.FileSystem, p string) int64 {
+	_, rev, err := fs.ReadJson(p, db, []string{})
+	require.Nil(t, err, "file '%s' couldn't be read", p)
+	return rev
+}
+
+func testRevisions(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/docs", db), "directory not created")
+	newFile(t, fs, "/docs/a", map[string]interface{}{"v": 1})
+	assert.EqualValues(t, 1, revision(t, fs, "/docs/a"), "wrong revision of new file")
+	info, err := fs.Info("/docs/a", db)
+	require.Nil(t, err, "file info failed")
+	assert.EqualValues(t, 1, info["revision"], "wrong revision in file info")
+
+	// every change increments the revision
+	err = fs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 2}, user, bytengine.AnyRevision)
+	assert.Nil(t, err, "file update failed")
+	err = fs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 3}, user, 2)
+	assert.Nil(t, err, "file update with current revision failed")
+	assert.EqualValues(t, 3, revision(t, fs, "/docs/a"), "revision not incremented")
+
+	// stale revisions are rejected
+	err = fs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 9}, user, 2)
+	require.NotNil(t, err, "file update with stale revision accepted")
+	conflict, ok := err.(*bytengine.ConflictError)
+	require.True(t, ok, "wrong error type for revision conflict")
+	assert.Equal(t, "/docs/a", conflict.Path, "wrong conflict path")
+	assert.EqualValues(t, 2, conflict.Expected, "wrong expected revision")
+	assert.EqualValues(t, 3, conflict.Current, "wrong current revision")
+	assert.EqualValues(t, 3, readJson(t, fs, "/docs/a")["v"], "file changed by rejected update")
+
+	// set and unset
+	cmd := parse(t, `@test.set "v"=4 in /docs where "v" == 3`)
+	cmd.Args["ifrev"] = int64(1)
+	_, err = fs.BQLSet(db, cmd.Args, user)
+	_, ok = err.(*bytengine.ConflictError)
+	assert.True(t, ok, "set with stale revision accepted")
+	assert.EqualValues(t, 3, readJson(t, fs, "/docs/a")["v"], "file changed by rejected set")
+	cmd.Args["ifrev"] = int64(3)
+	count, err := fs.BQLSet(db, cmd.Args, user)
+	assert.Nil(t, err, "set with current revision failed")
+	assert.Equal(t, 1, count, "wrong number of updated files")
+	assert.EqualValues(t, 4, revision(t, fs, "/docs/a"), "revision not incremented by set")
+	cmd = parse(t, `@test.unset "v" in /docs`)
+	_, err = fs.BQLUnset(db, cmd.Args, user)
+	assert.Nil(t, err, "unset failed")
+	assert.EqualValues(t, 5, revision(t, fs, "/docs/a"), "revision not incremented by unset")
+
+	// copies start over
+	assert.Nil(t, fs.Copy("/docs/a", "/docs/b", db), "file copy failed")
+	assert.EqualValues(t, 1, revision(t, fs, "/docs/b"), "wrong revision of copied file")
+
+	// delete
+	_, ok = fs.Delete("/docs/a", db, 4).(*bytengine.ConflictError)
+	assert.True(t, ok, "file deleted with stale revision")
+	assert.NotNil(t, fs.Delete("/docs", db, 1), "directory deleted with revision check")
+	assert.Nil(t, fs.Delete("/docs/a", db, 5), "file delete with current revision failed")
+}
+
+func testAtomic(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/docs", db), "directory not created")
+	newFile(t, fs, "/docs/a", map[string]interface{}{"v": 1})
+	newFile(t, fs, "/docs/b", map[string]interface{}{"v": 1})
+	writeBytes(t, fs, "/docs/b", "Hello from bst!")
+	id, err := fs.ReadBytes("/docs/b", db)
+	require.Nil(t, err, "attachment id not found")
+
+	// failed scripts don't change anything
+	failure := fmt.Errorf("script failed")
+	err = fs.Atomic(func(afs bytengine.FileSystem) error {
+		require.Nil(t, afs.NewFile("/docs/c", db, map[string]interface{}{}, user), "file not created")
+		err := afs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 2}, user, bytengine.AnyRevision)
+		require.Nil(t, err, "file not updated")
+		require.Nil(t, afs.Rename("/docs", "renamed", db), "directory not renamed")
+		require.Nil(t, afs.Delete("/renamed/b", db, bytengine.AnyRevision), "file not deleted")
+		_, err = afs.SetCounter("users", "incr", 1, db)
+		require.Nil(t, err, "counter not set")
+		// changes are visible within the script
+		_, _, err = afs.ReadJson("/renamed/c", db, []string{})
+		assert.Nil(t, err, "new file not found in script")
+		return failure
+	})
+	assert.Equal(t, failure, err, "wrong atomic script error")
+	list := listDir(t, fs, "/docs")
+	assert.Equal(t, []string{"a"}, list["files"], "changes of failed script applied")
+	assert.Equal(t, []string{"b"}, list["bfiles"], "changes of failed script applied")
+	assert.EqualValues(t, 1, readJson(t, fs, "/docs/a")["v"], "file update of failed script applied")
+	assert.EqualValues(t, 1, revision(t, fs, "/docs/a"), "revision of failed script kept")
+	assert.Equal(t, "Hello from bst!", readBytes(t, fs, bst, "/docs/b"), "attachment of failed script removed")
+	counters, err := fs.ListCounter("", db)
+	assert.Nil(t, err, "counters not listed")
+	assert.Len(t, counters, 0, "counter of failed script kept")
+
+	// overwritten attachments keep their content until the changes are
+	// applied
+	err = fs.Atomic(func(afs bytengine.FileSystem) error {
+		writeBytes(t, afs, "/docs/b", "Updated content")
+		assert.Equal(t, "Updated content", readBytes(t, afs, bst, "/docs/b"), "attachment not updated in script")
+		return failure
+	})
+	assert.Equal(t, failure, err, "wrong atomic script error")
+	assert.Equal(t, "Hello from bst!", readBytes(t, fs, bst, "/docs/b"), "attachment overwritten by failed script")
+
+	err = fs.Atomic(func(afs bytengine.FileSystem) error {
+		writeBytes(t, afs, "/docs/b", "Updated content")
+		return nil
+	})
+	assert.Nil(t, err, "atomic script failed")
+	assert.Equal(t, "Updated content", readBytes(t, fs, bst, "/docs/b"), "attachment update of script not applied")
+	assert.NotNil(t, bst.Read(db, id, ioutil.Discard), "replaced attachment not removed")
+	id, err = fs.ReadBytes("/docs/b", db)
+	require.Nil(t, err, "attachment id not found")
+
+	// errors returned by commands are passed on
+	err = fs.Atomic(func(afs bytengine.FileSystem) error {
+		err := afs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 2}, user, bytengine.AnyRevision)
+		require.Nil(t, err, "file not updated")
+		return afs.UpdateJson("/docs/b", db, map[string]interface{}{"v": 2}, user, 5)
+	})
+	_, ok := err.(*bytengine.ConflictError)
+	assert.True(t, ok, "wrong atomic script error")
+	assert.EqualValues(t, 1, readJson(t, fs, "/docs/a")["v"], "file update of failed script applied")
+
+	// successful scripts apply all changes
+	err = fs.Atomic(func(afs bytengine.FileSystem) error {
+		if err := afs.NewFile("/docs/c", db, map[string]interface{}{}, user); err != nil {
+			return err
+		}
+		if err := afs.Delete("/docs/b", db, bytengine.AnyRevision); err != nil {
+			return err
+		}
+		// attachments are removed once the changes are applied
+		assert.Nil(t, bst.Read(db, id, ioutil.Discard), "attachment removed before changes applied")
+		return afs.UpdateJson("/docs/a", db, map[string]interface{}{"v": 3}, user, 1)
+	})
+	assert.Nil(t, err, "atomic script failed")
+	assert.Equal(t, []string{"a", "c"}, listDir(t, fs, "/docs")["files"], "changes of script not applied")
+	assert.EqualValues(t, 3, readJson(t, fs, "/docs/a")["v"], "file update of script not applied")
+	assert.NotNil(t, bst.Read(db, id, ioutil.Discard), "attachment of deleted file not removed")
+
+	// databases can't be dropped
+	err = fs.Atomic(func(afs bytengine.FileSystem) error {
+		return afs.DropDatabase(db)
+	})
+	assert.NotNil(t, err, "database dropped in atomic script")
+	assert.Equal(t, []string{"a", "c"}, listDir(t, fs, "/docs")["files"], "database dropped in atomic script")
+}
+
+func testPagination(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	createUsers(t, fs)
+
+	names := func(rep interface{}) []string {
+		list := []string{}
+		for _, item := range rep.([]interface{}) {
+			content := item.(map[string]interface{})["content"]
+			list = append(list, content.(map[string]interface{})["name"].(string))
+		}
+		return list
+	}
+
+	// offset
+	rep := Normalize(search(t, fs, `@test.select "name" in /users sort asc "name" offset 1 limit 2`))
+	assert.Equal(t, []string{"jason", "john"}, names(rep), "search with offset failed")
+	rep = Normalize(search(t, fs, `@test.select "name" in /users offset 10`))
+	assert.Len(t, rep, 0, "search with offset past the results failed")
+
+	// cursors
+	query := `@test.select "name" in /users where "age" < 30 sort asc "name" limit 2 cursor "%s"`
+	list := []string{}
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		page, ok := Normalize(search(t, fs, fmt.Sprintf(query, cursor))).(map[string]interface{})
+		require.True(t, ok, "page isn't an object")
+		list = append(list, names(page["results"])...)
+		if page["cursor"] == nil {
+			break
+		}
+		cursor = page["cursor"].(string)
+	}
+	assert.Equal(t, []string{"dennis", "jason", "juliette", "michelle"}, list, "wrong pages")
+	assert.NotEqual(t, "", cursor, "missing cursor")
+
+	page := Normalize(search(t, fs, fmt.Sprintf(query, cursor))).(map[string]interface{})
+	assert.Equal(t, []string{"juliette", "michelle"}, names(page["results"]), "cursor isn't reusable")
+	assert.Nil(t, page["cursor"], "cursor returned on last page")
+
+	// cursors are bound to their query
+	cmd := parse(t, fmt.Sprintf(`@test.select "name" in /users limit 2 cursor "%s"`, cursor))
+	_, err := fs.BQLSearch(db, cmd.Args)
+	assert.NotNil(t, err, "cursor of another query accepted")
+	cmd = parse(t, `@test.select "name" in /users limit 2 cursor "invalid"`)
+	_, err = fs.BQLSearch(db, cmd.Args)
+	assert.NotNil(t, err, "invalid cursor accepted")
+}
+
+func testGroup(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	createUsers(t, fs)
+
+	rep := Normalize(search(t, fs, `@test.select in /users where "age" < 30
+	group by "country" sum("age") avg("age") min("name") max("age")`))
+	expected := []interface{}{
+		map[string]interface{}{
+			"group": map[string]interface{}{"country": nil}, "count": 1.0,
+			"sum(age)": 18.0, "avg(age)": 18.0, "min(name)": "juliette", "max(age)": 18.0,
+		},
+		map[string]interface{}{
+			"group": map[string]interface{}{"country": "france"}, "count": 1.0,
+			"sum(age)": 22.0, "avg(age)": 22.0, "min(name)": "dennis", "max(age)": 22.0,
+		},
+		map[string]interface{}{
+			"group": map[string]interface{}{"country": "ghana"}, "count": 1.0,
+			"sum(age)": 18.0, "avg(age)": 18.0, "min(name)": "jason", "max(age)": 18.0,
+		},
+		map[string]interface{}{
+			"group": map[string]interface{}{"country": "uk"}, "count": 1.0,
+			"sum(age)": 21.0, "avg(age)": 21.0, "min(name)": "michelle", "max(age)": 21.0,
+		},
+	}
+	assert.Equal(t, expected, rep, "group by content field failed")
+
+	rep = Normalize(search(t, fs, `@test.select in /users /staff group by "country" avg("age") min("nickname")`))
+	rows := rep.([]interface{})
+	require.Len(t, rows, 4, "wrong number of groups")
+	ghana := rows[2].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"country": "ghana"}, ghana["group"], "wrong group order")
+	assert.Equal(t, 3.0, ghana["count"], "wrong group count")
+	assert.Equal(t, 92.0/3, ghana["avg(age)"], "wrong group average")
+	assert.Nil(t, ghana["min(nickname)"], "aggregate of missing field isn't null")
+
+	// file metadata
+	assert.Nil(t, fs.NewDir("/files", db), "directory not created")
+	newFile(t, fs, "/files/a", map[string]interface{}{})
+	newFile(t, fs, "/files/b", map[string]interface{}{})
+	writeBytes(t, fs, "/files/a", "hello")
+	writeBytes(t, fs, "/files/b", "hi")
+	rep = Normalize(search(t, fs, `@test.select in /files group by file_mime sum(file_size) max(file_size)`))
+	rows = rep.([]interface{})
+	require.Len(t, rows, 1, "wrong number of mime groups")
+	row := rows[0].(map[string]interface{})
+	assert.Contains(t, row["group"], "file_mime", "missing group value")
+	assert.Equal(t, 2.0, row["count"], "wrong mime group count")
+	assert.Equal(t, 7.0, row["sum(file_size)"], "wrong attachment size sum")
+	assert.Equal(t, 5.0, row["max(file_size)"], "wrong attachment size maximum")
+}
+
+func testRecursiveScope(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	for _, dir := range []string{"/docs", "/docs/sub", "/docs/sub/deep", "/docs2", "/Docs"} {
+		require.Nil(t, fs.NewDir(dir, db), "directory '%s' not created", dir)
+	}
+	newFile(t, fs, "/docs/a", map[string]interface{}{"v": 1})
+	newFile(t, fs, "/docs/sub/b", map[string]interface{}{"v": 2})
+	newFile(t, fs, "/docs/sub/deep/c", map[string]interface{}{"v": 3})
+	newFile(t, fs, "/docs2/d", map[string]interface{}{"v": 4})
+	newFile(t, fs, "/Docs/e", map[string]interface{}{"v": 5})
+
+	paths := searchPaths(t, fs, `@test.select "v" in /docs/**`)
+	assert.Equal(t, []string{"/docs/a", "/docs/sub/b", "/docs/sub/deep/c"}, paths, "recursive search failed")
+	paths = searchPaths(t, fs, `@test.select "v" in /docs /docs/sub/** where "v" > 1`)
+	assert.Equal(t, []string{"/docs/sub/b", "/docs/sub/deep/c"}, paths, "recursive search with where failed")
+	paths = searchPaths(t, fs, `@test.select "v" in /docs/sub /docs/**`)
+	assert.Len(t, paths, 3, "files in overlapping scopes returned twice")
+	count := search(t, fs, `@test.select "v" in /docs/sub/** count`)
+	assert.EqualValues(t, 2, count, "recursive count failed")
+	count = search(t, fs, `@test.select "v" in / --recursive count`)
+	assert.EqualValues(t, 5, count, "recursive count of database failed")
+
+	count, err := fs.BQLSet(db, parse(t, `@test.set "seen"=true in /docs/**`).Args, user)
+	assert.Nil(t, err, "recursive set failed")
+	assert.Equal(t, 3, count, "recursive set failed")
+	assert.Equal(t, true, readJson(t, fs, "/docs/sub/deep/c")["seen"], "nested file not updated")
+	_, exists := readJson(t, fs, "/docs2/d")["seen"]
+	assert.False(t, exists, "file outside scope updated")
+	_, exists = readJson(t, fs, "/Docs/e")["seen"]
+	assert.False(t, exists, "file of directory with other case updated")
+
+	count, err = fs.BQLUnset(db, parse(t, `@test.unset "seen" in /docs/sub/**`).Args, user)
+	assert.Nil(t, err, "recursive unset failed")
+	assert.Equal(t, 2, count, "recursive unset failed")
+	assert.Equal(t, true, readJson(t, fs, "/docs/a")["seen"], "file outside scope changed")
+}
+
+func testBulkDelete(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	for _, dir := range []string{"/logs", "/logs/old", "/backup"} {
+		require.Nil(t, fs.NewDir(dir, db), "directory '%s' not created", dir)
+	}
+	newFile(t, fs, "/logs/l1", map[string]interface{}{"level": "debug"})
+	newFile(t, fs, "/logs/l2", map[string]interface{}{"level": "debug"})
+	newFile(t, fs, "/logs/l3", map[string]interface{}{"level": "info"})
+	newFile(t, fs, "/logs/old/l4", map[string]interface{}{"level": "debug"})
+	writeBytes(t, fs, "/logs/l1", "shared")
+	writeBytes(t, fs, "/logs/l2", "unshared")
+	assert.Nil(t, fs.Copy("/logs/l1", "/backup/l1", db), "file copy failed")
+	pointer, err := fs.ReadBytes("/logs/l2", db)
+	require.Nil(t, err, "read bytes failed")
+
+	// files changed since the expected revision prevent the deletion
+	cmd := parse(t, `@test.delete in /logs where "level" == "debug" --ifrev=3`)
+	cmd.Args["ifrev"] = cmd.Options["ifrev"]
+	_, err = fs.BQLDelete(db, cmd.Args)
+	_, ok := err.(*bytengine.ConflictError)
+	assert.True(t, ok, "deletion of changed files not rejected")
+	assert.Equal(t, []string{"l1", "l2"}, listDir(t, fs, "/logs")["bfiles"], "files deleted despite conflict")
+
+	cmd = parse(t, `@test.delete in /logs where "level" == "debug"`)
+	paths, err := fs.BQLDelete(db, cmd.Args)
+	assert.Nil(t, err, "bulk delete failed")
+	assert.Equal(t, []string{"/logs/l1", "/logs/l2"}, paths, "wrong deleted paths")
+	assert.Equal(t, []string{"l3"}, listDir(t, fs, "/logs")["files"], "wrong files left")
+	assert.Equal(t, []string{"old"}, listDir(t, fs, "/logs")["dirs"], "sub directory deleted")
+
+	// attachments are released unless other files make reference to them
+	assert.Equal(t, "shared", readBytes(t, fs, bst, "/backup/l1"), "shared attachment deleted")
+	err = bst.Read(db, pointer, ioutil.Discard)
+	assert.NotNil(t, err, "unused attachment not removed from byte store")
+
+	// deleted files are kept in the trash if enabled
+	assert.Nil(t, fs.SetTrash(db, true, 0), "trash not enabled")
+	paths, err = fs.BQLDelete(db, parse(t, `@test.delete in /logs/** where "level" == "debug"`).Args)
+	assert.Nil(t, err, "recursive bulk delete failed")
+	assert.Equal(t, []string{"/logs/old/l4"}, paths, "wrong recursively deleted paths")
+	items, err := fs.ListTrash(db)
+	assert.Nil(t, err, "trash not listed")
+	require.Len(t, items, 1, "deleted file not moved to the trash")
+	assert.Equal(t, "/logs/old/l4", items[0]["path"], "wrong trash item")
+
+	paths, err = fs.BQLDelete(db, parse(t, `@test.delete in /logs where "level" == "debug"`).Args)
+	assert.Nil(t, err, "bulk delete without match failed")
+	assert.Len(t, paths, 0, "files deleted without match")
+}
+
+func testSetOperators(t *testing.T, fs bytengine.FileSystem, bst bytengine.ByteStore) {
+	setup(t, fs)
+	assert.Nil(t, fs.NewDir("/items", db), "directory not created")
+	newFile(t, fs, "/items/a", map[string]interface{}{
+		"tags":  []interface{}{"x", "y", "x"},
+		"price": 10,
+		"low":   5,
+		"high":  5,
+		"old":   map[string]interface{}{"v": 1},
+	})
+
+	set := func(script string) {
+		count, err := fs.BQLSet(db, parse(t, script).Args, user)
+		require.Nil(t, err, "'%s' failed", script)
+		assert.Equal(t, 1, count, "wrong number of files updated by '%s'", script)
+	}
+	set(`@test.set "tags" push "z" "price" *= 1.5 "low" min 3 "high" min 8 in /items`)
+	val := readJson(t, fs, "/items/a")
+	assert.Equal(t, []interface{}{"x", "y", "x", "z"}, val["tags"], "value not pushed")
+	assert.EqualValues(t, 15, val["price"], "value not multiplied")
+	assert.EqualValues(t, 3, val["low"], "lower value not set")
+	assert.EqualValues(t, 5, val["high"], "greater value set by min")
+
+	set(`@test.set "tags" pull "x" "high" max 8 "low" max 1 "list" push 1 "qty" *= 2 in /items`)
+	val = readJson(t, fs, "/items/a")
+	assert.Equal(t, []interface{}{"y", "z"}, val["tags"], "values not pulled")
+	assert.EqualValues(t, 8, val["high"], "greater value not set")
+	assert.EqualValues(t, 3, val["low"], "lower value set by max")
+	assert.Equal(t, []interface{}{1.0}, val["list"], "array not created by push")
+	assert.EqualValues(t, 0, val["qty"], "missing multiplied field not set to 0")
+
+	set(`@test.set "tags" addtoset "y" "list" addtoset 2 "old.v" rename "new" in /items`)
+	val = readJson(t, fs, "/items/a")
+	assert.Equal(t, []interface{}{"y", "z"}, val["tags"], "existing value added to set")
+	assert.Equal(t, []interface{}{1.0, 2.0}, val["list"], "value not added to set")
+	assert.Equal(t, map[string]interface{}{}, val["old"], "field not renamed")
+	assert.EqualValues(t, 1, val["new"], "field not renamed")
+
+	set(`@test.set "tags" pop first "list" pop last "updated" now in /items`)
+	val = readJson(t, fs, "/items/a")
+	assert.Equal(t, []interface{}{"z"}, val["tags"], "first value not popped")
+	assert.Equal(t, []interface{}{1.0}, val["list"], "last value not popped")
+	updated, ok := val["updated"].(string)
+	require.True(t, ok, "current timestamp not set")
+	_, err := time.Parse(time.RFC3339, updated)
+	assert.Nil(t, err, "invalid current timestamp")
+
+	// array operators fail on other values and leave the files unchanged
+	_, err = fs.BQLSet(db, parse(t, `@test.set "price" push 1 in /items`).Args, user)
+	assert.NotNil(t, err, "value pushed to number")
+	assert.EqualValues(t, 15, readJson(t, fs, "/items/a")["price"], "file changed by failed update")
+}
@@ -0,0 +1,397 @@
+// Package memory provides a file system plugin that keeps all databases in
+// memory. It requires no external service which makes it suitable for tests
+// and embedded use. All content is lost when the process exits.
+package memory
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/filesystem/docfs"
+)
+
+type database struct {
+	nodes    map[string]*docfs.Node
+	paths    map[string]string // path -> node id
+	counters map[string]int64
+	records  map[string]map[string][]byte // collection -> key -> value
+}
+
+func newDatabase() *database {
+	return &database{
+		nodes:    map[string]*docfs.Node{},
+		paths:    map[string]string{},
+		counters: map[string]int64{},
+		records:  map[string]map[string][]byte{},
+	}
+}
+
+func pathKey(parent, name string) string {
+	return parent + "\x00" + name
+}
+
+// Backend stores nodes in maps guarded by a read/write lock
+type Backend struct {
+	mu        sync.RWMutex
+	databases map[string]*database
+}
+
+func NewBackend() *Backend {
+	return &Backend{databases: map[string]*database{}}
+}
+
+func (b *Backend) Start(config string) error {
+	// content is kept across restarts of the plugin
+	return nil
+}
+
+func (b *Backend) View(fn func(tx docfs.Tx) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return fn(&tx{backend: b})
+}
+
+func (b *Backend) Update(fn func(tx docfs.Tx) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := &tx{backend: b, writable: true}
+	err := fn(t)
+	if err != nil {
+		t.rollback()
+	}
+	return err
+}
+
+// undo restores the state changed by a single tx operation
+type undo func()
+
+type tx struct {
+	backend  *Backend
+	writable bool
+	journal  []undo
+}
+
+func (t *tx) rollback() {
+	for i := len(t.journal) - 1; i >= 0; i-- {
+		t.journal[i]()
+	}
+	t.journal = nil
+}
+
+func (t *tx) checkWritable() error {
+	if !t.writable {
+		return errors.New("memory: read only transaction")
+	}
+	return nil
+}
+
+func (t *tx) database(db string) (*database, error) {
+	d, ok := t.backend.databases[db]
+	if !ok {
+		return nil, docfs.ErrNotFound
+	}
+	return d, nil
+}
+
+func (t *tx) Databases() ([]string, error) {
+	list := []string{}
+	for name := range t.backend.databases {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+func (t *tx) HasDatabase(db string) (bool, error) {
+	_, ok := t.backend.databases[db]
+	return ok, nil
+}
+
+func (t *tx) CreateDatabase(db string) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	if _, ok := t.backend.databases[db]; ok {
+		return nil
+	}
+	t.backend.databases[db] = newDatabase()
+	t.journal = append(t.journal, func() {
+		delete(t.backend.databases, db)
+	})
+	return nil
+}
+
+func (t *tx) DropDatabase(db string) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	d, ok := t.backend.databases[db]
+	if !ok {
+		return nil
+	}
+	delete(t.backend.databases, db)
+	t.journal = append(t.journal, func() {
+		t.backend.databases[db] = d
+	})
+	return nil
+}
+
+func (t *tx) Node(db, id string) (*docfs.Node, error) {
+	d, err := t.database(db)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := d.nodes[id]
+	if !ok {
+		return nil, docfs.ErrNotFound
+	}
+	return n.Copy(), nil
+}
+
+func (t *tx) Lookup(db, parent, name string) (*docfs.Node, error) {
+	d, err := t.database(db)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := d.paths[pathKey(parent, name)]
+	if !ok {
+		return nil, docfs.ErrNotFound
+	}
+	return d.nodes[id].Copy(), nil
+}
+
+func (t *tx) Children(db, parent string) ([]*docfs.Node, error) {
+	d, err := t.database(db)
+	if err != nil {
+		return nil, err
+	}
+	list := []*docfs.Node{}
+	for _, n := range d.nodes {
+		if n.Header.Parent == parent {
+			list = append(list, n.Copy())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Header.Name < list[j].Header.Name
+	})
+	return list, nil
+}
+
+func (t *tx) Descendants(db, p string) ([]*docfs.Node, error) {
+	d, err := t.database(db)
+	if err != nil {
+		return nil, err
+	}
+	p = path.Clean(p)
+	prefix := p + "/"
+	if p == "/" {
+		prefix = "/"
+	}
+	list := []*docfs.Node{}
+	for _, n := range d.nodes {
+		if n.Header.Parent == p || strings.HasPrefix(n.Header.Parent, prefix) {
+			list = append(list, n.Copy())
+		}
+	}
+	return list, nil
+}
+
+func (t *tx) Walk(db string, fn func(n *docfs.Node) error) error {
+	d, err := t.database(db)
+	if err != nil {
+		return err
+	}
+	for _, n := range d.nodes {
+		err = fn(n.Copy())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tx) Put(db string, n *docfs.Node) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	d, err := t.database(db)
+	if err != nil {
+		return err
+	}
+	id := n.Id
+	key := pathKey(n.Header.Parent, n.Header.Name)
+	old, exists := d.nodes[id]
+	if exists {
+		delete(d.paths, pathKey(old.Header.Parent, old.Header.Name))
+	}
+	d.nodes[id] = n.Copy()
+	d.paths[key] = id
+
+	t.journal = append(t.journal, func() {
+		delete(d.paths, key)
+		if exists {
+			d.nodes[id] = old
+			d.paths[pathKey(old.Header.Parent, old.Header.Name)] = id
+		} else {
+			delete(d.nodes, id)
+		}
+	})
+	return nil
+}
+
+func (t *tx) Remove(db, id string) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	d, err := t.database(db)
+	if err != nil {
+		return err
+	}
+	old, exists := d.nodes[id]
+	if !exists {
+		return nil
+	}
+	delete(d.nodes, id)
+	delete(d.paths, pathKey(old.Header.Parent, old.Header.Name))
+
+	t.journal = append(t.journal, func() {
+		d.nodes[id] = old
+		d.paths[pathKey(old.Header.Parent, old.Header.Name)] = id
+	})
+	return nil
+}
+
+func (t *tx) Counter(db, name string) (int64, bool, error) {
+	d, err := t.database(db)
+	if err != nil {
+		return 0, false, err
+	}
+	v, ok := d.counters[name]
+	return v, ok, nil
+}
+
+func (t *tx) PutCounter(db, name string, value int64) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	d, err := t.database(db)
+	if err != nil {
+		return err
+	}
+	old, exists := d.counters[name]
+	d.counters[name] = value
+
+	t.journal = append(t.journal, func() {
+		if exists {
+			d.counters[name] = old
+		} else {
+			delete(d.counters, name)
+		}
+	})
+	return nil
+}
+
+func (t *tx) Counters(db string) (map[string]int64, error) {
+	d, err := t.database(db)
+	if err != nil {
+		return nil, err
+	}
+	list := make(map[string]int64, len(d.counters))
+	for name, value := range d.counters {
+		list[name] = value
+	}
+	return list, nil
+}
+
+func (t *tx) Record(db, collection, key string) ([]byte, error) {
+	d, err := t.database(db)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := d.records[collection][key]
+	if !ok {
+		return nil, docfs.ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+func (t *tx) PutRecord(db, collection, key string, value []byte) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	d, err := t.database(db)
+	if err != nil {
+		return err
+	}
+	c, ok := d.records[collection]
+	if !ok {
+		c = map[string][]byte{}
+		d.records[collection] = c
+	}
+	old, exists := c[key]
+	c[key] = append([]byte(nil), value...)
+
+	t.journal = append(t.journal, func() {
+		if exists {
+			c[key] = old
+		} else {
+			delete(c, key)
+		}
+	})
+	return nil
+}
+
+func (t *tx) RemoveRecord(db, collection, key string) error {
+	if err := t.checkWritable(); err != nil {
+		return err
+	}
+	d, err := t.database(db)
+	if err != nil {
+		return err
+	}
+	c := d.records[collection]
+	old, exists := c[key]
+	if !exists {
+		return nil
+	}
+	delete(c, key)
+
+	t.journal = append(t.journal, func() {
+		c[key] = old
+	})
+	return nil
+}
+
+func (t *tx) Records(db, collection, prefix string, fn func(key string, value []byte) error) error {
+	d, err := t.database(db)
+	if err != nil {
+		return err
+	}
+	c := d.records[collection]
+	keys := []string{}
+	for k := range c {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		err = fn(k, append([]byte(nil), c[k]...))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewFileSystem() *docfs.FileSystem {
+	return docfs.NewFileSystem(NewBackend())
+}
+
+func init() {
+	bytengine.RegisterFileSystem("memory", NewFileSystem())
+}
@@ -0,0 +1,29 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/johnwilson/bytengine"
+	_ "github.com/johnwilson/bytengine/bytestore/diskv"
+	"github.com/johnwilson/bytengine/filesystem/filesystemtest"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	BFS_CONFIG    = `{}`
+	BSTORE_CONFIG = `
+    {
+        "rootdir":"/tmp/diskv_memory_data",
+        "cachesize": 1
+    }`
+)
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) (bytengine.FileSystem, bytengine.ByteStore) {
+		bstore, err := bytengine.NewByteStore("diskv", BSTORE_CONFIG)
+		assert.Nil(t, err, "bst not created")
+		mfs, err := bytengine.NewFileSystem("memory", BFS_CONFIG, &bstore)
+		assert.Nil(t, err, "bfs not created")
+		return mfs, bstore
+	})
+}
@@ -0,0 +1,51 @@
+package mongo
+
+import (
+	"fmt"
+
+	"github.com/johnwilson/bytengine"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// groupPipeline builds the aggregation pipeline of a group query. Group
+// values and aggregates are given generated names as the fields of the
+// query may contain dots.
+func groupPipeline(q bson.M, fields []bytengine.GroupField, aggregates []bytengine.Aggregate) []bson.M {
+	id := bson.M{}
+	order := bson.D{}
+	for i, f := range fields {
+		key := fmt.Sprintf("g%d", i)
+		id[key] = "$" + f.Field
+		order = append(order, bson.DocElem{Name: "_id." + key, Value: 1})
+	}
+	g := bson.M{"_id": id, "count": bson.M{"$sum": 1}}
+	for i, a := range aggregates {
+		g[fmt.Sprintf("a%d", i)] = bson.M{"$" + a.Func: "$" + a.Field}
+	}
+	return []bson.M{{"$match": q}, {"$group": g}, {"$sort": order}}
+}
+
+// groupSearch runs a group query and returns one row per group
+func groupSearch(c *mgo.Collection, q bson.M, fields []bytengine.GroupField, aggregates []bytengine.Aggregate) ([]interface{}, error) {
+	rows := []interface{}{}
+	var item bson.M
+	iter := c.Pipe(groupPipeline(q, fields, aggregates)).Iter()
+	for iter.Next(&item) {
+		id, _ := item["_id"].(bson.M)
+		key := bson.M{}
+		for i, f := range fields {
+			key[f.Name] = id[fmt.Sprintf("g%d", i)]
+		}
+		row := bson.M{"group": key, "count": item["count"]}
+		for i, a := range aggregates {
+			row[a.Name] = item[fmt.Sprintf("a%d", i)]
+		}
+		rows = append(rows, row)
+		item = nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,100 @@
+package mongo
+
+import (
+	"errors"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// errAtomicDrop is returned when a database is dropped by an atomic file
+// system as its content couldn't be restored
+var errAtomicDrop = errors.New("databases can't be dropped in an atomic script")
+
+// journal is the compensation log of an atomic file system. The documents
+// about to be changed by a write are recorded so that the write can be
+// undone if the script fails later on. Other engines see the changes as
+// soon as they are made and may add documents matching the query of a
+// write in the meantime. Journaled writes are therefore restricted to the
+// recorded documents so that a rollback restores everything they changed
+// and leaves the documents of other engines alone.
+type journal struct {
+	entries []journalEntry
+	// recorded is called after every record. Tests use it to write as
+	// another engine between the record and the write.
+	recorded func()
+}
+
+type journalEntry struct {
+	c    *mgo.Collection
+	ids  []interface{} // documents changed or inserted by the write
+	docs []bson.D      // their content before the write
+}
+
+// record saves the documents of c matching the query q of a write and the
+// ids of the documents the write inserts. The query the write has to use
+// is returned which only matches the saved documents. Writes of file
+// systems without journal aren't recorded and keep their query.
+func (j *journal) record(c *mgo.Collection, q bson.M, inserted ...interface{}) (bson.M, error) {
+	if j == nil {
+		return q, nil
+	}
+	var docs []bson.D
+	err := c.Find(q).All(&docs)
+	if err != nil {
+		return nil, err
+	}
+	found := []interface{}{}
+	for _, doc := range docs {
+		found = append(found, doc.Map()["_id"])
+	}
+	ids := append(append([]interface{}{}, inserted...), found...)
+	j.entries = append(j.entries, journalEntry{c, ids, docs})
+	if j.recorded != nil {
+		j.recorded()
+	}
+	return bson.M{"_id": bson.M{"$in": found}}, nil
+}
+
+// recordId saves the document of c with the given id which the write
+// changes or inserts
+func (j *journal) recordId(c *mgo.Collection, id interface{}) error {
+	_, err := j.record(c, bson.M{"_id": id}, id)
+	return err
+}
+
+// append adds the entries of a nested journal
+func (j *journal) append(other *journal) {
+	if j == nil {
+		return
+	}
+	j.entries = append(j.entries, other.entries...)
+}
+
+// rollback undoes the recorded writes, latest first
+func (j *journal) rollback() error {
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		e := j.entries[i]
+		if len(e.ids) == 0 {
+			continue
+		}
+		// remove inserted and changed documents
+		_, err := e.c.RemoveAll(bson.M{"_id": bson.M{"$in": e.ids}})
+		if err != nil {
+			return err
+		}
+		if len(e.docs) == 0 {
+			continue
+		}
+		docs := []interface{}{}
+		for _, doc := range e.docs {
+			docs = append(docs, doc)
+		}
+		err = e.c.Insert(docs...)
+		if err != nil {
+			return err
+		}
+	}
+	j.entries = nil
+	return nil
+}
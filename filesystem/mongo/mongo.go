@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,6 +27,8 @@ type NodeHeader struct {
 	IsPublic bool   `bson:"ispublic"`
 	Created  string `bson:"created"`
 	Parent   string `bson:"parent"`
+	Modified string `bson:"modified,omitempty"` // last json content change
+	Revision int64  `bson:"revision,omitempty"` // number of json content changes
 }
 
 // BFS Bytes Header
@@ -32,6 +36,10 @@ type BytesHeader struct {
 	Filepointer string `bson:"filepointer"`
 	Mime        string `bson:"mime"`
 	Size        int64  `bson:"size"`
+	Hash        string `bson:"hash,omitempty"`
+	Modified    string `bson:"modified,omitempty"` // last bytes upload
+	Filename    string `bson:"filename,omitempty"` // original upload name
+	User        string `bson:"user,omitempty"`     // uploader
 }
 
 // BFS Directory
@@ -48,6 +56,34 @@ type File struct {
 	Content map[string]interface{} `bson:"content"`
 }
 
+// Revision is a recorded version of the json content of a file
+type Revision struct {
+	Id       string                 `bson:"_id"`
+	Node     string                 `bson:"node"`
+	Revision int64                  `bson:"revision"`
+	Created  string                 `bson:"created"`
+	User     string                 `bson:"user"`
+	Content  map[string]interface{} `bson:"content"`
+}
+
+// BytesVersion is a replaced attachment of a file
+type BytesVersion struct {
+	Id       string      `bson:"_id"`
+	Node     string      `bson:"node"`
+	Version  int64       `bson:"version"`
+	Bytes    BytesHeader `bson:"bytes"`
+	Replaced string      `bson:"replaced"`
+}
+
+// TrashItem is a deleted file or directory together with the nodes below
+// it. The item id is the id of the deleted node.
+type TrashItem struct {
+	Id      string   `bson:"_id"`
+	Path    string   `bson:"path"`
+	Deleted string   `bson:"deleted"`
+	Nodes   []bson.M `bson:"nodes"`
+}
+
 type Config struct {
 	Addresses    []string      `json:"addresses"`
 	Timeout      time.Duration `json:"timeout"`
@@ -61,13 +97,17 @@ func NewFileSystem() *FileSystem {
 }
 
 const (
-	FileSystemCollection = "bfs"
-	CounterCollection    = "bfs_counters"
+	FileSystemCollection   = "bfs"
+	CounterCollection      = "bfs_counters"
+	HistoryCollection      = "bfs_history"
+	BytesHistoryCollection = "bfs_bytes_history"
+	TrashCollection        = "bfs_trash"
 )
 
 type FileSystem struct {
 	session *mgo.Session
 	bstore  bytengine.ByteStore
+	journal *journal // writes of atomic file systems
 }
 
 type SimpleResultItem struct {
@@ -93,11 +133,102 @@ func makeRootDir() (*Directory, error) {
 		return nil, err
 	}
 	dt := filesystem.FormatDatetime(time.Now())
-	h := NodeHeader{"/", "Directory", true, dt, ""}
+	h := NodeHeader{"/", "Directory", true, dt, "", dt, 0}
 	r := &Directory{h, id}
 	return r, nil
 }
 
+// whereQuery translates a where statement condition tree into a mongodb
+// query
+func whereQuery(cond bytengine.Condition) bson.M {
+	switch c := cond.(type) {
+	case bytengine.And:
+		list := []bson.M{}
+		for _, item := range c.Conditions {
+			list = append(list, whereQuery(item))
+		}
+		if len(list) == 0 {
+			return bson.M{}
+		}
+		return bson.M{"$and": list}
+	case bytengine.Or:
+		list := []bson.M{}
+		for _, item := range c.Conditions {
+			list = append(list, whereQuery(item))
+		}
+		if len(list) == 0 {
+			// nothing can match an empty 'or' list
+			return bson.M{"_id": bson.M{"$exists": false}}
+		}
+		return bson.M{"$or": list}
+	case bytengine.Comparison:
+		switch c.Op {
+		case bytengine.OpNotEqual:
+			return bson.M{c.Field: bson.M{"$ne": c.Value}}
+		case bytengine.OpLesser:
+			return bson.M{c.Field: bson.M{"$lt": c.Value}}
+		case bytengine.OpLesserEqual:
+			return bson.M{c.Field: bson.M{"$lte": c.Value}}
+		case bytengine.OpGreater:
+			return bson.M{c.Field: bson.M{"$gt": c.Value}}
+		case bytengine.OpGreaterEqual:
+			return bson.M{c.Field: bson.M{"$gte": c.Value}}
+		}
+		return bson.M{c.Field: c.Value}
+	case bytengine.In:
+		if c.Negate {
+			return bson.M{c.Field: bson.M{"$nin": c.Values}}
+		}
+		return bson.M{c.Field: bson.M{"$in": c.Values}}
+	case bytengine.Exists:
+		return bson.M{c.Field: bson.M{"$exists": c.Exists}}
+	case bytengine.TypeOf:
+		typenum := -1
+		switch c.Type {
+		case bytengine.TypeString:
+			typenum = 2
+		case bytengine.TypeInt:
+			typenum = 16
+		}
+		if c.Negate {
+			return bson.M{c.Field: bson.M{"$not": bson.M{"$type": typenum}}}
+		}
+		return bson.M{c.Field: bson.M{"$type": typenum}}
+	case bytengine.Regex:
+		rgx := bson.RegEx{Pattern: c.Pattern, Options: c.Options}
+		return bson.M{c.Field: bson.M{"$regex": rgx}}
+	}
+	return bson.M{}
+}
+
+// bqlQuery builds the query selecting files located in the given
+// directories which match the where statement
+func bqlQuery(paths []string, query map[string]interface{}) bson.M {
+	direct, recursive := filesystem.ScopeDirs(paths)
+	q := bson.M{
+		"__header__.parent": bson.M{"$in": direct},
+		"__header__.type":   "File"} // make sure return item is file
+	if len(recursive) > 0 {
+		// files below the recursive directories
+		scope := []bson.M{{"__header__.parent": bson.M{"$in": direct}}}
+		for _, dir := range recursive {
+			scope = append(scope, findAllChildrenQuery(dir))
+		}
+		delete(q, "__header__.parent")
+		q["$or"] = scope
+	}
+	if where, haswhere := query["where"].(bytengine.Condition); haswhere {
+		q["$and"] = []bson.M{whereQuery(where)}
+	}
+	return q
+}
+
+// bytesHash returns the content digest reported by the bst if any
+func bytesHash(info map[string]interface{}) string {
+	hash, _ := info["hash"].(string)
+	return hash
+}
+
 func (m *FileSystem) existsDocument(p string, c *mgo.Collection) (SimpleResultItem, bool) {
 	q := m.findPathQuery(p)
 	var ri SimpleResultItem
@@ -129,8 +260,13 @@ func (m *FileSystem) copyDirectoryDocument(d *Directory, newprefix, oldprefix, n
 		d.Header.Name = newname
 	}
 	d.Header.Created = filesystem.FormatDatetime(time.Now())
+	d.Header.Modified = d.Header.Created
 	d.Id = id
 	// save to mongodb
+	err = m.journal.recordId(c, d.Id)
+	if err != nil {
+		return err
+	}
 	err = c.Insert(&d)
 	if err != nil {
 		return err
@@ -153,6 +289,8 @@ func (m *FileSystem) copyFileDocument(f *File, newprefix, oldprefix, newname str
 	}
 	f.Header.Parent = _parent_path
 	f.Header.Created = filesystem.FormatDatetime(time.Now())
+	f.Header.Modified = f.Header.Created
+	f.Header.Revision = 1
 	if newname != "" {
 		err = filesystem.ValidateFileName(newname)
 		if err != nil {
@@ -163,6 +301,10 @@ func (m *FileSystem) copyFileDocument(f *File, newprefix, oldprefix, newname str
 	f.Id = id
 
 	// save to mongodb
+	err = m.journal.recordId(c, f.Id)
+	if err != nil {
+		return err
+	}
 	err = c.Insert(&f)
 	if err != nil {
 		return err
@@ -191,15 +333,17 @@ func (m *FileSystem) findChildrenQuery(p, rgx string) bson.M {
 	return q
 }
 
-func (m *FileSystem) findAllChildrenQuery(p string) bson.M {
+// findAllChildrenQuery matches the descendants of the directory p. Paths are
+// case sensitive and p is matched literally.
+func findAllChildrenQuery(p string) bson.M {
 	// pattern
 	var r string
 	if p == "/" {
 		r = "^/"
 	} else {
-		r = fmt.Sprintf("^%s($|/)", p)
+		r = "^" + regexp.QuoteMeta(p) + "($|/)"
 	}
-	q := bson.M{"__header__.parent": bson.RegEx{r, "i"}}
+	q := bson.M{"__header__.parent": bson.RegEx{Pattern: r}}
 	return q
 }
 
@@ -211,6 +355,481 @@ func (m *FileSystem) getCounterCollection(db string) *mgo.Collection {
 	return m.session.DB(db).C(CounterCollection)
 }
 
+func (m *FileSystem) getHistoryCollection(db string) *mgo.Collection {
+	return m.session.DB(db).C(HistoryCollection)
+}
+
+func (m *FileSystem) getBytesHistoryCollection(db string) *mgo.Collection {
+	return m.session.DB(db).C(BytesHistoryCollection)
+}
+
+func (m *FileSystem) getTrashCollection(db string) *mgo.Collection {
+	return m.session.DB(db).C(TrashCollection)
+}
+
+// lastModified returns when the json content of a file was last changed
+func lastModified(h NodeHeader) string {
+	if h.Modified == "" {
+		return h.Created
+	}
+	return h.Modified
+}
+
+// revisionId sorts the revisions of a file by number
+func revisionId(node string, rev int64) string {
+	return fmt.Sprintf("%s/%020d", node, rev)
+}
+
+// versioning checks if changes to the files of the database are recorded
+func (m *FileSystem) versioning(db string) (bool, error) {
+	var key bson.M
+	err := m.getBFSCollection(db).FindId("bytengine").One(&key)
+	if err == mgo.ErrNotFound {
+		return false, fmt.Errorf("database '%s' doesn't exist", db)
+	}
+	if err != nil {
+		return false, err
+	}
+	on, _ := key["versioning"].(bool)
+	return on, nil
+}
+
+// sameContent compares json content independently of the value types used
+// by the driver
+func sameContent(a, b map[string]interface{}) bool {
+	ha, err := filesystem.ContentHash(a)
+	if err != nil {
+		return false
+	}
+	hb, err := filesystem.ContentHash(b)
+	if err != nil {
+		return false
+	}
+	return ha == hb
+}
+
+// recordRevision adds the content of f as the latest revision of the file.
+// The previous content prev of existing files is recorded first if the
+// history doesn't end with it, i.e. for files changed while versioning was
+// disabled.
+func (m *FileSystem) recordRevision(db string, prev, f *File, user string) error {
+	c := m.getHistoryCollection(db)
+
+	var last Revision
+	found := true
+	err := c.Find(bson.M{"node": f.Id}).Sort("-revision").One(&last)
+	if err == mgo.ErrNotFound {
+		found = false
+	} else if err != nil {
+		return err
+	}
+
+	num := last.Revision
+	if prev != nil && (!found || !sameContent(last.Content, prev.Content)) {
+		num++
+		r := Revision{revisionId(f.Id, num), f.Id, num, lastModified(prev.Header), "", prev.Content}
+		err = m.journal.recordId(c, r.Id)
+		if err != nil {
+			return err
+		}
+		err = c.Insert(&r)
+		if err != nil {
+			return err
+		}
+	}
+	num++
+	r := Revision{revisionId(f.Id, num), f.Id, num, lastModified(f.Header), user, f.Content}
+	err = m.journal.recordId(c, r.Id)
+	if err != nil {
+		return err
+	}
+	return c.Insert(&r)
+}
+
+// bytesInfo describes an attachment
+func bytesInfo(h BytesHeader) map[string]interface{} {
+	info := map[string]interface{}{
+		"mime": h.Mime,
+		"size": h.Size,
+	}
+	if h.Hash != "" {
+		info["hash"] = h.Hash
+	}
+	if h.Modified != "" {
+		info["modified"] = h.Modified
+	}
+	if h.Filename != "" {
+		info["filename"] = h.Filename
+	}
+	if h.User != "" {
+		info["user"] = h.User
+	}
+	return info
+}
+
+// recordBytesVersion keeps the replaced attachment h of a file
+func (m *FileSystem) recordBytesVersion(db, node string, h BytesHeader) error {
+	c := m.getBytesHistoryCollection(db)
+
+	var last BytesVersion
+	err := c.Find(bson.M{"node": node}).Sort("-version").One(&last)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	num := last.Version + 1
+	v := BytesVersion{revisionId(node, num), node, num, h, filesystem.FormatDatetime(time.Now())}
+	err = m.journal.recordId(c, v.Id)
+	if err != nil {
+		return err
+	}
+	return c.Insert(&v)
+}
+
+// references counts the files, replaced attachment versions and trash items
+// which make reference to an attachment
+func (m *FileSystem) references(db, pointer string) (int, error) {
+	n, err := m.getBFSCollection(db).Find(bson.M{"__bytes__.filepointer": pointer}).Count()
+	if err != nil {
+		return 0, err
+	}
+	nv, err := m.getBytesHistoryCollection(db).Find(bson.M{"bytes.filepointer": pointer}).Count()
+	if err != nil {
+		return 0, err
+	}
+	nt, err := m.getTrashCollection(db).Find(bson.M{"nodes.__bytes__.filepointer": pointer}).Count()
+	if err != nil {
+		return 0, err
+	}
+	return n + nv + nt, nil
+}
+
+// releaseBytes deletes attachments from the bst once no file, replaced
+// attachment version or trash item makes reference to them anymore
+func (m *FileSystem) releaseBytes(db string, pointers []string) error {
+	seen := map[string]bool{}
+	for _, item := range pointers {
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		n, err := m.references(db, item)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		err = m.bstore.Delete(db, item)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeHistory deletes all revisions and replaced attachments of the
+// files. The pointers of the attachments are returned so that they can be
+// released.
+func (m *FileSystem) removeHistory(db string, ids []string) ([]string, error) {
+	q := bson.M{"node": bson.M{"$in": ids}}
+	hc := m.getHistoryCollection(db)
+	wq, err := m.journal.record(hc, q)
+	if err != nil {
+		return nil, err
+	}
+	_, err = hc.RemoveAll(wq)
+	if err != nil {
+		return nil, err
+	}
+
+	c := m.getBytesHistoryCollection(db)
+	var versions []BytesVersion
+	err = c.Find(q).All(&versions)
+	if err != nil {
+		return nil, err
+	}
+	pointers := []string{}
+	for _, v := range versions {
+		pointers = append(pointers, v.Bytes.Filepointer)
+	}
+	wq, err = m.journal.record(c, q)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.RemoveAll(wq)
+	if err != nil {
+		return nil, err
+	}
+	return pointers, nil
+}
+
+// revisionQuery matches the files at revision rev. Files stored before
+// revisions were counted have none and are at revision 0.
+func revisionQuery(rev int64) interface{} {
+	if rev == 0 {
+		return bson.M{"$in": []interface{}{0, nil}}
+	}
+	return rev
+}
+
+// checkRevision fails with a conflict error if one of the files matching q
+// isn't at the expected revision. Otherwise q is returned restricted to the
+// files at that revision so that changes made in the meantime aren't
+// overwritten.
+func (m *FileSystem) checkRevision(db string, q bson.M, ifrev int64) (bson.M, error) {
+	if ifrev == bytengine.AnyRevision {
+		return q, nil
+	}
+	cq := bson.M{}
+	for k, v := range q {
+		cq[k] = v
+	}
+	if ifrev == 0 {
+		cq["__header__.revision"] = bson.M{"$nin": []interface{}{0, nil}}
+	} else {
+		cq["__header__.revision"] = bson.M{"$ne": ifrev}
+	}
+	var ri SimpleResultItem
+	err := m.getBFSCollection(db).Find(cq).One(&ri)
+	if err == nil {
+		p := path.Join(ri.Header.Parent, ri.Header.Name)
+		return nil, &bytengine.ConflictError{Path: p, Expected: ifrev, Current: ri.Header.Revision}
+	}
+	if err != mgo.ErrNotFound {
+		return nil, err
+	}
+	cq["__header__.revision"] = revisionQuery(ifrev)
+	return cq, nil
+}
+
+// updateFiles applies the update uq to the files matching q and increments
+// their revision. The new content of every file is recorded if versioning
+// is enabled for the database.
+func (m *FileSystem) updateFiles(db string, q, uq bson.M, user string) (int, error) {
+	c := m.getBFSCollection(db)
+
+	inc := bson.M{"__header__.revision": 1}
+	switch val := uq["$inc"].(type) {
+	case bson.M:
+		for k, v := range val {
+			inc[k] = v
+		}
+	case map[string]interface{}:
+		for k, v := range val {
+			inc[k] = v
+		}
+	}
+	uq["$inc"] = inc
+
+	on, err := m.versioning(db)
+	if err != nil {
+		return 0, err
+	}
+	if !on {
+		wq, err := m.journal.record(c, q)
+		if err != nil {
+			return 0, err
+		}
+		info, err := c.UpdateAll(wq, uq)
+		if err != nil {
+			return 0, err
+		}
+		return info.Updated, nil
+	}
+
+	var files []File
+	err = c.Find(q).All(&files)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range files {
+		prev := &files[i]
+		err = m.journal.recordId(c, prev.Id)
+		if err != nil {
+			return count, err
+		}
+		err = c.UpdateId(prev.Id, uq)
+		if err == mgo.ErrNotFound {
+			// removed in the meantime
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		var f File
+		err = c.FindId(prev.Id).One(&f)
+		if err != nil {
+			return count, err
+		}
+		err = m.recordRevision(db, prev, &f, user)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// trashSettings checks if deleted items are moved to the trash and how long
+// they are kept. Items don't expire if expiry is 0.
+func (m *FileSystem) trashSettings(db string) (bool, time.Duration, error) {
+	var key bson.M
+	err := m.getBFSCollection(db).FindId("bytengine").One(&key)
+	if err == mgo.ErrNotFound {
+		return false, 0, fmt.Errorf("database '%s' doesn't exist", db)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	on, _ := key["trash"].(bool)
+	expiry, _ := key["trashexpiry"].(int64)
+	return on, time.Duration(expiry), nil
+}
+
+// trashNode decodes the headers of a node kept in the trash
+func trashNode(doc bson.M) (SimpleResultItem, error) {
+	var ri SimpleResultItem
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return ri, err
+	}
+	err = bson.Unmarshal(data, &ri)
+	return ri, err
+}
+
+// moveToTrash replaces the node with the given id and the nodes below it by
+// a trash item. History and attachments are kept until the item is purged.
+func (m *FileSystem) moveToTrash(db, p, id string) error {
+	c := m.getBFSCollection(db)
+
+	var root bson.M
+	err := c.FindId(id).One(&root)
+	if err != nil {
+		return err
+	}
+	ri, err := trashNode(root)
+	if err != nil {
+		return err
+	}
+	nodes := []bson.M{root}
+	q := findAllChildrenQuery(p)
+	isdir := ri.Header.Type == "Directory"
+	if isdir {
+		var children []bson.M
+		err = c.Find(q).All(&children)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, children...)
+	}
+
+	item := TrashItem{id, p, filesystem.FormatDatetime(time.Now()), nodes}
+	tc := m.getTrashCollection(db)
+	err = m.journal.recordId(tc, id)
+	if err != nil {
+		return err
+	}
+	err = tc.Insert(&item)
+	if err != nil {
+		return err
+	}
+	if isdir {
+		wq, err := m.journal.record(c, q)
+		if err != nil {
+			return err
+		}
+		_, err = c.RemoveAll(wq)
+		if err != nil {
+			return err
+		}
+	}
+	err = m.journal.recordId(c, id)
+	if err != nil {
+		return err
+	}
+	return c.RemoveId(id)
+}
+
+// purgeTrash permanently deletes the trash items matching q together with
+// the history of their files and returns their number
+func (m *FileSystem) purgeTrash(db string, q bson.M) (int, error) {
+	c := m.getTrashCollection(db)
+
+	var items []TrashItem
+	err := c.Find(q).All(&items)
+	if err != nil {
+		return 0, err
+	}
+	ids := []string{}
+	files := []string{}
+	pointers := []string{}
+	for _, item := range items {
+		ids = append(ids, item.Id)
+		for _, doc := range item.Nodes {
+			ri, err := trashNode(doc)
+			if err != nil {
+				return 0, err
+			}
+			if ri.Header.Type != "File" {
+				continue
+			}
+			files = append(files, ri.Id)
+			if ri.AHeader.Filepointer != "" {
+				pointers = append(pointers, ri.AHeader.Filepointer)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	q = bson.M{"_id": bson.M{"$in": ids}}
+	wq, err := m.journal.record(c, q)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.RemoveAll(wq)
+	if err != nil {
+		return 0, err
+	}
+	versions, err := m.removeHistory(db, files)
+	if err != nil {
+		return 0, err
+	}
+	pointers = append(pointers, versions...)
+	return len(ids), m.releaseBytes(db, pointers)
+}
+
+// expireTrash purges the trash items which are older than the expiry set
+// for the database
+func (m *FileSystem) expireTrash(db string) error {
+	// expiry applies even if the trash has been disabled since
+	_, expiry, err := m.trashSettings(db)
+	if err != nil || expiry == 0 {
+		return err
+	}
+
+	var items []TrashItem
+	err = m.getTrashCollection(db).Find(nil).Select(bson.M{"deleted": 1}).All(&items)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	ids := []string{}
+	for _, item := range items {
+		deleted, err := time.Parse(time.RFC3339, item.Deleted)
+		if err == nil && now.After(deleted.Add(expiry)) {
+			ids = append(ids, item.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err = m.purgeTrash(db, bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
 /*
 ============================================================================
     BFS Interface Methods
@@ -256,6 +875,9 @@ func (m *FileSystem) isBfsDatabase(db string) (bool, error) {
 
 func (m *FileSystem) ClearAll() ([]string, error) {
 	found := make([]string, 0)
+	if m.journal != nil {
+		return found, errAtomicDrop
+	}
 
 	dbs, err := m.session.DatabaseNames()
 	if err != nil {
@@ -341,11 +963,24 @@ func (m *FileSystem) CreateDatabase(db string) error {
 	if err != nil {
 		return err
 	}
+	index = mgo.Index{
+		Key:        []string{"node", "revision"},
+		Background: true,
+	}
+	err = m.getHistoryCollection(db).EnsureIndex(index)
+	if err != nil {
+		return err
+	}
 
 	// create bytengine key
 	key := bson.M{"_id": "bytengine"}
 
 	// add items
+	ids := []interface{}{rn.Id, "bytengine"}
+	_, err = m.journal.record(col, bson.M{"_id": bson.M{"$in": ids}}, ids...)
+	if err != nil {
+		return err
+	}
 	err = col.Insert(&rn, &key)
 	if err != nil {
 		return err
@@ -355,6 +990,9 @@ func (m *FileSystem) CreateDatabase(db string) error {
 }
 
 func (m *FileSystem) DropDatabase(db string) error {
+	if m.journal != nil {
+		return errAtomicDrop
+	}
 	// check if db to be deleted exists
 	dbs, err := m.session.DatabaseNames()
 	if err != nil {
@@ -441,18 +1079,22 @@ func (m *FileSystem) NewDir(p, db string) error {
 		return err
 	}
 	dt := filesystem.FormatDatetime(time.Now())
-	h := NodeHeader{_name, "Directory", false, dt, _parent}
+	h := NodeHeader{_name, "Directory", false, dt, _parent, dt, 0}
 	_dir := Directory{h, id}
 	// insert node into mongodb
-	err = c.Insert(&_dir)
+	err = m.journal.recordId(c, id)
 	if err != nil {
 		return err
 	}
-
+	err = c.Insert(&_dir)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (m *FileSystem) NewFile(p, db string, j map[string]interface{}) error {
+func (m *FileSystem) NewFile(p, db string, j map[string]interface{}, user string) error {
 	// check path
 	p = path.Clean(p)
 	_name := path.Base(p)
@@ -494,16 +1136,24 @@ func (m *FileSystem) NewFile(p, db string, j map[string]interface{}) error {
 		return err
 	}
 	dt := filesystem.FormatDatetime(time.Now())
-	h := NodeHeader{_name, "File", false, dt, _parent}
-	a := BytesHeader{"", "", 0}
+	h := NodeHeader{_name, "File", false, dt, _parent, dt, 1}
+	a := BytesHeader{"", "", 0, "", "", "", ""}
 	_file := File{h, a, id, j}
 	// insert node into mongodb
+	err = m.journal.recordId(c, id)
+	if err != nil {
+		return err
+	}
 	err = c.Insert(&_file)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	on, err := m.versioning(db)
+	if err != nil || !on {
+		return err
+	}
+	return m.recordRevision(db, nil, &_file, user)
 }
 
 func (m *FileSystem) ListDir(p, filter, db string) (map[string][]string, error) {
@@ -556,7 +1206,7 @@ func (m *FileSystem) ListDir(p, filter, db string) (map[string][]string, error)
 	return res, nil
 }
 
-func (m *FileSystem) ReadJson(p, db string, fields []string) (interface{}, error) {
+func (m *FileSystem) ReadJson(p, db string, fields []string) (interface{}, int64, error) {
 	// check path
 	p = path.Clean(p)
 
@@ -567,11 +1217,14 @@ func (m *FileSystem) ReadJson(p, db string, fields []string) (interface{}, error
 	q := m.findPathQuery(p)
 	q["__header__.type"] = "File"
 
-	var r bson.M
+	var r struct {
+		Header  NodeHeader  `bson:"__header__"`
+		Content interface{} `bson:"content"`
+	}
 	if len(fields) == 0 {
 		err := c.Find(q).One(&r)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	} else {
 		_flds := bson.M{"__header__": 1}
@@ -580,14 +1233,14 @@ func (m *FileSystem) ReadJson(p, db string, fields []string) (interface{}, error
 		}
 		err := c.Find(q).Select(_flds).One(&r)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
-	return r["content"], nil
+	return r.Content, r.Header.Revision, nil
 }
 
-func (m *FileSystem) Delete(p, db string) error {
+func (m *FileSystem) Delete(p, db string, ifrev int64) error {
 	// check path
 	p = path.Clean(p)
 	if p == "/" {
@@ -598,21 +1251,46 @@ func (m *FileSystem) Delete(p, db string) error {
 	// get collection
 	c := m.getBFSCollection(db)
 
+	err := m.expireTrash(db)
+	if err != nil {
+		return err
+	}
+
 	// get file or directory if it exists
 	q := m.findPathQuery(p)
 	var ri SimpleResultItem
-	err := c.Find(q).One(&ri)
+	err = c.Find(q).One(&ri)
 	if err != nil {
 		return err
 	}
+	if ifrev != bytengine.AnyRevision {
+		if ri.Header.Type == "Directory" {
+			return errors.New("revision checks are only valid for files.")
+		}
+		if ri.Header.Revision != ifrev {
+			return &bytengine.ConflictError{Path: p, Expected: ifrev, Current: ri.Header.Revision}
+		}
+	}
+	on, _, err := m.trashSettings(db)
+	if err != nil {
+		return err
+	}
+	if on {
+		return m.moveToTrash(db, p, ri.Id)
+	}
 	if ri.Header.Type == "Directory" {
 		// find all children
-		q = m.findAllChildrenQuery(p)
+		q = findAllChildrenQuery(p)
 		i := c.Find(q).Iter()
 		var ri2 SimpleResultItem
 		_attchs := []string{} // list of all attachments paths
+		_files := []string{}  // ids of all files
 		for i.Next(&ri2) {
-			if ri2.Header.Type == "File" && ri2.AHeader.Filepointer != "" {
+			if ri2.Header.Type != "File" {
+				continue
+			}
+			_files = append(_files, ri2.Id)
+			if ri2.AHeader.Filepointer != "" {
 				_attchs = append(_attchs, ri2.AHeader.Filepointer)
 			}
 		}
@@ -621,66 +1299,50 @@ func (m *FileSystem) Delete(p, db string) error {
 			return err
 		}
 		// delete all children
-		_, err := c.RemoveAll(q)
+		wq, err := m.journal.record(c, q)
 		if err != nil {
 			return err
 		}
-
-		// check if any documents make reference to attachment
-		q = bson.M{
-			"__bytes__.filepointer": bson.M{"$in": _attchs},
-		}
-		i = c.Find(q).Iter()
-		var ri3 SimpleResultItem
-		_keep := map[string]bool{}
-		for i.Next(&ri3) {
-			if ri3.Header.Type == "File" {
-				_keep[ri3.AHeader.Filepointer] = true
-			}
+		_, err = c.RemoveAll(wq)
+		if err != nil {
+			return err
 		}
-
-		// delete attachments from bst
-		for _, item := range _attchs {
-			if _, found := _keep[item]; found {
-				continue
-			}
-			err = m.bstore.Delete(db, item)
-			if err != nil {
-				return err
-			}
+		_versions, err := m.removeHistory(db, _files)
+		if err != nil {
+			return err
 		}
+		_attchs = append(_attchs, _versions...)
 		// delete directory
-		err = c.RemoveId(ri.Id)
+		err = m.journal.recordId(c, ri.Id)
 		if err != nil {
 			return err
 		}
-
-	} else {
-		if ri.AHeader.Filepointer != "" {
-			// check if any documents make reference to attachment
-			q = bson.M{
-				"__bytes__.filepointer": ri.AHeader.Filepointer,
-			}
-			n, err := c.Find(q).Count()
-			if err != nil {
-				return err
-			}
-			if n < 2 {
-				// delete attachment from bst
-				err = m.bstore.Delete(db, ri.AHeader.Filepointer)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		// delete file
 		err = c.RemoveId(ri.Id)
 		if err != nil {
 			return err
 		}
+		// delete attachments from bst unless copies make reference to them
+		return m.releaseBytes(db, _attchs)
 	}
 
-	return nil
+	// delete file
+	err = m.journal.recordId(c, ri.Id)
+	if err != nil {
+		return err
+	}
+	err = c.RemoveId(ri.Id)
+	if err != nil {
+		return err
+	}
+	_attchs, err := m.removeHistory(db, []string{ri.Id})
+	if err != nil {
+		return err
+	}
+	if ri.AHeader.Filepointer != "" {
+		_attchs = append(_attchs, ri.AHeader.Filepointer)
+	}
+	// delete attachments from bst unless copies make reference to them
+	return m.releaseBytes(db, _attchs)
 }
 
 func (m *FileSystem) Rename(p, newname, db string) error {
@@ -719,7 +1381,7 @@ func (m *FileSystem) Rename(p, newname, db string) error {
 			return err
 		}
 		// get affected parent directories
-		q = m.findAllChildrenQuery(p)
+		q = findAllChildrenQuery(p)
 		var _dirs []string
 		err = c.Find(q).Distinct("__header__.parent", &_dirs)
 		if err != nil {
@@ -729,13 +1391,21 @@ func (m *FileSystem) Rename(p, newname, db string) error {
 			newparent := strings.Replace(item, p, np, 1)
 			q = bson.M{"__header__.parent": item}
 			uq := bson.M{"$set": bson.M{"__header__.parent": newparent}}
-			_, e := c.UpdateAll(q, uq)
+			wq, e := m.journal.record(c, q)
+			if e != nil {
+				return e
+			}
+			_, e = c.UpdateAll(wq, uq)
 			if e != nil {
 				return e
 			}
 		}
 		// rename directory by updating field
 		q = bson.M{"$set": bson.M{"__header__.name": newname}}
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return err
@@ -759,6 +1429,10 @@ func (m *FileSystem) Rename(p, newname, db string) error {
 		}
 		// rename file by updating field
 		q = bson.M{"$set": bson.M{"__header__.name": newname}}
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return err
@@ -817,7 +1491,7 @@ func (m *FileSystem) Move(from, to, db string) error {
 			return err
 		}
 		// get affected parent directories
-		q = m.findAllChildrenQuery(from)
+		q = findAllChildrenQuery(from)
 		var _dirs []string
 		err = c.Find(q).Distinct("__header__.parent", &_dirs)
 		if err != nil {
@@ -827,13 +1501,21 @@ func (m *FileSystem) Move(from, to, db string) error {
 			newparent := strings.Replace(item, from, np, 1)
 			q = bson.M{"__header__.parent": item}
 			uq := bson.M{"$set": bson.M{"__header__.parent": newparent}}
-			_, e := c.UpdateAll(q, uq)
+			wq, e := m.journal.record(c, q)
+			if e != nil {
+				return e
+			}
+			_, e = c.UpdateAll(wq, uq)
 			if e != nil {
 				return e
 			}
 		}
 		// move directory by updating parent field
 		q = bson.M{"$set": bson.M{"__header__.parent": to}}
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return err
@@ -853,6 +1535,10 @@ func (m *FileSystem) Move(from, to, db string) error {
 		}
 		// rename file by updating field
 		q = bson.M{"$set": bson.M{"__header__.parent": to}}
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return err
@@ -923,7 +1609,7 @@ func (m *FileSystem) Copy(from, to, db string) error {
 		}
 
 		// get affected dirs
-		q := m.findAllChildrenQuery(_from_doc_path)
+		q := findAllChildrenQuery(_from_doc_path)
 		q["__header__.type"] = "Directory"
 		var _tmpdir Directory
 		i := c.Find(q).Iter()
@@ -935,7 +1621,7 @@ func (m *FileSystem) Copy(from, to, db string) error {
 		}
 
 		// get affected files
-		q = m.findAllChildrenQuery(_from_doc_path)
+		q = findAllChildrenQuery(_from_doc_path)
 		q["__header__.type"] = "File"
 		var _tmpfile File
 		i = c.Find(q).Iter()
@@ -972,7 +1658,7 @@ func (m *FileSystem) Info(p, db string) (map[string]interface{}, error) {
 
 	// get file or directory if it exists
 	q := m.findPathQuery(p)
-	var ri SimpleResultItem
+	var ri File
 	err := c.Find(q).One(&ri)
 	if err != nil {
 		return nil, err
@@ -1006,11 +1692,18 @@ func (m *FileSystem) Info(p, db string) (map[string]interface{}, error) {
 	} else {
 		_type := "file"
 		_info["type"] = _type
+		_hash, e := filesystem.ContentHash(ri.Content)
+		if e != nil {
+			return nil, e
+		}
+		_info["hash"] = _hash
+		_info["modified"] = ri.Header.Modified
+		if ri.Header.Modified == "" {
+			_info["modified"] = _created
+		}
+		_info["revision"] = ri.Header.Revision
 		if ri.AHeader.Filepointer != "" {
-			_info["bytes"] = map[string]interface{}{
-				"mime": ri.AHeader.Mime,
-				"size": ri.AHeader.Size,
-			}
+			_info["bytes"] = bytesInfo(ri.AHeader)
 		}
 	}
 
@@ -1035,14 +1728,22 @@ func (m *FileSystem) FileAccess(p, db string, protect bool) error {
 	if ri.Header.Type == "Directory" {
 		// update directory access by updating field
 		q = bson.M{"$set": bson.M{"__header__.ispublic": !protect}}
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return err
 		}
 		// automatically cascade to sub nodes
-		q = m.findAllChildrenQuery(p)
+		q = findAllChildrenQuery(p)
 		uq := bson.M{"$set": bson.M{"__header__.ispublic": !protect}}
-		_, e := c.UpdateAll(q, uq)
+		wq, e := m.journal.record(c, q)
+		if e != nil {
+			return e
+		}
+		_, e = c.UpdateAll(wq, uq)
 		if e != nil {
 			return e
 		}
@@ -1050,6 +1751,10 @@ func (m *FileSystem) FileAccess(p, db string, protect bool) error {
 	} else {
 		// update file access by updating field
 		q = bson.M{"$set": bson.M{"__header__.ispublic": !protect}}
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return err
@@ -1080,7 +1785,11 @@ func (m *FileSystem) SetCounter(counter, action string, value int64, db string)
 			return 0, err
 		}
 
-		doc := bson.M{"name": counter, "value": value}
+		doc := bson.M{"_id": bson.NewObjectId(), "name": counter, "value": value}
+		err = m.journal.recordId(c, doc["_id"])
+		if err != nil {
+			return 0, err
+		}
 		err = c.Insert(doc)
 		if err != nil {
 			return 0, err
@@ -1118,7 +1827,11 @@ func (m *FileSystem) SetCounter(counter, action string, value int64, db string)
 		Name  string
 		Value int64
 	}
-	_, err = c.Find(q).Apply(cq, &r)
+	wq, err := m.journal.record(c, q)
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.Find(wq).Apply(cq, &r)
 	if err != nil {
 		return 0, err
 	}
@@ -1146,15 +1859,11 @@ func (m *FileSystem) ListCounter(filter, db string) (map[string]int64, error) {
 	return list, nil
 }
 
-func (m *FileSystem) WriteBytes(p, ap, db string) (int64, error) {
+func (m *FileSystem) WriteBytes(p string, r io.Reader, hint bytengine.FileHint, db, user string) (int64, error) {
 	var nbytes int64 // number of bytes written
 
 	// check path
 	p = path.Clean(p)
-	_, err := os.Stat(ap)
-	if err != nil {
-		return nbytes, err
-	}
 
 	// get collection
 	c := m.getBFSCollection(db)
@@ -1162,7 +1871,7 @@ func (m *FileSystem) WriteBytes(p, ap, db string) (int64, error) {
 	// get file or directory if it exists
 	q := m.findPathQuery(p)
 	var ri SimpleResultItem
-	err = c.Find(q).One(&ri)
+	err := c.Find(q).One(&ri)
 	if err != nil {
 		return nbytes, err
 	}
@@ -1175,18 +1884,22 @@ func (m *FileSystem) WriteBytes(p, ap, db string) (int64, error) {
 		// if bytes already writen then update else create new
 		isnew := true
 		if ri.AHeader.Filepointer != "" {
-			isnew = false
-		}
-		// open attachment and add to bst
-		file, err := os.Open(ap)
-		if err != nil {
-			return nbytes, err
+			// previous attachments are kept if versioning is enabled
+			on, err := m.versioning(db)
+			if err != nil {
+				return nbytes, err
+			}
+			// copies of the file make reference to the same attachment
+			n, err := m.references(db, ri.AHeader.Filepointer)
+			if err != nil {
+				return nbytes, err
+			}
+			isnew = on || n > 1
 		}
-
 		var q bson.M // query
 
 		if isnew {
-			info, err := m.bstore.Add(db, file)
+			info, err := m.bstore.Add(db, r, hint)
 			if err != nil {
 				return nbytes, err
 			}
@@ -1198,26 +1911,54 @@ func (m *FileSystem) WriteBytes(p, ap, db string) (int64, error) {
 					"__bytes__.filepointer": info["name"].(string),
 					"__bytes__.size":        info["size"].(int64),
 					"__bytes__.mime":        info["mime"].(string),
+					"__bytes__.hash":        bytesHash(info),
+					"__bytes__.modified":    filesystem.FormatDatetime(time.Now()),
+					"__bytes__.filename":    hint.Name,
+					"__bytes__.user":        user,
 				}}
 		} else {
-			info, err := m.bstore.Update(db, ri.AHeader.Filepointer, file)
+			info, err := m.bstore.Update(db, ri.AHeader.Filepointer, r, hint)
 			if err != nil {
 				return nbytes, err
 			}
 			nbytes = info["size"].(int64)
 
 			// update file access by updating field
-			q = bson.M{
-				"$set": bson.M{
-					"__bytes__.size": info["size"].(int64),
-					"__bytes__.mime": info["mime"].(string),
-				}}
+			set := bson.M{
+				"__bytes__.size":     info["size"].(int64),
+				"__bytes__.mime":     info["mime"].(string),
+				"__bytes__.hash":     bytesHash(info),
+				"__bytes__.modified": filesystem.FormatDatetime(time.Now()),
+				"__bytes__.filename": hint.Name,
+				"__bytes__.user":     user,
+			}
+			// atomic file systems store updates under another name
+			if name, ok := info["name"].(string); ok {
+				set["__bytes__.filepointer"] = name
+			}
+			q = bson.M{"$set": set}
 		}
 
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return nbytes, err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return nbytes, err
 		}
+
+		if isnew && ri.AHeader.Filepointer != "" {
+			on, err := m.versioning(db)
+			if err != nil {
+				return nbytes, err
+			}
+			if on {
+				return nbytes, m.recordBytesVersion(db, ri.Id, ri.AHeader)
+			}
+			// copies of the file may still make reference to the attachment
+			return nbytes, m.releaseBytes(db, []string{ri.AHeader.Filepointer})
+		}
 	}
 
 	return nbytes, nil
@@ -1318,45 +2059,141 @@ func (m *FileSystem) DeleteBytes(p, db string) error {
 		return err
 
 	} else {
-		// delete attachment
-		if ri.AHeader.Filepointer != "" {
-			// delete attachment
-			err = m.bstore.Delete(db, ri.AHeader.Filepointer)
-			if err != nil && os.IsExist(err) {
-				return err
-			}
-		}
 		// update file access by updating field
 		q = bson.M{"$set": bson.M{"__bytes__.filepointer": ""}}
+		err = m.journal.recordId(c, ri.Id)
+		if err != nil {
+			return err
+		}
 		err = c.UpdateId(ri.Id, q)
 		if err != nil {
 			return err
 		}
+		if ri.AHeader.Filepointer == "" {
+			return nil
+		}
+
+		// attachment is kept if versioning is enabled
+		on, err := m.versioning(db)
+		if err != nil {
+			return err
+		}
+		if on {
+			return m.recordBytesVersion(db, ri.Id, ri.AHeader)
+		}
+		// delete attachment unless other documents make reference to it
+		err = m.releaseBytes(db, []string{ri.AHeader.Filepointer})
+		if err != nil && os.IsExist(err) {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (m *FileSystem) UpdateJson(p, db string, j map[string]interface{}) error {
-	// check path
-	p = path.Clean(p)
+// ListBytes returns the paths of the files in the database grouped by
+// the attachment they make reference to. Replaced attachment versions are
+// listed as 'path (version n)' and files in the trash as 'path (trash)'.
+func (m *FileSystem) ListBytes(db string) (map[string][]string, error) {
+	ok, err := m.isBfsDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("database '%s' doesn't exist", db)
+	}
 
 	// get collection
 	c := m.getBFSCollection(db)
 
+	q := bson.M{
+		"__header__.type":       "File",
+		"__bytes__.filepointer": bson.M{"$nin": []interface{}{"", nil}},
+	}
+	list := make(map[string][]string)
+	var ri SimpleResultItem
+	iter := c.Find(q).Iter()
+	for iter.Next(&ri) {
+		id := ri.AHeader.Filepointer
+		list[id] = append(list[id], path.Join(ri.Header.Parent, ri.Header.Name))
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	trashed := map[string]string{} // node id -> path of files in the trash
+	var item TrashItem
+	iter = m.getTrashCollection(db).Find(nil).Iter()
+	for iter.Next(&item) {
+		for _, doc := range item.Nodes {
+			ri, err := trashNode(doc)
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+			p := path.Join(ri.Header.Parent, ri.Header.Name)
+			trashed[ri.Id] = p
+			if id := ri.AHeader.Filepointer; id != "" {
+				list[id] = append(list[id], p+" (trash)")
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	var v BytesVersion
+	iter = m.getBytesHistoryCollection(db).Find(nil).Iter()
+	for iter.Next(&v) {
+		p, ok := trashed[v.Node]
+		if !ok {
+			var f SimpleResultItem
+			err = c.FindId(v.Node).One(&f)
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+			p = path.Join(f.Header.Parent, f.Header.Name)
+		}
+		p = fmt.Sprintf("%s (version %d)", p, v.Version)
+		list[v.Bytes.Filepointer] = append(list[v.Bytes.Filepointer], p)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (m *FileSystem) UpdateJson(p, db string, j map[string]interface{}, user string, ifrev int64) error {
+	// check path
+	p = path.Clean(p)
+
 	// get file if it exists
 	q := m.findPathQuery(p)
 	q["__header__.type"] = "File"
-	uq := bson.M{"$set": bson.M{"content": j}}
+	rq, err := m.checkRevision(db, q, ifrev)
+	if err != nil {
+		return err
+	}
+	uq := bson.M{"$set": bson.M{
+		"content":             j,
+		"__header__.modified": filesystem.FormatDatetime(time.Now()),
+	}}
 	// update file
-	return c.Update(q, uq)
+	n, err := m.updateFiles(db, rq, uq, user)
+	if err == nil && n == 0 {
+		// file may have been changed since the revision check
+		if _, err = m.checkRevision(db, q, ifrev); err == nil {
+			err = mgo.ErrNotFound
+		}
+	}
+	return err
 }
 
 func (m *FileSystem) BQLSearch(db string, query map[string]interface{}) (interface{}, error) {
 	// check fields and paths
 	fields, hasfields := query["fields"].([]string)
 	paths, haspaths := query["dirs"].([]string)
-	where, haswhere := query["where"].(map[string]interface{})
 	limit, haslimit := query["limit"].(int64)
 	sort, hassort := query["sort"].([]string)
 	_, hascount := query["count"]
@@ -1366,25 +2203,23 @@ func (m *FileSystem) BQLSearch(db string, query map[string]interface{}) (interfa
 		err := errors.New("Invalid select query: No fields or document paths.")
 		return nil, err
 	}
+	offset, paged, err := filesystem.PageOffset(query)
+	if err != nil {
+		return nil, err
+	}
 
 	// build mongodb query
-	q := bson.M{
-		"__header__.parent": bson.M{"$in": paths},
-		"__header__.type":   "File"} // make sure return item is file
-	if haswhere {
-		_and := where["and"].([]map[string]interface{})
-		if len(_and) > 0 {
-			q["$and"] = _and
-		}
-		_or := where["or"].([]map[string]interface{})
-		if len(_or) > 0 {
-			q["$or"] = _or
-		}
-	}
+	q := bqlQuery(paths, query)
 
 	// get collection
 	c := m.getBFSCollection(db)
 
+	// check group
+	if group, hasgroup := query["group"].([]bytengine.GroupField); hasgroup {
+		aggregates, _ := query["aggregates"].([]bytengine.Aggregate)
+		return groupSearch(c, q, group, aggregates)
+	}
+
 	// run query
 	tmp := c.Find(q)
 	// check count
@@ -1404,11 +2239,23 @@ func (m *FileSystem) BQLSearch(db string, query map[string]interface{}) (interfa
 		}
 		return distinctlist, nil
 	}
-	// check limit
+	// check offset
+	if offset > 0 {
+		tmp = tmp.Skip(int(offset))
+	}
+	// check limit, the next page starts after one more result
 	if haslimit {
-		tmp = tmp.Limit(int(limit))
+		if paged {
+			tmp = tmp.Limit(int(limit) + 1)
+		} else {
+			tmp = tmp.Limit(int(limit))
+		}
+	}
+	// check sort, pages need a stable order
+	if offset > 0 || paged {
+		sort = append(append([]string{}, sort...), "_id")
+		hassort = true
 	}
-	// check sort
 	if hassort {
 		tmp = tmp.Sort(sort...)
 	}
@@ -1432,18 +2279,114 @@ func (m *FileSystem) BQLSearch(db string, query map[string]interface{}) (interfa
 		_data := item["content"].(bson.M)
 		itemlist = append(itemlist, bson.M{"path": _path, "content": _data})
 	}
+	if err := i.Close(); err != nil {
+		return nil, err
+	}
 
+	if paged {
+		return filesystem.PageResult(query, itemlist, offset)
+	}
 	return itemlist, nil
 }
 
-func (m *FileSystem) BQLSet(db string, query map[string]interface{}) (int, error) {
+func (m *FileSystem) BQLDelete(db string, query map[string]interface{}) ([]string, error) {
+	// check paths
+	paths, haspaths := query["dirs"].([]string)
+
+	if !haspaths {
+		err := errors.New("Invalid delete command: No document paths.")
+		return nil, err
+	}
+
+	err := m.expireTrash(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// build query
+	q := bqlQuery(paths, query)
+	ifrev, hasrev := query["ifrev"].(int64)
+	if !hasrev {
+		ifrev = bytengine.AnyRevision
+	}
+	// no file is deleted if one of them has been changed by another client
+	q, err = m.checkRevision(db, q, ifrev)
+	if err != nil {
+		return nil, err
+	}
+
+	// get files
+	c := m.getBFSCollection(db)
+	var files []SimpleResultItem
+	err = c.Find(q).All(&files)
+	if err != nil {
+		return nil, err
+	}
+	on, _, err := m.trashSettings(db)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	_files := []string{}  // ids of deleted files
+	_attchs := []string{} // attachments of deleted files
+	for _, ri := range files {
+		p := path.Join(ri.Header.Parent, ri.Header.Name)
+		deleted = append(deleted, p)
+		if on {
+			err = m.moveToTrash(db, p, ri.Id)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		_files = append(_files, ri.Id)
+		if ri.AHeader.Filepointer != "" {
+			_attchs = append(_attchs, ri.AHeader.Filepointer)
+		}
+	}
+	sort.Strings(deleted)
+	if len(_files) == 0 {
+		return deleted, nil
+	}
+
+	// delete files
+	dq := bson.M{"_id": bson.M{"$in": _files}}
+	wq, err := m.journal.record(c, dq)
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.RemoveAll(wq)
+	if err != nil {
+		return nil, err
+	}
+	_versions, err := m.removeHistory(db, _files)
+	if err != nil {
+		return nil, err
+	}
+	_attchs = append(_attchs, _versions...)
+	// delete attachments from bst unless copies make reference to them
+	return deleted, m.releaseBytes(db, _attchs)
+}
+
+// setOperators maps the update operators of set commands to mongodb ones
+var setOperators = map[string]string{
+	"multiply": "$mul",
+	"min":      "$min",
+	"max":      "$max",
+	"push":     "$push",
+	"addtoset": "$addToSet",
+	"pull":     "$pull",
+	"pop":      "$pop",
+	"rename":   "$rename",
+}
+
+func (m *FileSystem) BQLSet(db string, query map[string]interface{}, user string) (int, error) {
 	var count int // number of items updated
 
 	// check fields and paths
 	fields, hasfields := query["fields"].(map[string]interface{})
 	incr_fields, hasincr := query["incr"].(map[string]interface{})
 	paths, haspaths := query["dirs"].([]string)
-	where, haswhere := query["where"].(map[string]interface{})
 
 	if !hasfields && !haspaths {
 		err := errors.New("Invalid set command: No fields or document paths.")
@@ -1451,43 +2394,52 @@ func (m *FileSystem) BQLSet(db string, query map[string]interface{}) (int, error
 	}
 
 	// build query
-	q := bson.M{"__header__.parent": bson.M{"$in": paths}, "__header__.type": "File"}
-	if haswhere {
-		_and := where["and"].([]map[string]interface{})
-		if len(_and) > 0 {
-			q["$and"] = _and
-		}
-		_or := where["or"].([]map[string]interface{})
-		if len(_or) > 0 {
-			q["$or"] = _or
-		}
+	q := bqlQuery(paths, query)
+	ifrev, hasrev := query["ifrev"].(int64)
+	if !hasrev {
+		ifrev = bytengine.AnyRevision
+	}
+	// no file is changed if one of them has been changed by another client
+	q, err := m.checkRevision(db, q, ifrev)
+	if err != nil {
+		return 0, err
 	}
 	// build update query
-	uquery := bson.M{"$set": fields}
+	dt := filesystem.FormatDatetime(time.Now())
+	set := bson.M{"__header__.modified": dt}
+	for k, v := range fields {
+		set[k] = v
+	}
+	// current timestamps are stored as text like other dates
+	now, _ := query["now"].([]string)
+	for _, k := range now {
+		set[k] = dt
+	}
+	uquery := bson.M{"$set": set}
 	if hasincr {
 		uquery["$inc"] = incr_fields
 	}
-
-	// get collection
-	c := m.getBFSCollection(db)
+	for op, mop := range setOperators {
+		if values, ok := query[op].(map[string]interface{}); ok {
+			uquery[mop] = values
+		}
+	}
 
 	// run query
-	info, err := c.UpdateAll(q, uquery)
+	count, err = m.updateFiles(db, q, uquery, user)
 	if err != nil {
-		return count, err
+		return 0, err
 	}
-	count = info.Updated
 
 	return count, nil
 }
 
-func (m *FileSystem) BQLUnset(db string, query map[string]interface{}) (int, error) {
+func (m *FileSystem) BQLUnset(db string, query map[string]interface{}, user string) (int, error) {
 	var count int // number of items updated
 
 	// check fields and paths
 	fields, hasfields := query["fields"].(map[string]interface{})
 	paths, haspaths := query["dirs"].([]string)
-	where, haswhere := query["where"].(map[string]interface{})
 
 	if !hasfields && !haspaths {
 		err := errors.New("Invalid unset command: No fields or document paths.")
@@ -1495,32 +2447,428 @@ func (m *FileSystem) BQLUnset(db string, query map[string]interface{}) (int, err
 	}
 
 	// build query
-	q := bson.M{"__header__.parent": bson.M{"$in": paths}, "__header__.type": "File"}
-	if haswhere {
-		_and := where["and"].([]map[string]interface{})
-		if len(_and) > 0 {
-			q["$and"] = _and
+	q := bqlQuery(paths, query)
+	ifrev, hasrev := query["ifrev"].(int64)
+	if !hasrev {
+		ifrev = bytengine.AnyRevision
+	}
+	// no file is changed if one of them has been changed by another client
+	q, err := m.checkRevision(db, q, ifrev)
+	if err != nil {
+		return 0, err
+	}
+	// build update query
+	uq := bson.M{
+		"$unset": fields,
+		"$set":   bson.M{"__header__.modified": filesystem.FormatDatetime(time.Now())},
+	}
+
+	// run query
+	count, err = m.updateFiles(db, q, uq, user)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (m *FileSystem) Versioning(db string) (bool, error) {
+	return m.versioning(db)
+}
+
+func (m *FileSystem) SetVersioning(db string, enabled bool) error {
+	ok, err := m.isBfsDatabase(db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("database '%s' doesn't exist", db)
+	}
+	// recorded history is kept when versioning is disabled
+	uq := bson.M{"$set": bson.M{"versioning": enabled}}
+	c := m.getBFSCollection(db)
+	err = m.journal.recordId(c, "bytengine")
+	if err != nil {
+		return err
+	}
+	return c.UpdateId("bytengine", uq)
+}
+
+// findFile returns the file at path p
+func (m *FileSystem) findFile(p, db string) (*File, error) {
+	q := m.findPathQuery(p)
+	q["__header__.type"] = "File"
+	var f File
+	err := m.getBFSCollection(db).Find(q).One(&f)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (m *FileSystem) ListRevisions(p, db string) ([]map[string]interface{}, error) {
+	// check path
+	p = path.Clean(p)
+
+	f, err := m.findFile(p, db)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []map[string]interface{}{}
+	var r Revision
+	i := m.getHistoryCollection(db).Find(bson.M{"node": f.Id}).Sort("revision").Select(bson.M{"content": 0}).Iter()
+	for i.Next(&r) {
+		item := map[string]interface{}{
+			"revision": r.Revision,
+			"created":  r.Created,
+			"user":     r.User,
 		}
-		_or := where["or"].([]map[string]interface{})
-		if len(_or) > 0 {
-			q["$or"] = _or
+		list = append(list, item)
+	}
+	err = i.Err()
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (m *FileSystem) ReadRevision(p, db string, rev int64, fields []string) (interface{}, error) {
+	// check path
+	p = path.Clean(p)
+
+	f, err := m.findFile(p, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var r bson.M
+	tmp := m.getHistoryCollection(db).FindId(revisionId(f.Id, rev))
+	if len(fields) > 0 {
+		_flds := bson.M{"revision": 1}
+		for _, item := range fields {
+			_flds["content."+item] = 1
 		}
+		tmp = tmp.Select(_flds)
 	}
-	// build update query
-	uq := bson.M{"$unset": fields}
+	err = tmp.One(&r)
+	if err == mgo.ErrNotFound {
+		return nil, fmt.Errorf("revision %d of '%s' doesn't exist", rev, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if r["content"] == nil {
+		return bson.M{}, nil
+	}
+	return r["content"], nil
+}
 
-	// get collection
+func (m *FileSystem) RevertJson(p, db string, rev int64, user string) error {
+	// check path
+	p = path.Clean(p)
+
+	f, err := m.findFile(p, db)
+	if err != nil {
+		return err
+	}
+	var r Revision
+	err = m.getHistoryCollection(db).FindId(revisionId(f.Id, rev)).One(&r)
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("revision %d of '%s' doesn't exist", rev, p)
+	}
+	if err != nil {
+		return err
+	}
+
+	content := r.Content
+	if content == nil {
+		content = map[string]interface{}{}
+	}
+	uq := bson.M{"$set": bson.M{
+		"content":             content,
+		"__header__.modified": filesystem.FormatDatetime(time.Now()),
+	}}
+	_, err = m.updateFiles(db, bson.M{"_id": f.Id}, uq, user)
+	return err
+}
+
+func (m *FileSystem) ListBytesVersions(p, db string) ([]map[string]interface{}, error) {
+	// check path
+	p = path.Clean(p)
+
+	f, err := m.findFile(p, db)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []map[string]interface{}{}
+	var v BytesVersion
+	i := m.getBytesHistoryCollection(db).Find(bson.M{"node": f.Id}).Sort("version").Iter()
+	for i.Next(&v) {
+		item := bytesInfo(v.Bytes)
+		item["version"] = v.Version
+		item["replaced"] = v.Replaced
+		list = append(list, item)
+	}
+	err = i.Close()
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (m *FileSystem) ReadBytesVersion(fp, db string, version int64) (string, map[string]interface{}, error) {
+	// check path
+	fp = path.Clean(fp)
+
+	f, err := m.findFile(fp, db)
+	if err != nil {
+		return "", nil, err
+	}
+	var v BytesVersion
+	err = m.getBytesHistoryCollection(db).FindId(revisionId(f.Id, version)).One(&v)
+	if err == mgo.ErrNotFound {
+		return "", nil, fmt.Errorf("version %d of '%s' doesn't exist", version, fp)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return v.Bytes.Filepointer, bytesInfo(v.Bytes), nil
+}
+
+func (m *FileSystem) PruneBytesVersions(p, db string, keep int64) (int, error) {
+	// check path
+	p = path.Clean(p)
+	if keep < 0 {
+		return 0, errors.New("number of versions to keep can't be negative")
+	}
+
+	f, err := m.findFile(p, db)
+	if err != nil {
+		return 0, err
+	}
+	c := m.getBytesHistoryCollection(db)
+	var versions []BytesVersion
+	err = c.Find(bson.M{"node": f.Id}).Sort("-version").Skip(int(keep)).All(&versions)
+	if err != nil {
+		return 0, err
+	}
+	pointers := []string{}
+	for _, v := range versions {
+		err = m.journal.recordId(c, v.Id)
+		if err != nil {
+			return 0, err
+		}
+		err = c.RemoveId(v.Id)
+		if err != nil {
+			return 0, err
+		}
+		pointers = append(pointers, v.Bytes.Filepointer)
+	}
+	return len(pointers), m.releaseBytes(db, pointers)
+}
+
+func (m *FileSystem) Trash(db string) (bool, time.Duration, error) {
+	return m.trashSettings(db)
+}
+
+func (m *FileSystem) SetTrash(db string, enabled bool, expiry time.Duration) error {
+	if expiry < 0 {
+		return errors.New("trash expiry can't be negative")
+	}
+	ok, err := m.isBfsDatabase(db)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("database '%s' doesn't exist", db)
+	}
+	// items in the trash are kept when it is disabled
+	uq := bson.M{"$set": bson.M{"trash": enabled, "trashexpiry": int64(expiry)}}
 	c := m.getBFSCollection(db)
+	err = m.journal.recordId(c, "bytengine")
+	if err != nil {
+		return err
+	}
+	return c.UpdateId("bytengine", uq)
+}
 
-	// run query
-	info, err := c.UpdateAll(q, uq)
+func (m *FileSystem) ListTrash(db string) ([]map[string]interface{}, error) {
+	err := m.expireTrash(db)
 	if err != nil {
-		return count, err
+		return nil, err
+	}
+	_, expiry, err := m.trashSettings(db)
+	if err != nil {
+		return nil, err
 	}
 
-	count = info.Updated
+	list := []map[string]interface{}{}
+	var item TrashItem
+	i := m.getTrashCollection(db).Find(nil).Sort("-deleted", "path").Iter()
+	for i.Next(&item) {
+		root, err := trashNode(item.Nodes[0])
+		if err != nil {
+			i.Close()
+			return nil, err
+		}
+		info := map[string]interface{}{
+			"id":      item.Id,
+			"path":    item.Path,
+			"type":    "file",
+			"deleted": item.Deleted,
+		}
+		if root.Header.Type == "Directory" {
+			info["type"] = "directory"
+			info["content_count"] = len(item.Nodes) - 1
+		}
+		if deleted, err := time.Parse(time.RFC3339, item.Deleted); err == nil && expiry > 0 {
+			info["expires"] = filesystem.FormatDatetime(deleted.Add(expiry))
+		}
+		list = append(list, info)
+	}
+	err = i.Close()
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
 
-	return count, nil
+func (m *FileSystem) RestoreTrash(id, to, db string) (string, error) {
+	ok, err := m.isBfsDatabase(db)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("database '%s' doesn't exist", db)
+	}
+
+	tc := m.getTrashCollection(db)
+	var item TrashItem
+	err = tc.FindId(id).One(&item)
+	if err == mgo.ErrNotFound {
+		return "", fmt.Errorf("trash item '%s' doesn't exist", id)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// restore to original location by default
+	if to == "" {
+		to = item.Path
+	}
+	to = path.Clean(to)
+	if to == "/" {
+		return "", errors.New("root directory can't be replaced")
+	}
+	_name := path.Base(to)
+	_parent := path.Dir(to)
+
+	root, err := trashNode(item.Nodes[0])
+	if err != nil {
+		return "", err
+	}
+	if root.Header.Type == "Directory" {
+		err = filesystem.ValidateDirName(_name)
+	} else {
+		err = filesystem.ValidateFileName(_name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// check if parent directory exists
+	c := m.getBFSCollection(db)
+	var _parentdir Directory
+	err = c.Find(m.findPathQuery(_parent)).One(&_parentdir)
+	if err != nil {
+		return "", fmt.Errorf("destination directory not found: %s", err)
+	}
+	if _parentdir.Header.Type != "Directory" {
+		return "", errors.New("destination isn't a directory")
+	}
+	// check if name already taken
+	_count, err := c.Find(m.findPathQuery(to)).Count()
+	if err != nil {
+		return "", err
+	}
+	if _count > 0 {
+		return "", fmt.Errorf("'%s' already exists", to)
+	}
+
+	ids := []interface{}{}
+	docs := []interface{}{}
+	for _, doc := range item.Nodes {
+		h, ok := doc["__header__"].(bson.M)
+		if !ok {
+			return "", fmt.Errorf("invalid trash item '%s'", id)
+		}
+		if doc["_id"] == root.Id {
+			h["parent"] = _parent
+			h["name"] = _name
+		} else {
+			p := h["parent"].(string)
+			h["parent"] = to + strings.TrimPrefix(p, item.Path)
+		}
+		ids = append(ids, doc["_id"])
+		docs = append(docs, doc)
+	}
+	_, err = m.journal.record(c, bson.M{"_id": bson.M{"$in": ids}}, ids...)
+	if err != nil {
+		return "", err
+	}
+	err = c.Insert(docs...)
+	if err != nil {
+		return "", err
+	}
+	err = m.journal.recordId(tc, id)
+	if err != nil {
+		return "", err
+	}
+	err = tc.RemoveId(id)
+	if err != nil {
+		return "", err
+	}
+	return to, nil
+}
+
+func (m *FileSystem) PurgeTrash(id, db string) (int, error) {
+	ok, err := m.isBfsDatabase(db)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("database '%s' doesn't exist", db)
+	}
+
+	// empty trash if no id is given
+	q := bson.M{}
+	if id != "" {
+		q["_id"] = id
+	}
+	n, err := m.purgeTrash(db, q)
+	if err == nil && n == 0 && id != "" {
+		return 0, fmt.Errorf("trash item '%s' doesn't exist", id)
+	}
+	return n, err
+}
+
+func (m *FileSystem) Atomic(fn func(fs bytengine.FileSystem) error) error {
+	store := filesystem.NewDeferredStore(m.bstore)
+	afs := &FileSystem{session: m.session, bstore: store, journal: &journal{}}
+	err := fn(afs)
+	if err != nil {
+		// undo writes made so far
+		if e := afs.journal.rollback(); e != nil {
+			return fmt.Errorf("%s (rollback failed: %s)", err, e)
+		}
+		store.Discard()
+		return err
+	}
+	// nested atomic file systems are undone with the enclosing one
+	m.journal.append(afs.journal)
+	return store.Flush()
 }
 
 func init() {
@@ -1,12 +1,14 @@
 package mongo
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
 
 	"github.com/johnwilson/bytengine"
 	_ "github.com/johnwilson/bytengine/bytestore/diskv"
+	"github.com/johnwilson/bytengine/filesystem/filesystemtest"
 	_ "github.com/johnwilson/bytengine/parser/base"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/mgo.v2/bson"
@@ -109,7 +111,7 @@ func TestContentManagement(t *testing.T) {
 	assert.Nil(t, err, "directory not created")
 
 	// create file
-	err = mfs.NewFile("/var/www/index.html", db, map[string]interface{}{})
+	err = mfs.NewFile("/var/www/index.html", db, map[string]interface{}{}, "test")
 	assert.Nil(t, err, "file not created")
 
 	// update file
@@ -117,11 +119,11 @@ func TestContentManagement(t *testing.T) {
 		"title": "welcome",
 		"body":  "Hello world!",
 	}
-	err = mfs.UpdateJson("/var/www/index.html", db, data)
+	err = mfs.UpdateJson("/var/www/index.html", db, data, "test", bytengine.AnyRevision)
 	assert.Nil(t, err, "file update failed")
 
 	// read file
-	j, err := mfs.ReadJson("/var/www/index.html", db, []string{"title", "body"})
+	j, _, err := mfs.ReadJson("/var/www/index.html", db, []string{"title", "body"})
 	assert.Nil(t, err, "file read failed")
 	val, ok := j.(bson.M)
 	assert.True(t, ok, "couldn't cast file content to bson.M")
@@ -149,7 +151,7 @@ func TestContentManagement(t *testing.T) {
 	assert.Len(t, files, 2, "directory copy failed")
 
 	// read copied file contents
-	j, err = mfs.ReadJson("/www/index_copy.html", db, []string{"title", "body"})
+	j, _, err = mfs.ReadJson("/www/index_copy.html", db, []string{"title", "body"})
 	assert.Nil(t, err, "file read failed")
 	val, ok = j.(bson.M)
 	assert.Equal(t, val["title"], "welcome", "incorrect file content: title")
@@ -211,30 +213,30 @@ func TestSearch(t *testing.T) {
 		"name":    "john",
 		"age":     34,
 		"country": "ghana",
-	})
+	}, "test")
 	assert.Nil(t, err, "file not created")
 	err = mfs.NewFile("/users/u2", db, map[string]interface{}{
 		"name":    "jason",
 		"age":     18,
 		"country": "ghana",
-	})
+	}, "test")
 	assert.Nil(t, err, "file not created")
 	err = mfs.NewFile("/users/u3", db, map[string]interface{}{
 		"name": "juliette",
 		"age":  18,
-	})
+	}, "test")
 	assert.Nil(t, err, "file not created")
 	err = mfs.NewFile("/users/u4", db, map[string]interface{}{
 		"name":    "michelle",
 		"age":     21,
 		"country": "uk",
-	})
+	}, "test")
 	assert.Nil(t, err, "file not created")
 	err = mfs.NewFile("/users/u5", db, map[string]interface{}{
 		"name":    "dennis",
 		"age":     22,
 		"country": "france",
-	})
+	}, "test")
 	assert.Nil(t, err, "file not created")
 
 	// create parser
@@ -308,11 +310,11 @@ func TestSetUnset(t *testing.T) {
     `
 	cmd, err := parser.Parse(script)
 	assert.Nil(t, err, "couldn't parse script")
-	count, err := mfs.BQLSet(db, cmd[0].Args)
+	count, err := mfs.BQLSet(db, cmd[0].Args, "test")
 	assert.Nil(t, err, "set data failed")
 	assert.Equal(t, count, 2, "set data failed")
 
-	j, err := mfs.ReadJson("/users/u1", db, []string{})
+	j, _, err := mfs.ReadJson("/users/u1", db, []string{})
 	assert.Nil(t, err, "read file failed")
 	data, ok := j.(bson.M)
 	assert.True(t, ok, "couldn't cast file content to bson.M")
@@ -327,7 +329,7 @@ func TestSetUnset(t *testing.T) {
     `
 	cmd, err = parser.Parse(script)
 	assert.Nil(t, err, "couldn't parse script")
-	count, err = mfs.BQLUnset(db, cmd[0].Args)
+	count, err = mfs.BQLUnset(db, cmd[0].Args, "test")
 	assert.Nil(t, err, "unset data failed")
 	assert.Equal(t, count, 4, "unset data failed")
 
@@ -363,11 +365,14 @@ func TestAttachmentManagement(t *testing.T) {
 		"type":  ".txt",
 	}
 	bfs_path := "/file_with_attachment"
-	err = mfs.NewFile(bfs_path, db, data)
+	err = mfs.NewFile(bfs_path, db, data, "test")
 	assert.Nil(t, err, "file creation failed")
 
 	// add to bfs
-	_, err = mfs.WriteBytes(bfs_path, fpath, db)
+	f, err := os.Open(fpath)
+	assert.Nil(t, err, "test file couldn't be opened")
+	defer f.Close()
+	_, err = mfs.WriteBytes(bfs_path, f, bytengine.FileHint{Name: fpath, Size: int64(len(txt))}, db, "test")
 	assert.Nil(t, err, "write bytes failed")
 
 	// read from store
@@ -386,3 +391,107 @@ func TestAttachmentManagement(t *testing.T) {
 	assert.Nil(t, err, "download test file couldn't be opened")
 	assert.Equal(t, txt, string(fdata), "attachment file content has changed")
 }
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) (bytengine.FileSystem, bytengine.ByteStore) {
+		bstore, err := bytengine.NewByteStore("diskv", BSTORE_CONFIG)
+		assert.Nil(t, err, "bst not created")
+		mfs, err := bytengine.NewFileSystem("mongodb", BFS_CONFIG, &bstore)
+		assert.Nil(t, err, "bfs not created")
+		return mfs, bstore
+	})
+}
+
+func TestWhereQuery(t *testing.T) {
+	where := bytengine.And{Conditions: []bytengine.Condition{
+		bytengine.Comparison{Field: "content.age", Op: bytengine.OpGreaterEqual, Value: 18},
+		bytengine.Or{Conditions: []bytengine.Condition{
+			bytengine.In{Field: "content.country", Values: []interface{}{"uk"}, Negate: true},
+			bytengine.TypeOf{Field: "content.name", Type: bytengine.TypeString, Negate: true},
+			bytengine.Regex{Field: "content.name", Pattern: "^j", Options: "i"},
+		}},
+	}}
+	expected := bson.M{"$and": []bson.M{
+		{"content.age": bson.M{"$gte": 18}},
+		{"$or": []bson.M{
+			{"content.country": bson.M{"$nin": []interface{}{"uk"}}},
+			{"content.name": bson.M{"$not": bson.M{"$type": 2}}},
+			{"content.name": bson.M{"$regex": bson.RegEx{Pattern: "^j", Options: "i"}}},
+		}},
+	}}
+	assert.Equal(t, expected, whereQuery(where), "wrong mongodb query")
+
+	q := bqlQuery([]string{"/users"}, map[string]interface{}{
+		"where": bytengine.Exists{Field: "content.email", Exists: true},
+	})
+	assert.Equal(t, []bson.M{{"content.email": bson.M{"$exists": true}}}, q["$and"], "wrong mongodb query")
+
+	q = bqlQuery([]string{"/users", "/docs/**"}, map[string]interface{}{})
+	expected = bson.M{
+		"__header__.type": "File",
+		"$or": []bson.M{
+			{"__header__.parent": bson.M{"$in": []string{"/users"}}},
+			{"__header__.parent": bson.RegEx{Pattern: "^/docs($|/)"}},
+		},
+	}
+	assert.Equal(t, expected, q, "wrong recursive mongodb query")
+
+	// paths are matched literally and case sensitively
+	q = findAllChildrenQuery("/Docs.v1")
+	assert.Equal(t, bson.M{"__header__.parent": bson.RegEx{Pattern: `^/Docs\.v1($|/)`}}, q, "wrong children query")
+}
+
+func TestAtomicRollback(t *testing.T) {
+	bstore, err := bytengine.NewByteStore("diskv", BSTORE_CONFIG)
+	assert.Nil(t, err, "bst not created")
+	mfs, err := bytengine.NewFileSystem("mongodb", BFS_CONFIG, &bstore)
+	assert.Nil(t, err, "bfs not created")
+	// file system of another engine
+	other := NewFileSystem()
+	err = other.Start(BFS_CONFIG, &bstore)
+	assert.Nil(t, err, "other bfs not created")
+
+	db := "atomicdb"
+	mfs.DropDatabase(db)
+	err = mfs.CreateDatabase(db)
+	assert.Nil(t, err, "database not created")
+	err = mfs.NewDir("/docs", db)
+	assert.Nil(t, err, "directory not created")
+	err = mfs.NewFile("/docs/a", db, map[string]interface{}{"status": "draft"}, "test")
+	assert.Nil(t, err, "file not created")
+
+	parser, err := bytengine.NewParser("base", "")
+	assert.Nil(t, err, "parser not created")
+	cmd, err := parser.Parse(`@atomicdb.set "status"="done" in /docs where "status" == "draft"`)
+	assert.Nil(t, err, "couldn't parse script")
+
+	errAbort := errors.New("abort")
+	err = mfs.Atomic(func(fs bytengine.FileSystem) error {
+		// written by the other engine between the record and the write of
+		// the set and matching its query
+		afs := fs.(*FileSystem)
+		afs.journal.recorded = func() {
+			afs.journal.recorded = nil
+			err := other.NewFile("/docs/b", db, map[string]interface{}{"status": "draft"}, "test")
+			assert.Nil(t, err, "file not created by other engine")
+		}
+		count, err := fs.BQLSet(db, cmd[0].Args, "test")
+		assert.Nil(t, err, "set data failed")
+		assert.Equal(t, 1, count, "file of other engine changed by set")
+		// written by the other engine before the script fails and
+		// matching the query of the set
+		err = other.NewFile("/docs/c", db, map[string]interface{}{"status": "draft"}, "test")
+		assert.Nil(t, err, "file not created by other engine")
+		return errAbort
+	})
+	assert.Equal(t, errAbort, err, "script not aborted")
+
+	j, _, err := mfs.ReadJson("/docs/a", db, []string{"status"})
+	assert.Nil(t, err, "changed file not restored")
+	assert.Equal(t, "draft", j.(bson.M)["status"], "changed file not restored")
+	for _, p := range []string{"/docs/b", "/docs/c"} {
+		j, _, err = mfs.ReadJson(p, db, []string{"status"})
+		assert.Nil(t, err, "file of other engine removed by rollback")
+		assert.Equal(t, "draft", j.(bson.M)["status"], "file of other engine changed by rollback")
+	}
+}
@@ -1,12 +1,17 @@
 package filesystem
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/johnwilson/bytengine"
 	"github.com/nu7hatch/gouuid"
 )
 
@@ -74,3 +79,31 @@ func NewNodeID() (string, error) {
 	id := strings.Replace(tmp.String(), "-", "", -1) // remove dashes
 	return id, nil
 }
+
+// ContentHash returns the hex encoded sha256 checksum of the json encoded
+// content of a file. Object keys are sorted by the encoder so every
+// revision of the content has a single hash.
+func ContentHash(content map[string]interface{}) (string, error) {
+	b, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// ScopeDirs splits the directories of a BQL query into directories whose
+// direct children are in scope and directories whose descendants are all
+// in scope. Paths are cleaned.
+func ScopeDirs(dirs []string) (direct, recursive []string) {
+	direct = []string{}
+	recursive = []string{}
+	for _, dir := range dirs {
+		if strings.HasSuffix(dir, bytengine.RecursiveSuffix) {
+			recursive = append(recursive, path.Clean(strings.TrimSuffix(dir, "**")))
+			continue
+		}
+		direct = append(direct, path.Clean(dir))
+	}
+	return direct, recursive
+}
@@ -17,6 +17,7 @@ type Command struct {
 	Options  map[string]interface{}
 	Filter   string // name of filter to apply to result
 	IsAdmin  bool   // is it an admin command
+	Assign   string // name of the script variable receiving the result
 }
 
 func (c Command) String() string {
@@ -12,6 +12,9 @@ func init() {
 	p.registry.NewServerItem("newdb", "", p.parseNewDatabaseCmd)
 	p.registry.NewServerItem("init", "", p.parseServerInitCmd)
 	p.registry.NewServerItem("dropdb", "", p.parseDropDatabaseCmd)
+	p.registry.NewServerItem("gc", "", p.parseServerGcCmd)
+	p.registry.NewServerItem("versioning", "", p.parseServerVersioningCmd)
+	p.registry.NewServerItem("trash", "", p.parseServerTrashCmd)
 
 	// register user functions
 	p.registry.NewUserItem("new", "", p.parseNewUserCmd)
@@ -41,6 +44,13 @@ func init() {
 	p.registry.NewDatabaseItem("select", "", p.parseSelectCmd)
 	p.registry.NewDatabaseItem("set", "", p.parseSetCmd)
 	p.registry.NewDatabaseItem("unset", "", p.parseUnsetCmd)
+	p.registry.NewDatabaseItem("history", "", p.parseFileHistoryCmd)
+	p.registry.NewDatabaseItem("revert", "", p.parseRevertFileCmd)
+	p.registry.NewDatabaseItem("byteversions", "", p.parseByteVersionsCmd)
+	p.registry.NewDatabaseItem("prunebytes", "", p.parsePruneBytesCmd)
+	p.registry.NewDatabaseItem("trash", "", p.parseListTrashCmd)
+	p.registry.NewDatabaseItem("restore", "", p.parseRestoreTrashCmd)
+	p.registry.NewDatabaseItem("purge", "", p.parsePurgeTrashCmd)
 
 	bytengine.RegisterParser("base", p)
 }
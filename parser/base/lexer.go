@@ -39,6 +39,7 @@ const (
 	itemEqual             // equal for argument value assignment
 	itemPlusEqual         // += for value increment
 	itemMinusEqual        // -= for value decrement
+	itemMultiplyEqual     // *= for value multiplication
 	itemColon             // :
 	itemDot               // .
 	itemSemiColon         // ;
@@ -61,6 +62,7 @@ const (
 	itemString            // quoted string (includes quotes)
 	itemPath              // unix type file path
 	itemOption            // --option
+	itemVariable          // $name or $name.field
 	// Keywords appear after all the rest.
 	itemKeyword // used only to delimit the keywords
 	itemNull
@@ -74,6 +76,7 @@ var itemName = map[itemType]string{
 	itemEqual:             "=",
 	itemPlusEqual:         "+=",
 	itemMinusEqual:        "-=",
+	itemMultiplyEqual:     "*=",
 	itemColon:             ":",
 	itemDot:               ".",
 	itemSemiColon:         ";",
@@ -97,6 +100,7 @@ var itemName = map[itemType]string{
 	itemString:            "string",
 	itemPath:              "path",
 	itemOption:            "--",
+	itemVariable:          "variable",
 	itemNull:              "null",
 }
 
@@ -324,6 +328,8 @@ func lexInsideScript(l *lexer) stateFn {
 	case r == '}':
 		l.emit(itemRightBrace)
 		return lexInsideScript
+	case r == '$':
+		return lexVariable
 	case r == '"':
 		return lexDoubleQuote
 	case r == '\'':
@@ -338,6 +344,12 @@ func lexInsideScript(l *lexer) stateFn {
 
 		l.backup()
 		return lexNumber
+	case r == '*':
+		if l.next() == '=' {
+			l.emit(itemMultiplyEqual)
+			return lexInsideScript
+		}
+		return l.errorf("expected *=")
 	case r == '-':
 		pk := l.peek()
 		if pk == '=' {
@@ -470,11 +482,45 @@ func (l *lexer) scanNumber() bool {
 	return true
 }
 
+// lexVariable scans a variable name and the fields selected in its value.
+// The '$' is known to be present.
+func lexVariable(l *lexer) stateFn {
+	if !isAlphaNumeric(l.peek()) {
+		return l.errorf("variable name expected after '$'")
+	}
+	for {
+		for isAlphaNumeric(l.peek()) {
+			l.next()
+		}
+		if l.peek() != '.' {
+			break
+		}
+		// field selector
+		l.next()
+		if !isAlphaNumeric(l.peek()) {
+			return l.errorf("field name expected in variable %q", l.input[l.start:l.pos])
+		}
+	}
+	l.emit(itemVariable)
+	return lexInsideScript
+}
+
 func lexPath(l *lexer) stateFn {
 Loop:
 	for {
 		switch r := l.next(); {
-		case isValidPathCharacter(r), r == '/':
+		case isValidPathCharacter(r), r == '/', r == '*':
+			// '*' is only valid in recursive directory scopes e.g. '/docs/**'
+			break
+		case r == '$':
+			// variable in path: $name or ${name.field}
+			if l.peek() == '{' {
+				i := strings.IndexRune(l.input[l.pos:], '}')
+				if i < 0 {
+					return l.errorf("unclosed variable in path")
+				}
+				l.pos += i + 1
+			}
 			break
 		default:
 			l.backup()
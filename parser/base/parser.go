@@ -3,7 +3,6 @@ package base
 import (
 	"encoding/json"
 	"fmt"
-	"gopkg.in/mgo.v2/bson"
 	"math"
 	"runtime"
 	"strconv"
@@ -156,61 +155,246 @@ func (p *Parser) Parse(s string) (c []bytengine.Command, err error) {
 // It runs to EOF.
 // all commands and sub-commands are made case insensitive with strings.ToLower()
 func (p *Parser) parse() map[string]interface{} {
+	atomic := false // inside begin/commit block
 	for p.peek().typ != itemEOF {
 		switch _next := p.peek(); {
 		case _next.typ == itemError:
 			p.errorf("Parsing error: %s", p.peek().val)
 		case _next.typ == itemIdentifier:
 			switch cmdprefix := strings.ToLower(_next.val); {
-			case cmdprefix == "server":
+			case cmdprefix == bytengine.BeginCommand:
 				// absorb keyword
 				p.next()
-				p.expect(itemDot, cmdprefix)
-				item := p.expect(itemIdentifier, cmdprefix)
-				key := strings.ToLower(item.val)
-				// lookup key
-				fn, name := p.registry.GetServerItem(key)
-				if fn == nil {
-					p.errorf("%s.%s not found", cmdprefix, key)
+				if atomic {
+					p.errorf("'%s' blocks can't be nested", cmdprefix)
 				}
-				ctx := fmt.Sprintf("%s.%s", cmdprefix, name)
-				fn(ctx)
-			case cmdprefix == "user":
+				atomic = true
+				p.parseBlockKeyword(cmdprefix)
+			case cmdprefix == bytengine.CommitCommand:
 				// absorb keyword
 				p.next()
-				p.expect(itemDot, cmdprefix)
-				item := p.expect(itemIdentifier, cmdprefix)
-				key := strings.ToLower(item.val)
-				// lookup key
-				fn, name := p.registry.GetUserItem(key)
-				if fn == nil {
-					p.errorf("%s.%s not found", cmdprefix, key)
+				if !atomic {
+					p.errorf("'%s' without '%s'", cmdprefix, bytengine.BeginCommand)
 				}
-				ctx := fmt.Sprintf("%s.%s", cmdprefix, name)
-				fn(ctx)
+				atomic = false
+				p.parseBlockKeyword(cmdprefix)
+			case cmdprefix == "let":
+				// absorb keyword
+				p.next()
+				p.parseLetCmd(cmdprefix)
 			default:
-				p.errorf("Invalid command prefix '%s'", cmdprefix)
+				p.parseCommand()
 			}
 		case _next.typ == itemDatabase:
-			cmdprefix := "database"
-			db := p.next().val
+			p.parseCommand()
+		default:
+			//absorb unknown token
+			p.next()
+		}
+	}
+	if atomic {
+		p.errorf("'%s' without '%s'", bytengine.BeginCommand, bytengine.CommitCommand)
+	}
+
+	return nil
+}
+
+// parseCommand parses a server, user or database command
+func (p *Parser) parseCommand() {
+	switch _next := p.peek(); {
+	case _next.typ == itemIdentifier:
+		switch cmdprefix := strings.ToLower(_next.val); {
+		case cmdprefix == "server":
+			// absorb keyword
+			p.next()
 			p.expect(itemDot, cmdprefix)
 			item := p.expect(itemIdentifier, cmdprefix)
 			key := strings.ToLower(item.val)
 			// lookup key
-			fn, name := p.registry.GetDatabaseItem(key)
+			fn, name := p.registry.GetServerItem(key)
 			if fn == nil {
 				p.errorf("%s.%s not found", cmdprefix, key)
 			}
 			ctx := fmt.Sprintf("%s.%s", cmdprefix, name)
-			fn(db, ctx)
-		default:
-			//absorb unknown token
+			fn(ctx)
+		case cmdprefix == "user":
+			// absorb keyword
 			p.next()
+			p.expect(itemDot, cmdprefix)
+			item := p.expect(itemIdentifier, cmdprefix)
+			key := strings.ToLower(item.val)
+			// lookup key
+			fn, name := p.registry.GetUserItem(key)
+			if fn == nil {
+				p.errorf("%s.%s not found", cmdprefix, key)
+			}
+			ctx := fmt.Sprintf("%s.%s", cmdprefix, name)
+			fn(ctx)
+		default:
+			p.errorf("Invalid command prefix '%s'", cmdprefix)
 		}
+	case _next.typ == itemDatabase:
+		cmdprefix := "database"
+		db := p.next().val
+		p.expect(itemDot, cmdprefix)
+		item := p.expect(itemIdentifier, cmdprefix)
+		key := strings.ToLower(item.val)
+		// lookup key
+		fn, name := p.registry.GetDatabaseItem(key)
+		if fn == nil {
+			p.errorf("%s.%s not found", cmdprefix, key)
+		}
+		ctx := fmt.Sprintf("%s.%s", cmdprefix, name)
+		fn(db, ctx)
+	default:
+		p.unexpected(_next, "command")
 	}
+}
 
-	return nil
+// variable assignment parser: let $name = command
+func (p *Parser) parseLetCmd(ctx string) {
+	_token := p.expect(itemVariable, ctx)
+	_var := p.variable(_token.val, ctx)
+	if len(_var.Fields) > 0 {
+		p.errorf("Invalid variable name '%s' in %s", _token.val, ctx)
+	}
+	p.expect(itemEqual, ctx)
+	count := len(p.commands)
+	p.parseCommand()
+	if len(p.commands) != count+1 {
+		p.errorf("Command expected in %s", ctx)
+	}
+	p.commands[count].Assign = _var.Name
+}
+
+// variable reference parser e.g. '$user.emails.0'
+func (p *Parser) variable(val, ctx string) bytengine.Variable {
+	parts := strings.Split(strings.TrimPrefix(val, "$"), ".")
+	for _, item := range parts {
+		if item == "" {
+			p.errorf("Invalid variable '%s' in %s", val, ctx)
+		}
+	}
+	return bytengine.Variable{Name: parts[0], Fields: parts[1:]}
+}
+
+// path value parser. Paths with variables such as '/users/$id' or
+// '/users/${user.id}' are returned as templates.
+func (p *Parser) pathValue(_token item, ctx string) interface{} {
+	val := _token.val
+	if strings.Contains(val, "*") {
+		p.errorf("Invalid path '%s' in %s", val, ctx)
+	}
+	if !strings.Contains(val, "$") {
+		return val
+	}
+	parts := bytengine.Template{}
+	for {
+		i := strings.Index(val, "$")
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			parts = append(parts, val[:i])
+		}
+		val = val[i+1:]
+		var name string
+		if strings.HasPrefix(val, "{") {
+			j := strings.Index(val, "}")
+			name = val[1:j]
+			val = val[j+1:]
+		} else {
+			j := strings.IndexFunc(val, func(r rune) bool {
+				return !isAlphaNumeric(r)
+			})
+			if j < 0 {
+				j = len(val)
+			}
+			name = val[:j]
+			val = val[j:]
+		}
+		parts = append(parts, p.variable("$"+name, ctx))
+	}
+	if val != "" {
+		parts = append(parts, val)
+	}
+	return parts
+}
+
+// directory scope parser. Files at any depth below directories ending
+// with '/**' are in scope. '/**' on its own starts a comment so the whole
+// database is scoped with the '--recursive' option instead.
+func (p *Parser) dirValue(_token item, ctx string) interface{} {
+	if !strings.HasSuffix(_token.val, bytengine.RecursiveSuffix) {
+		return p.pathValue(_token, ctx)
+	}
+	_token.val = strings.TrimSuffix(_token.val, bytengine.RecursiveSuffix)
+	switch val := p.pathValue(_token, ctx).(type) {
+	case bytengine.Template:
+		return append(val, bytengine.RecursiveSuffix)
+	default:
+		return val.(string) + bytengine.RecursiveSuffix
+	}
+}
+
+// recursiveDir makes a directory scope recursive
+func recursiveDir(dir interface{}) interface{} {
+	switch val := dir.(type) {
+	case bytengine.Template:
+		if val[len(val)-1] == bytengine.RecursiveSuffix {
+			return val
+		}
+		return append(val, bytengine.RecursiveSuffix)
+	default:
+		s := val.(string)
+		if strings.HasSuffix(s, bytengine.RecursiveSuffix) {
+			return s
+		}
+		return strings.TrimSuffix(s, "/") + bytengine.RecursiveSuffix
+	}
+}
+
+// scopeDirs returns the directories of a select, set, unset or delete
+// command. The '--recursive' option makes all of them recursive.
+func (p *Parser) scopeDirs(paths []interface{}, ac optList, ctx string) interface{} {
+	if len(paths) < 1 {
+		p.errorf("Invalid %s: no directories found", ctx)
+	}
+	if ac.Get("recursive") != nil {
+		for i, item := range paths {
+			paths[i] = recursiveDir(item)
+		}
+	}
+	return pathList(paths)
+}
+
+// pathList returns the paths of a command as a []string or, if any of
+// them has variables, as a template list
+func pathList(paths []interface{}) interface{} {
+	list := []string{}
+	for _, item := range paths {
+		s, ok := item.(string)
+		if !ok {
+			return bytengine.TemplateList(paths)
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+// begin/commit keyword parser
+func (p *Parser) parseBlockKeyword(ctx string) {
+	_nxt := p.expectOneOf(itemSemiColon, itemEOF, ctx)
+	if _nxt.typ == itemEOF {
+		p.backup()
+	}
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	p.commands = append(p.commands, cmd)
 }
 
 // copied from go source docs: strconv.Unquote
@@ -404,6 +588,115 @@ func (p *Parser) parseDropDatabaseCmd(ctx string) {
 	p.commands = append(p.commands, cmd)
 }
 
+// attachment garbage collection parser
+func (p *Parser) parseServerGcCmd(ctx string) {
+	_token := p.expect(itemString, ctx)
+	_db, err := formatString(_token.val)
+	if err != nil {
+		p.errorf("Improperly quoted database name in %s", ctx)
+	}
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: true,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Args["database"] = _db
+
+	// parse arguments
+	ac := newOptList()
+	ac.Add("dryrun", optBool)
+	p.parseOptions(ctx, ac)
+	// get arguments
+	arg := ac.Get("dryrun")
+	if arg != nil {
+		cmd.Options["dryrun"] = arg
+	}
+
+	_filter := p.parseEndofCommand(ctx)
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// database versioning parser
+func (p *Parser) parseServerVersioningCmd(ctx string) {
+	_token := p.expect(itemString, ctx)
+	_db, err := formatString(_token.val)
+	if err != nil {
+		p.errorf("Improperly quoted database name in %s", ctx)
+	}
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: true,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Args["database"] = _db
+
+	// setting is returned if on/off is missing
+	if p.peek().typ == itemIdentifier {
+		_token = p.next()
+		switch _token.val {
+		case "on":
+			cmd.Args["enable"] = true
+		case "off":
+			cmd.Args["enable"] = false
+		default:
+			p.errorf("Invalid indentifier "+_token.val+" in %s", ctx)
+		}
+	}
+
+	_filter := p.parseEndofCommand(ctx)
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// database trash parser
+func (p *Parser) parseServerTrashCmd(ctx string) {
+	_token := p.expect(itemString, ctx)
+	_db, err := formatString(_token.val)
+	if err != nil {
+		p.errorf("Improperly quoted database name in %s", ctx)
+	}
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: true,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Args["database"] = _db
+
+	// setting is returned if on/off is missing
+	if p.peek().typ == itemIdentifier {
+		_token = p.next()
+		switch _token.val {
+		case "on":
+			cmd.Args["enable"] = true
+		case "off":
+			cmd.Args["enable"] = false
+		default:
+			p.errorf("Invalid indentifier "+_token.val+" in %s", ctx)
+		}
+
+		// parse arguments
+		ac := newOptList()
+		ac.Add("expiry", optInt)
+		p.parseOptions(ctx, ac)
+		// get arguments
+		arg := ac.Get("expiry")
+		if arg != nil {
+			if arg.(int64) < 0 {
+				p.errorf("Invalid trash expiry in %s", ctx)
+			}
+			cmd.Options["expiry"] = arg
+		}
+	}
+
+	_filter := p.parseEndofCommand(ctx)
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
 // current user info parser
 func (p *Parser) parseWhoamiCmd(ctx string) {
 	_filter := p.parseEndofCommand(ctx)
@@ -613,7 +906,7 @@ func (p *Parser) parseServerInitCmd(ctx string) {
 // create new directory parser
 func (p *Parser) parseNewDirectoryCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_filter := p.parseEndofCommand(ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
@@ -630,7 +923,7 @@ func (p *Parser) parseNewDirectoryCmd(db, ctx string) {
 // create new file parser
 func (p *Parser) parseNewFileCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 
 	// check if next item is a json object
 	var _json interface{}
@@ -656,7 +949,7 @@ func (p *Parser) parseNewFileCmd(db, ctx string) {
 // list directory contents parser
 func (p *Parser) parseListDirectoryCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
 		IsAdmin: false,
@@ -684,7 +977,7 @@ func (p *Parser) parseListDirectoryCmd(db, ctx string) {
 // rename file/directory parser
 func (p *Parser) parseRenameContentCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_token = p.expect(itemString, ctx)
 	_name, err := formatString(_token.val)
 	if err != nil {
@@ -707,9 +1000,9 @@ func (p *Parser) parseRenameContentCmd(db, ctx string) {
 // move file/directory parser
 func (p *Parser) parseMoveContentCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_token = p.expect(itemPath, ctx)
-	_path2 := _token.val
+	_path2 := p.pathValue(_token, ctx)
 	_rename := ""
 	if p.peek().typ == itemString {
 		_nxt := p.next()
@@ -733,9 +1026,9 @@ func (p *Parser) parseMoveContentCmd(db, ctx string) {
 // copy file/directory parser
 func (p *Parser) parseCopyContentCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_token = p.expect(itemPath, ctx)
-	_path2 := _token.val
+	_path2 := p.pathValue(_token, ctx)
 	_rename := ""
 	if p.peek().typ == itemString {
 		_nxt := p.next()
@@ -756,27 +1049,112 @@ func (p *Parser) parseCopyContentCmd(db, ctx string) {
 	p.commands = append(p.commands, cmd)
 }
 
+// expected file revision option parser
+func (p *Parser) parseIfRevOption(ctx string, cmd *bytengine.Command) {
+	ac := newOptList()
+	ac.Add("ifrev", optInt)
+	p.parseOptions(ctx, ac)
+	arg := ac.Get("ifrev")
+	if arg != nil {
+		if arg.(int64) < 0 {
+			p.errorf("Invalid revision number in %s", ctx)
+		}
+		cmd.Options["ifrev"] = arg
+	}
+}
+
 // delete file/directory parser
 func (p *Parser) parseDeleteContentCmd(db, ctx string) {
+	if _next := p.peek(); _next.typ == itemIdentifier && strings.ToLower(_next.val) == "in" {
+		p.parseDeleteWhereCmd(db, ctx)
+		return
+	}
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
-	_filter := p.parseEndofCommand(ctx)
+	_path := p.pathValue(_token, ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
 		IsAdmin: false,
 		Args:    make(map[string]interface{}),
 		Options: make(map[string]interface{}),
 	}
+	p.parseIfRevOption(ctx, &cmd)
+	_filter := p.parseEndofCommand(ctx)
 	cmd.Database = db
 	cmd.Args["path"] = _path
 	cmd.Filter = _filter
 	p.commands = append(p.commands, cmd)
 }
 
+// delete query statement parser e.g. 'delete in /logs where "level" == "debug"'
+func (p *Parser) parseDeleteWhereCmd(db, ctx string) {
+	// absorb 'in'
+	p.next()
+	_paths := []interface{}{}
+	for p.peek().typ == itemPath {
+		_path := p.dirValue(p.next(), ctx)
+		_paths = append(_paths, _path)
+		continue
+	}
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+	var _filter string
+
+	ac := newOptList()
+	ac.Add("ifrev", optInt)
+	ac.Add("paths", optBool)
+	ac.Add("recursive", optBool)
+
+	// get optional identifiers
+Loop:
+	for {
+		switch _token := p.next(); {
+		case _token.typ == itemIdentifier && strings.ToLower(_token.val) == "where":
+			_where := p.parseWhereCmd()
+			cmd.Args["where"] = _where
+			continue
+		case _token.typ == itemOption:
+			p.backup()
+			p.parseOptions(ctx, ac)
+			continue
+		case _token.typ == itemSendTo:
+			p.backup()
+			_filter = p.parseEndofCommand(ctx)
+			break Loop
+		case _token.typ == itemSemiColon:
+			break Loop
+		case _token.typ == itemEOF:
+			// do not consume eof
+			p.backup()
+			break Loop
+		default:
+			p.errorf("Invalid identifier "+_token.val+" in %s", ctx)
+		}
+	}
+
+	if arg := ac.Get("ifrev"); arg != nil {
+		if arg.(int64) < 0 {
+			p.errorf("Invalid revision number in %s", ctx)
+		}
+		cmd.Options["ifrev"] = arg
+	}
+	// return the paths of the deleted files
+	if ac.Get("paths") != nil {
+		cmd.Options["paths"] = true
+	}
+	cmd.Args["dirs"] = p.scopeDirs(_paths, ac, ctx)
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
 // file/directory info parser
 func (p *Parser) parseContentInfoCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_filter := p.parseEndofCommand(ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
@@ -793,7 +1171,7 @@ func (p *Parser) parseContentInfoCmd(db, ctx string) {
 // make file public parser
 func (p *Parser) parseMakeContentPublicCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_filter := p.parseEndofCommand(ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
@@ -810,7 +1188,7 @@ func (p *Parser) parseMakeContentPublicCmd(db, ctx string) {
 // make file private parser
 func (p *Parser) parseMakeContentPrivateCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_filter := p.parseEndofCommand(ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
@@ -827,7 +1205,7 @@ func (p *Parser) parseMakeContentPrivateCmd(db, ctx string) {
 // read file JSON content parser
 func (p *Parser) parseReadFileCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	// check if we have an array of fields to return
 	_list := []string{}
 	if p.peek().typ == itemLeftBracket {
@@ -863,13 +1241,31 @@ func (p *Parser) parseReadFileCmd(db, ctx string) {
 		}
 	}
 
-	_filter := p.parseEndofCommand(ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
 		IsAdmin: false,
 		Args:    make(map[string]interface{}),
 		Options: make(map[string]interface{}),
 	}
+
+	// parse arguments
+	ac := newOptList()
+	ac.Add("rev", optInt)
+	ac.Add("withrev", optBool)
+	p.parseOptions(ctx, ac)
+	// get arguments
+	arg := ac.Get("rev")
+	if arg != nil {
+		cmd.Options["rev"] = arg
+	}
+	if ac.Get("withrev") != nil {
+		if arg != nil {
+			p.errorf("'withrev' cannot be used with 'rev' in %s", ctx)
+		}
+		cmd.Options["withrev"] = true
+	}
+
+	_filter := p.parseEndofCommand(ctx)
 	cmd.Database = db
 	cmd.Args["path"] = _path
 	cmd.Args["fields"] = _list
@@ -877,10 +1273,179 @@ func (p *Parser) parseReadFileCmd(db, ctx string) {
 	p.commands = append(p.commands, cmd)
 }
 
+// file history parser
+func (p *Parser) parseFileHistoryCmd(db, ctx string) {
+	_token := p.expect(itemPath, ctx)
+	_path := p.pathValue(_token, ctx)
+	_filter := p.parseEndofCommand(ctx)
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+	cmd.Args["path"] = _path
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// list attachment versions parser
+func (p *Parser) parseByteVersionsCmd(db, ctx string) {
+	_token := p.expect(itemPath, ctx)
+	_path := p.pathValue(_token, ctx)
+	_filter := p.parseEndofCommand(ctx)
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+	cmd.Args["path"] = _path
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// list trash parser
+func (p *Parser) parseListTrashCmd(db, ctx string) {
+	_filter := p.parseEndofCommand(ctx)
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// restore trash item parser
+func (p *Parser) parseRestoreTrashCmd(db, ctx string) {
+	_token := p.expect(itemString, ctx)
+	_id, err := formatString(_token.val)
+	if err != nil {
+		p.errorf("Improperly quoted trash item id in %s", ctx)
+	}
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+	cmd.Args["id"] = _id
+
+	// item is restored to its original location if path is missing
+	if p.peek().typ == itemPath {
+		_token = p.next()
+		cmd.Args["path"] = p.pathValue(_token, ctx)
+	}
+
+	_filter := p.parseEndofCommand(ctx)
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// purge trash parser
+func (p *Parser) parsePurgeTrashCmd(db, ctx string) {
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+
+	if p.peek().typ == itemString {
+		_token := p.next()
+		_id, err := formatString(_token.val)
+		if err != nil {
+			p.errorf("Improperly quoted trash item id in %s", ctx)
+		}
+		cmd.Args["id"] = _id
+	}
+
+	// parse arguments
+	ac := newOptList()
+	ac.Add("all", optBool)
+	p.parseOptions(ctx, ac)
+	// get arguments
+	arg := ac.Get("all")
+	if arg != nil {
+		cmd.Options["all"] = arg
+	}
+
+	// whole trash is only emptied on request
+	_, hasid := cmd.Args["id"]
+	if hasid == (arg != nil) {
+		p.errorf("Expecting either a trash item id or --all in %s", ctx)
+	}
+
+	_filter := p.parseEndofCommand(ctx)
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// prune attachment versions parser
+func (p *Parser) parsePruneBytesCmd(db, ctx string) {
+	_token := p.expect(itemPath, ctx)
+	_path := p.pathValue(_token, ctx)
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+	cmd.Args["path"] = _path
+
+	// parse arguments
+	ac := newOptList()
+	ac.Add("keep", optInt)
+	p.parseOptions(ctx, ac)
+	// get arguments
+	arg := ac.Get("keep")
+	if arg != nil {
+		if arg.(int64) < 0 {
+			p.errorf("Invalid number of versions to keep in %s", ctx)
+		}
+		cmd.Options["keep"] = arg
+	}
+
+	_filter := p.parseEndofCommand(ctx)
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
+// revert file to revision parser
+func (p *Parser) parseRevertFileCmd(db, ctx string) {
+	_token := p.expect(itemPath, ctx)
+	_path := p.pathValue(_token, ctx)
+	_token = p.expect(itemNumber, ctx)
+	_rev, err := strconv.ParseInt(_token.val, 10, 64) // base 10 64bit integer
+	if err != nil || _rev < 1 {
+		p.errorf("Invalid revision number in %s", ctx)
+	}
+	_filter := p.parseEndofCommand(ctx)
+	cmd := bytengine.Command{
+		Name:    ctx,
+		IsAdmin: false,
+		Args:    make(map[string]interface{}),
+		Options: make(map[string]interface{}),
+	}
+	cmd.Database = db
+	cmd.Args["path"] = _path
+	cmd.Args["revision"] = _rev
+	cmd.Filter = _filter
+	p.commands = append(p.commands, cmd)
+}
+
 // overwrite file JSON parser
 func (p *Parser) parseModifyFileCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 
 	// check if next item is a json object
 	var _json interface{}
@@ -889,13 +1454,14 @@ func (p *Parser) parseModifyFileCmd(db, ctx string) {
 	} else {
 		p.errorf("Expecting a JSON object in %s", ctx)
 	}
-	_filter := p.parseEndofCommand(ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
 		IsAdmin: false,
 		Args:    make(map[string]interface{}),
 		Options: make(map[string]interface{}),
 	}
+	p.parseIfRevOption(ctx, &cmd)
+	_filter := p.parseEndofCommand(ctx)
 	cmd.Database = db
 	cmd.Args["path"] = _path
 	cmd.Args["data"] = _json
@@ -906,7 +1472,7 @@ func (p *Parser) parseModifyFileCmd(db, ctx string) {
 // delete file bytes parser
 func (p *Parser) parseDeleteAttachmentCmd(db, ctx string) {
 	_token := p.expect(itemPath, ctx)
-	_path := _token.val
+	_path := p.pathValue(_token, ctx)
 	_filter := p.parseEndofCommand(ctx)
 	cmd := bytengine.Command{
 		Name:    ctx,
@@ -1010,9 +1576,9 @@ func (p *Parser) parseSelectCmd(db, ctx string) {
 	if strings.ToLower(_in.val) != "in" {
 		p.errorf("Invalid %s, expecting 'In statement'.", ctx)
 	}
-	_paths := []string{}
+	_paths := []interface{}{}
 	for p.peek().typ == itemPath {
-		_path := p.next().val
+		_path := p.dirValue(p.next(), ctx)
 		_paths = append(_paths, _path)
 		continue
 	}
@@ -1024,9 +1590,11 @@ func (p *Parser) parseSelectCmd(db, ctx string) {
 	}
 	cmd.Database = db
 	cmd.Args["fields"] = _fields
-	cmd.Args["dirs"] = _paths
 	var _filter string
 
+	ac := newOptList()
+	ac.Add("recursive", optBool)
+
 	// get optional identifiers
 Loop:
 	for {
@@ -1035,6 +1603,10 @@ Loop:
 			_where := p.parseWhereCmd()
 			cmd.Args["where"] = _where
 			continue
+		case _token.typ == itemOption:
+			p.backup()
+			p.parseOptions(ctx, ac)
+			continue
 		case _token.typ == itemIdentifier && strings.ToLower(_token.val) == "sort":
 			cmd.Args["sort"] = p.parseSortCmd()
 			continue
@@ -1042,6 +1614,20 @@ Loop:
 			// add to select statement
 			cmd.Args["limit"] = p.parseLimitCmd()
 			continue
+		case _token.typ == itemIdentifier && strings.ToLower(_token.val) == "group":
+			// add to select statement
+			_group, _aggregates := p.parseGroupCmd()
+			cmd.Args["group"] = _group
+			cmd.Args["aggregates"] = _aggregates
+			continue
+		case _token.typ == itemIdentifier && strings.ToLower(_token.val) == "offset":
+			// add to select statement
+			cmd.Args["offset"] = p.parseOffsetCmd()
+			continue
+		case _token.typ == itemIdentifier && strings.ToLower(_token.val) == "cursor":
+			// add to select statement
+			cmd.Args["cursor"] = p.parseCursorCmd()
+			continue
 		case _token.typ == itemIdentifier && strings.ToLower(_token.val) == "distinct":
 			// add to select statement
 			cmd.Args["distinct"] = p.parseDistinctCmd()
@@ -1063,11 +1649,40 @@ Loop:
 		}
 	}
 
+	cmd.Args["dirs"] = p.scopeDirs(_paths, ac, ctx)
+
 	// validate select statement
 	_, hascount := cmd.Args["count"]
 	_, haslimit := cmd.Args["limit"]
 	_, hassort := cmd.Args["sort"]
 	_, hasdistinct := cmd.Args["distinct"]
+	_, hasoffset := cmd.Args["offset"]
+	_, hascursor := cmd.Args["cursor"]
+	_, hasgroup := cmd.Args["group"]
+
+	if hasgroup {
+		if len(_fields) > 0 {
+			p.errorf("'Group' cannot be used with selected fields in %s", ctx)
+		}
+		if hascount || hasdistinct || haslimit || hassort || hasoffset || hascursor {
+			p.errorf("'Group' cannot be used with 'Count', 'Distinct', 'Limit', 'Sort', 'Offset' or 'Cursor' in %s", ctx)
+		}
+	}
+
+	if hasoffset || hascursor {
+		if hascount {
+			p.errorf("'Count' cannot be used with 'Offset' or 'Cursor' in %s", ctx)
+		}
+		if hasdistinct {
+			p.errorf("'Distinct' cannot be used with 'Offset' or 'Cursor' in %s", ctx)
+		}
+		if hasoffset && hascursor {
+			p.errorf("'Offset' cannot be used with 'Cursor' in %s", ctx)
+		}
+		if hascursor && (!haslimit || cmd.Args["limit"].(int64) <= 0) {
+			p.errorf("'Cursor' requires a positive 'Limit' in %s", ctx)
+		}
+	}
 
 	if haslimit || hassort {
 		if hascount {
@@ -1106,6 +1721,16 @@ Loop:
 func (p *Parser) parseSetCmd(db, ctx string) {
 	_fields := map[string]interface{}{}
 	_incr := map[string]interface{}{}
+	_updates := map[string]map[string]interface{}{} // update operators
+	_now := []string{}
+	_used := map[string]bool{}
+	// fields can only be changed by one assignment or operator
+	use := func(field string) {
+		if _used[field] {
+			p.errorf("Field '%s' is updated more than once in %s", strings.TrimPrefix(field, FieldPrefix), ctx)
+		}
+		_used[field] = true
+	}
 
 	// get field assignment list
 Loop:
@@ -1116,6 +1741,7 @@ Loop:
 			case itemEqual:
 				p.backup2(i)
 				f, v := p.parseValueAssignment()
+				use(f)
 				_fields[f] = v
 				continue
 			case itemPlusEqual:
@@ -1123,8 +1749,34 @@ Loop:
 			case itemMinusEqual:
 				p.backup2(i)
 				f, v := p.parseIncrDecrValue()
+				use(f)
 				_incr[f] = v
 				continue
+			case itemMultiplyEqual:
+				p.backup2(i)
+				f, v := p.parseMultiplyValue()
+				use(f)
+				if _updates["multiply"] == nil {
+					_updates["multiply"] = map[string]interface{}{}
+				}
+				_updates["multiply"][f] = v
+				continue
+			case itemIdentifier:
+				p.backup2(i)
+				op, f, v := p.parseUpdateOperator()
+				use(f)
+				switch op {
+				case "now":
+					_now = append(_now, f)
+					continue
+				case "rename":
+					use(v.(string))
+				}
+				if _updates[op] == nil {
+					_updates[op] = map[string]interface{}{}
+				}
+				_updates[op][f] = v
+				continue
 			default:
 				p.errorf("Invalid assingment operator in %s", ctx)
 			}
@@ -1134,7 +1786,7 @@ Loop:
 			break Loop
 		}
 	}
-	if len(_fields) < 1 && len(_incr) < 1 {
+	if len(_used) < 1 {
 		p.errorf("Invalid %s: no field assignments found", ctx)
 	}
 
@@ -1143,9 +1795,9 @@ Loop:
 	if strings.ToLower(_in.val) != "in" {
 		p.errorf("Invalid %s, expecting 'In statement'.", ctx)
 	}
-	_paths := []string{}
+	_paths := []interface{}{}
 	for p.peek().typ == itemPath {
-		_path := p.next().val
+		_path := p.dirValue(p.next(), ctx)
 		_paths = append(_paths, _path)
 		continue
 	}
@@ -1160,9 +1812,18 @@ Loop:
 	if len(_incr) > 0 {
 		cmd.Args["incr"] = _incr
 	}
-	cmd.Args["dirs"] = _paths
+	for op, values := range _updates {
+		cmd.Args[op] = values
+	}
+	if len(_now) > 0 {
+		cmd.Args["now"] = _now
+	}
 	var _filter string
 
+	ac := newOptList()
+	ac.Add("ifrev", optInt)
+	ac.Add("recursive", optBool)
+
 	// get optional identifiers
 Loop2:
 	for {
@@ -1171,6 +1832,10 @@ Loop2:
 			_where := p.parseWhereCmd()
 			cmd.Args["where"] = _where
 			continue
+		case _token.typ == itemOption:
+			p.backup()
+			p.parseOptions(ctx, ac)
+			continue
 		case _token.typ == itemSendTo:
 			p.backup()
 			_filter = p.parseEndofCommand(ctx)
@@ -1186,6 +1851,13 @@ Loop2:
 		}
 	}
 
+	if arg := ac.Get("ifrev"); arg != nil {
+		if arg.(int64) < 0 {
+			p.errorf("Invalid revision number in %s", ctx)
+		}
+		cmd.Options["ifrev"] = arg
+	}
+	cmd.Args["dirs"] = p.scopeDirs(_paths, ac, ctx)
 	cmd.Filter = _filter
 	p.commands = append(p.commands, cmd)
 }
@@ -1213,9 +1885,9 @@ func (p *Parser) parseUnsetCmd(db, ctx string) {
 	if strings.ToLower(_in.val) != "in" {
 		p.errorf("Invalid %s, expecting 'In statement'.", ctx)
 	}
-	_paths := []string{}
+	_paths := []interface{}{}
 	for p.peek().typ == itemPath {
-		_path := p.next().val
+		_path := p.dirValue(p.next(), ctx)
 		_paths = append(_paths, _path)
 		continue
 	}
@@ -1227,9 +1899,12 @@ func (p *Parser) parseUnsetCmd(db, ctx string) {
 	}
 	cmd.Database = db
 	cmd.Args["fields"] = _fields
-	cmd.Args["dirs"] = _paths
 	var _filter string
 
+	ac := newOptList()
+	ac.Add("ifrev", optInt)
+	ac.Add("recursive", optBool)
+
 	// get optional identifiers
 Loop2:
 	for {
@@ -1238,6 +1913,10 @@ Loop2:
 			_where := p.parseWhereCmd()
 			cmd.Args["where"] = _where
 			continue
+		case _token.typ == itemOption:
+			p.backup()
+			p.parseOptions(ctx, ac)
+			continue
 		case _token.typ == itemSendTo:
 			p.backup()
 			_filter = p.parseEndofCommand(ctx)
@@ -1253,6 +1932,13 @@ Loop2:
 		}
 	}
 
+	if arg := ac.Get("ifrev"); arg != nil {
+		if arg.(int64) < 0 {
+			p.errorf("Invalid revision number in %s", ctx)
+		}
+		cmd.Options["ifrev"] = arg
+	}
+	cmd.Args["dirs"] = p.scopeDirs(_paths, ac, ctx)
 	cmd.Filter = _filter
 	p.commands = append(p.commands, cmd)
 }
@@ -1296,6 +1982,91 @@ func (p *Parser) parseLimitCmd() int64 {
 	return _number
 }
 
+// group query statement parser e.g. 'group by "country" sum("age")'
+func (p *Parser) parseGroupCmd() ([]bytengine.GroupField, []bytengine.Aggregate) {
+	context := "Select Group Statement"
+	_by := p.expect(itemIdentifier, context)
+	if strings.ToLower(_by.val) != "by" {
+		p.errorf("%s error: Expected 'By'.", context)
+	}
+	_group := []bytengine.GroupField{}
+	_aggregates := []bytengine.Aggregate{}
+Loop:
+	for {
+		switch _next := p.peek(); {
+		case _next.typ == itemString:
+			_field, _name := p.parseGroupField(context)
+			_group = append(_group, bytengine.GroupField{Field: _field, Name: _name})
+		case _next.typ == itemIdentifier && strings.HasPrefix(_next.val, "file_"):
+			_field, _name := p.parseGroupField(context)
+			_group = append(_group, bytengine.GroupField{Field: _field, Name: _name})
+		case _next.typ == itemIdentifier && isAggregate(_next.val):
+			_fn := strings.ToLower(p.next().val)
+			p.expect(itemLeftParenthesis, context)
+			_field, _name := p.parseGroupField(context)
+			p.expect(itemRightParenthesis, context)
+			_agg := bytengine.Aggregate{Func: _fn, Field: _field, Name: _fn + "(" + _name + ")"}
+			_aggregates = append(_aggregates, _agg)
+		default:
+			break Loop
+		}
+	}
+	if len(_group) == 0 {
+		p.errorf("%s error: Group requires at least one field.", context)
+	}
+	return _group, _aggregates
+}
+
+func isAggregate(name string) bool {
+	switch strings.ToLower(name) {
+	case bytengine.AggSum, bytengine.AggAvg, bytengine.AggMin, bytengine.AggMax:
+		return true
+	}
+	return false
+}
+
+// group field parser: quoted content fields or file metadata tags
+func (p *Parser) parseGroupField(context string) (string, string) {
+	_next := p.expectOneOf(itemString, itemIdentifier, context)
+	if _next.typ == itemIdentifier {
+		_field := fileMetaToField(_next.val)
+		if _field == "" {
+			p.errorf("Invalid file metadata tag %s in %s", _next.val, context)
+		}
+		return _field, _next.val
+	}
+	_name, err := formatString(_next.val)
+	if err != nil {
+		p.errorf("Improperly quoted field name in %s", context)
+	}
+	return FieldPrefix + _name, _name
+}
+
+// offset query statement parser
+func (p *Parser) parseOffsetCmd() int64 {
+	context := "Select Offset Statement"
+	_next := p.expect(itemNumber, context)
+	// check if value is a positive Int
+	_number, err := strconv.ParseInt(_next.val, 10, 64)
+	if err != nil || _number < 0 {
+		p.errorf("%s error: Offset requires a positive integer value.", context)
+	}
+	return _number
+}
+
+// cursor query statement parser. The cursor of the first page is empty.
+func (p *Parser) parseCursorCmd() interface{} {
+	context := "Select Cursor Statement"
+	switch p.peek().typ {
+	case itemString:
+		return p.parseString()
+	case itemVariable:
+		return p.variable(p.next().val, context)
+	default:
+		return ""
+	}
+}
+
 // distinct query statement parser
 func (p *Parser) parseDistinctCmd() string {
 	context := "Select Distinct Statement"
@@ -1337,6 +2108,10 @@ Loop:
 		case itemNull:
 			p.next()
 			_list = append(_list, nil)
+		case itemVariable:
+			_next := p.next()
+			_list = append(_list, p.variable(_next.val, context))
+			continue
 		case itemNumber:
 			_next := p.next()
 			// go uses float64 for json numerical values
@@ -1410,6 +2185,7 @@ func (p *Parser) parseJSON(context string) map[string]interface{} {
 	// check if next item is a json object
 	_objlevel := 0
 	_json := ""
+	_vars := []bytengine.Variable{}
 Loop:
 	for {
 		switch _next := p.next(); {
@@ -1438,6 +2214,10 @@ Loop:
 			}
 			p.backup()
 			break Loop
+		case _next.typ == itemVariable:
+			// replaced by a placeholder string until the json is decoded
+			_json += fmt.Sprintf(`"\u0000%d"`, len(_vars))
+			_vars = append(_vars, p.variable(_next.val, context))
 		default:
 			_json += _next.val
 		}
@@ -1450,10 +2230,35 @@ Loop:
 	if err != nil {
 		p.errorf("Invalid json object in %s", context)
 	}
+	if len(_vars) > 0 {
+		return jsonVariables(_i, _vars).(map[string]interface{})
+	}
 
 	return _i
 }
 
+// jsonVariables replaces the variable placeholders of a decoded json value
+func jsonVariables(v interface{}, vars []bytengine.Variable) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = jsonVariables(item, vars)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = jsonVariables(item, vars)
+		}
+	case string:
+		if strings.HasPrefix(val, "\x00") {
+			i, err := strconv.Atoi(val[1:])
+			if err == nil && i < len(vars) {
+				return vars[i]
+			}
+		}
+	}
+	return v
+}
+
 // value assignment parser
 func (p *Parser) parseValueAssignment() (string, interface{}) {
 	context := "Assignment Statement"
@@ -1466,7 +2271,11 @@ func (p *Parser) parseValueAssignment() (string, interface{}) {
 	_field = FieldPrefix + _field
 	//absorb equals
 	p.expect(itemEqual, context)
-	// get value string, array, json, number
+	return _field, p.parseValue(context)
+}
+
+// field value parser: string, array, json, number, boolean, null or variable
+func (p *Parser) parseValue(context string) interface{} {
 	var _val interface{}
 	switch p.peek().typ {
 	case itemString:
@@ -1476,15 +2285,80 @@ func (p *Parser) parseValueAssignment() (string, interface{}) {
 	case itemNumber:
 		_val = p.parseNumber()
 	case itemNull:
+		p.next()
 		_val = nil
 	case itemLeftBracket:
 		_val = p.parseArray()
 	case itemLeftBrace:
 		_val = p.parseJSON(context)
+	case itemVariable:
+		_val = p.variable(p.next().val, context)
 	default:
 		p.errorf("Invalid field value for %s", context)
 	}
-	return _field, _val
+	return _val
+}
+
+// multiplication value parser
+func (p *Parser) parseMultiplyValue() (string, interface{}) {
+	context := "Multiplication Statement"
+	// get field
+	_next := p.expect(itemString, context)
+	_field, err := formatString(_next.val)
+	if err != nil {
+		p.errorf("Improperly quoted field name in %s", context)
+	}
+	_field = FieldPrefix + _field
+	p.expect(itemMultiplyEqual, context)
+	// get value only number
+	if p.peek().typ != itemNumber {
+		p.errorf("Invalid field value for %s", context)
+	}
+	return _field, p.parseNumber()
+}
+
+// update operator parser e.g. '"tags" push "new"' or '"updated" now'
+func (p *Parser) parseUpdateOperator() (string, string, interface{}) {
+	context := "Update Operator Statement"
+	// get field
+	_next := p.expect(itemString, context)
+	_field, err := formatString(_next.val)
+	if err != nil {
+		p.errorf("Improperly quoted field name in %s", context)
+	}
+	_field = FieldPrefix + _field
+	// get operator
+	_op := strings.ToLower(p.expect(itemIdentifier, context).val)
+	switch _op {
+	case "push", "pull", "addtoset", "min", "max":
+		return _op, _field, p.parseValue(context)
+	case "pop":
+		// remove first or last array element
+		_next = p.expect(itemIdentifier, context)
+		switch strings.ToLower(_next.val) {
+		case "first":
+			return _op, _field, int64(-1)
+		case "last":
+			return _op, _field, int64(1)
+		default:
+			p.errorf("%s error: Expected 'First' or 'Last'.", context)
+		}
+	case "rename":
+		_next = p.expect(itemString, context)
+		_name, err := formatString(_next.val)
+		if err != nil || _name == "" {
+			p.errorf("Improperly quoted field name in %s", context)
+		}
+		return _op, _field, FieldPrefix + _name
+	case "now":
+		// current timestamp
+		return _op, _field, nil
+	default:
+		p.errorf("Invalid update operator %s in %s", _op, context)
+	}
+
+	// shouldnt get here
+	return "", "", nil
 }
 
 // increment/decrement value parser
@@ -1532,7 +2406,7 @@ func fileMetaToField(meta string) string {
 }
 
 // simple where statement parser
-func (p *Parser) parseSimpleWhereCondition() map[string]interface{} {
+func (p *Parser) parseSimpleWhereCondition() bytengine.Condition {
 	context := "Where Condition Statement"
 	_next := p.next()
 	var _field string
@@ -1564,24 +2438,17 @@ func (p *Parser) parseSimpleWhereCondition() map[string]interface{} {
 			_val = p.parseArray()
 		case itemLeftBrace:
 			_val = p.parseJSON(context)
+		case itemVariable:
+			_val = p.variable(p.next().val, context)
 		default:
 			p.errorf("Invalid field value for %s", context)
 		}
 
 		// get operetor
 		switch _next2.val {
-		case "==":
-			return map[string]interface{}{_field: _val}
-		case "!=":
-			return map[string]interface{}{_field: map[string]interface{}{"$ne": _val}}
-		case "<":
-			return map[string]interface{}{_field: map[string]interface{}{"$lt": _val}}
-		case "<=":
-			return map[string]interface{}{_field: map[string]interface{}{"$lte": _val}}
-		case ">":
-			return map[string]interface{}{_field: map[string]interface{}{"$gt": _val}}
-		case ">=":
-			return map[string]interface{}{_field: map[string]interface{}{"$gte": _val}}
+		case bytengine.OpEqual, bytengine.OpNotEqual, bytengine.OpLesser,
+			bytengine.OpLesserEqual, bytengine.OpGreater, bytengine.OpGreaterEqual:
+			return bytengine.Comparison{Field: _field, Op: _next2.val, Value: _val}
 		default:
 			p.errorf("Invalid operator for %s", context)
 		}
@@ -1590,11 +2457,11 @@ func (p *Parser) parseSimpleWhereCondition() map[string]interface{} {
 		case "in":
 			// expect array
 			_val := p.parseArray()
-			return map[string]interface{}{_field: map[string]interface{}{"$in": _val}}
+			return bytengine.In{Field: _field, Values: _val}
 		case "nin":
 			// expect array
 			_val := p.parseArray()
-			return map[string]interface{}{_field: map[string]interface{}{"$nin": _val}}
+			return bytengine.In{Field: _field, Values: _val, Negate: true}
 		default:
 			p.errorf("Invalid operator for %s", context)
 		}
@@ -1607,7 +2474,7 @@ func (p *Parser) parseSimpleWhereCondition() map[string]interface{} {
 }
 
 // value type where statement parser
-func (p *Parser) parseTypeofWhereCondition() map[string]interface{} {
+func (p *Parser) parseTypeofWhereCondition() bytengine.Condition {
 	context := "Where Typeof Condition Statement"
 	// absorb typeof
 	p.next()
@@ -1634,23 +2501,12 @@ func (p *Parser) parseTypeofWhereCondition() map[string]interface{} {
 	if err != nil {
 		p.errorf("Improperly quoted Type value in %s", context)
 	}
-	_typenum := -1
-	switch _typetext {
-	case "string":
-		_typenum = 2 // mongodb specific
-	case "int":
-		_typenum = 16 // mongodb specific
-	}
 
-	if _isequal {
-		return map[string]interface{}{_field: map[string]interface{}{"$type": _typenum}}
-	}
-
-	return map[string]interface{}{_field: map[string]interface{}{"$not": map[string]interface{}{"$type": _typenum}}}
+	return bytengine.TypeOf{Field: _field, Type: _typetext, Negate: !_isequal}
 }
 
 // exists where statement parser
-func (p *Parser) parseExistsWhereCondition() map[string]interface{} {
+func (p *Parser) parseExistsWhereCondition() bytengine.Condition {
 	context := "Where Exists Condition Statement"
 	// absorb exists
 	p.next()
@@ -1678,24 +2534,10 @@ func (p *Parser) parseExistsWhereCondition() map[string]interface{} {
 		_val = false
 	}
 
-	if _isequal {
-		if _val == true {
-			return map[string]interface{}{_field: map[string]interface{}{"$exists": true}}
-		}
-		return map[string]interface{}{_field: map[string]interface{}{"$exists": false}}
-	} else {
-		if _val == true {
-			return map[string]interface{}{_field: map[string]interface{}{"$exists": false}}
-		}
-
-		return map[string]interface{}{_field: map[string]interface{}{"$exists": true}}
-	}
-
-	// shouldn't reach here
-	return nil
+	return bytengine.Exists{Field: _field, Exists: _val == _isequal}
 }
 
-func (p *Parser) parseRegexWhereCondition() map[string]interface{} {
+func (p *Parser) parseRegexWhereCondition() bytengine.Condition {
 	context := "Where Regex Condition Statement"
 	// absorb regex
 	p.next()
@@ -1732,9 +2574,7 @@ func (p *Parser) parseRegexWhereCondition() map[string]interface{} {
 		p.errorf("Improperly quoted regex pattern value in %s", context)
 	}
 
-	_repattern := bson.RegEx{Pattern: _pattern_text, Options: _opt}
-	_rgx := map[string]interface{}{"$regex": _repattern}
-	return map[string]interface{}{_field: _rgx}
+	return bytengine.Regex{Field: _field, Pattern: _pattern_text, Options: _opt}
 }
 
 // Where parser mode
@@ -1743,17 +2583,17 @@ const (
 	ConditionalAnd
 )
 
-// where statement parser
-func (p *Parser) parseWhereCmd() map[string]interface{} {
+// where statement parser. All 'and' conditions must match as well as one of
+// the 'or' conditions if any.
+func (p *Parser) parseWhereCmd() bytengine.Condition {
 	context := "Where Statement"
-	_where := map[string]interface{}{}
-	_and := []map[string]interface{}{}
-	_or := []map[string]interface{}{}
+	_and := []bytengine.Condition{}
+	_or := []bytengine.Condition{}
 	_mode := ConditionalAnd
 Loop:
 	for {
 		// condition statement
-		var _condition map[string]interface{}
+		var _condition bytengine.Condition
 
 		switch p.peek().typ {
 		case itemString:
@@ -1815,8 +2655,12 @@ Loop:
 		p.errorf("Invalid syntax for %s", context)
 	}
 
-	// add conditions to where
-	_where["and"] = _and
-	_where["or"] = _or
-	return _where
+	// build condition tree
+	if len(_or) > 0 {
+		_and = append(_and, bytengine.Or{Conditions: _or})
+	}
+	if len(_and) == 1 {
+		return _and[0]
+	}
+	return bytengine.And{Conditions: _and}
 }
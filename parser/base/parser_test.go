@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/johnwilson/bytengine"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,6 +32,9 @@ func TestServerCommands(t *testing.T) {
 	p := NewParser()
 	p.registry.NewServerItem("listdb", "dbs", p.parseListDatabasesCmd)
 	p.registry.NewServerItem("init", "", p.parseServerInitCmd)
+	p.registry.NewServerItem("gc", "", p.parseServerGcCmd)
+	p.registry.NewServerItem("versioning", "", p.parseServerVersioningCmd)
+	p.registry.NewServerItem("trash", "", p.parseServerTrashCmd)
 
 	s := `server.listdb --regex="^\\w"`
 	cmdlist, err := p.Parse(s)
@@ -53,4 +57,458 @@ func TestServerCommands(t *testing.T) {
 	assert.Len(t, cmdlist, 2, "wrong number of commands parsed")
 	assert.Equal(t, cmdlist[0].Name, "server.init", "wrong command name")
 	assert.Equal(t, cmdlist[1].Name, "server.listdb", "wrong command name")
+
+	s = `server.gc "mydb" --dryrun`
+	cmdlist, err = p.Parse(s)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Len(t, cmdlist, 1, "wrong number of commands parsed")
+	cmd = cmdlist[0]
+	assert.Equal(t, cmd.Name, "server.gc", "wrong command name")
+	assert.True(t, cmd.IsAdmin, "gc should be an admin command")
+	assert.Equal(t, cmd.Args["database"], "mydb", "wrong database")
+	assert.Equal(t, cmd.Options["dryrun"], true, "missing dryrun option")
+
+	cmdlist, err = p.Parse(`server.gc "mydb"`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	_, ok := cmdlist[0].Options["dryrun"]
+	assert.False(t, ok, "unexpected dryrun option")
+
+	cmdlist, err = p.Parse(`server.versioning "mydb" on`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	cmd = cmdlist[0]
+	assert.True(t, cmd.IsAdmin, "versioning should be an admin command")
+	assert.Equal(t, cmd.Args["database"], "mydb", "wrong database")
+	assert.Equal(t, cmd.Args["enable"], true, "wrong versioning setting")
+	cmdlist, err = p.Parse(`server.versioning "mydb" off`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Args["enable"], false, "wrong versioning setting")
+	cmdlist, err = p.Parse(`server.versioning "mydb"`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	_, ok = cmdlist[0].Args["enable"]
+	assert.False(t, ok, "unexpected versioning setting")
+	_, err = p.Parse(`server.versioning "mydb" maybe`)
+	assert.NotNil(t, err, "invalid versioning setting parsed")
+
+	cmdlist, err = p.Parse(`server.trash "mydb" on --expiry=30`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	cmd = cmdlist[0]
+	assert.True(t, cmd.IsAdmin, "trash should be an admin command")
+	assert.Equal(t, cmd.Args["enable"], true, "wrong trash setting")
+	assert.Equal(t, cmd.Options["expiry"], int64(30), "wrong trash expiry")
+	cmdlist, err = p.Parse(`server.trash "mydb" off`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Args["enable"], false, "wrong trash setting")
+	cmdlist, err = p.Parse(`server.trash "mydb"`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	_, ok = cmdlist[0].Args["enable"]
+	assert.False(t, ok, "unexpected trash setting")
+	_, err = p.Parse(`server.trash "mydb" on --expiry=-1`)
+	assert.NotNil(t, err, "negative trash expiry parsed")
+	_, err = p.Parse(`server.trash "mydb" --expiry=1`)
+	assert.NotNil(t, err, "trash expiry without setting parsed")
+}
+
+func TestTrashCommands(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("trash", "", p.parseListTrashCmd)
+	p.registry.NewDatabaseItem("restore", "", p.parseRestoreTrashCmd)
+	p.registry.NewDatabaseItem("purge", "", p.parsePurgeTrashCmd)
+
+	cmdlist, err := p.Parse(`@test.trash`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Name, "database.trash", "wrong command name")
+
+	cmdlist, err = p.Parse(`@test.restore "abc"`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Name, "database.restore", "wrong command name")
+	assert.Equal(t, cmdlist[0].Args["id"], "abc", "wrong trash item id")
+	_, ok := cmdlist[0].Args["path"]
+	assert.False(t, ok, "unexpected restore path")
+	cmdlist, err = p.Parse(`@test.restore "abc" /docs/old`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Args["path"], "/docs/old", "wrong restore path")
+	_, err = p.Parse(`@test.restore /docs/old`)
+	assert.NotNil(t, err, "restore without id parsed")
+
+	cmdlist, err = p.Parse(`@test.purge "abc"`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Name, "database.purge", "wrong command name")
+	assert.Equal(t, cmdlist[0].Args["id"], "abc", "wrong trash item id")
+	cmdlist, err = p.Parse(`@test.purge --all`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Options["all"], true, "wrong purge option")
+	_, err = p.Parse(`@test.purge`)
+	assert.NotNil(t, err, "purge without id parsed")
+	_, err = p.Parse(`@test.purge "abc" --all`)
+	assert.NotNil(t, err, "purge with id and --all parsed")
+}
+
+func TestHistoryCommands(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("readfile", "read", p.parseReadFileCmd)
+	p.registry.NewDatabaseItem("history", "", p.parseFileHistoryCmd)
+	p.registry.NewDatabaseItem("revert", "", p.parseRevertFileCmd)
+
+	cmdlist, err := p.Parse(`@test.history /docs/a`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Name, "database.history", "wrong command name")
+	assert.Equal(t, cmdlist[0].Args["path"], "/docs/a", "wrong path")
+
+	cmdlist, err = p.Parse(`@test.readfile /docs/a ["title"] --rev=3`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Args["fields"], []string{"title"}, "wrong fields")
+	assert.Equal(t, cmdlist[0].Options["rev"], int64(3), "wrong revision")
+	cmdlist, err = p.Parse(`@test.read /docs/a`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	_, ok := cmdlist[0].Options["rev"]
+	assert.False(t, ok, "unexpected revision")
+
+	cmdlist, err = p.Parse(`@test.revert /docs/a 2`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Name, "database.revert", "wrong command name")
+	assert.Equal(t, cmdlist[0].Args["revision"], int64(2), "wrong revision")
+	_, err = p.Parse(`@test.revert /docs/a 0`)
+	assert.NotNil(t, err, "invalid revision parsed")
+	_, err = p.Parse(`@test.revert /docs/a`)
+	assert.NotNil(t, err, "missing revision parsed")
+}
+
+func TestBytesVersionCommands(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("byteversions", "", p.parseByteVersionsCmd)
+	p.registry.NewDatabaseItem("prunebytes", "", p.parsePruneBytesCmd)
+
+	cmdlist, err := p.Parse(`@test.byteversions /files/doc`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Name, "database.byteversions", "wrong command name")
+	assert.Equal(t, cmdlist[0].Args["path"], "/files/doc", "wrong path")
+
+	cmdlist, err = p.Parse(`@test.prunebytes /files/doc --keep=2`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Name, "database.prunebytes", "wrong command name")
+	assert.Equal(t, cmdlist[0].Options["keep"], int64(2), "wrong number of versions")
+	cmdlist, err = p.Parse(`@test.prunebytes /files/doc`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	_, ok := cmdlist[0].Options["keep"]
+	assert.False(t, ok, "unexpected number of versions")
+	_, err = p.Parse(`@test.prunebytes /files/doc --keep=-1`)
+	assert.NotNil(t, err, "negative number of versions parsed")
+}
+
+func TestRevisionOptions(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("delete", "rm", p.parseDeleteContentCmd)
+	p.registry.NewDatabaseItem("readfile", "read", p.parseReadFileCmd)
+	p.registry.NewDatabaseItem("updatefile", "update", p.parseModifyFileCmd)
+	p.registry.NewDatabaseItem("set", "", p.parseSetCmd)
+	p.registry.NewDatabaseItem("unset", "", p.parseUnsetCmd)
+
+	cmdlist, err := p.Parse(`@test.updatefile /docs/a {"title":"new"} --ifrev=3`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Options["ifrev"], int64(3), "wrong expected revision")
+	cmdlist, err = p.Parse(`@test.updatefile /docs/a {"title":"new"}`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	_, ok := cmdlist[0].Options["ifrev"]
+	assert.False(t, ok, "unexpected expected revision")
+	_, err = p.Parse(`@test.updatefile /docs/a {"title":"new"} --ifrev=-1`)
+	assert.NotNil(t, err, "negative revision parsed")
+
+	cmdlist, err = p.Parse(`@test.delete /docs/a --ifrev=2`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Args["path"], "/docs/a", "wrong path")
+	assert.Equal(t, cmdlist[0].Options["ifrev"], int64(2), "wrong expected revision")
+
+	cmdlist, err = p.Parse(`@test.set "v"=1 in /docs where "v" == 0 --ifrev=4`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Options["ifrev"], int64(4), "wrong expected revision")
+	cmdlist, err = p.Parse(`@test.unset "v" in /docs --ifrev=5`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Options["ifrev"], int64(5), "wrong expected revision")
+
+	cmdlist, err = p.Parse(`@test.readfile /docs/a ["title"] --withrev`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, cmdlist[0].Options["withrev"], true, "revision not requested")
+	_, err = p.Parse(`@test.readfile /docs/a ["title"] --rev=2 --withrev`)
+	assert.NotNil(t, err, "history revision read with current revision")
+}
+
+func TestAtomicBlocks(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("newfile", "", p.parseNewFileCmd)
+	p.registry.NewDatabaseItem("delete", "rm", p.parseDeleteContentCmd)
+
+	cmdlist, err := p.Parse(`BEGIN; @test.newfile /a {}; @test.delete /b; commit;`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Len(t, cmdlist, 4, "wrong number of commands parsed")
+	assert.Equal(t, bytengine.BeginCommand, cmdlist[0].Name, "missing begin command")
+	assert.Equal(t, "database.newfile", cmdlist[1].Name, "wrong command name")
+	assert.Equal(t, bytengine.CommitCommand, cmdlist[3].Name, "missing commit command")
+
+	_, err = p.Parse(`begin; @test.newfile /a {}`)
+	assert.NotNil(t, err, "begin without commit parsed")
+	_, err = p.Parse(`@test.newfile /a {}; commit`)
+	assert.NotNil(t, err, "commit without begin parsed")
+	_, err = p.Parse(`begin; begin; @test.newfile /a {}; commit; commit`)
+	assert.NotNil(t, err, "nested blocks parsed")
+}
+
+func TestScriptVariables(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("counter", "", p.parseCounterCmd)
+	p.registry.NewDatabaseItem("newfile", "", p.parseNewFileCmd)
+	p.registry.NewDatabaseItem("select", "", p.parseSelectCmd)
+	p.registry.NewDatabaseItem("set", "", p.parseSetCmd)
+
+	s := `let $n = @test.counter "users" incr 1;
+	@test.newfile /users/user_$n {"id":$n, "tags":["a", $tag.name]};
+	@test.set "owner"=$user.name in /docs/${user.id} where "id" == $n`
+	cmdlist, err := p.Parse(s)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Len(t, cmdlist, 3, "wrong number of commands parsed")
+	assert.Equal(t, "n", cmdlist[0].Assign, "wrong assigned variable")
+
+	n := bytengine.Variable{Name: "n", Fields: []string{}}
+	assert.Equal(t, bytengine.Template{"/users/user_", n}, cmdlist[1].Args["path"], "wrong path template")
+	data := map[string]interface{}{
+		"id":   n,
+		"tags": []interface{}{"a", bytengine.Variable{Name: "tag", Fields: []string{"name"}}},
+	}
+	assert.Equal(t, data, cmdlist[1].Args["data"], "wrong json variables")
+
+	dirs := bytengine.TemplateList{
+		bytengine.Template{"/docs/", bytengine.Variable{Name: "user", Fields: []string{"id"}}},
+	}
+	assert.Equal(t, dirs, cmdlist[2].Args["dirs"], "wrong directory templates")
+	where := bytengine.Comparison{Field: "content.id", Op: bytengine.OpEqual, Value: n}
+	assert.Equal(t, where, cmdlist[2].Args["where"], "wrong where variable")
+
+	_, err = p.Parse(`let $n.x = @test.counter "users" incr 1`)
+	assert.NotNil(t, err, "field assignment parsed")
+	_, err = p.Parse(`let $n = begin; commit`)
+	assert.NotNil(t, err, "block keyword assigned")
+	_, err = p.Parse(`@test.newfile /a {"id":$}`)
+	assert.NotNil(t, err, "missing variable name parsed")
+	_, err = p.Parse(`@test.newfile /a {"id":$n.}`)
+	assert.NotNil(t, err, "missing variable field parsed")
+}
+
+func TestSelectPagination(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("select", "", p.parseSelectCmd)
+
+	cmdlist, err := p.Parse(`@test.select "name" in /users sort asc "name" offset 20 limit 10`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, int64(20), cmdlist[0].Args["offset"], "wrong offset")
+
+	cmdlist, err = p.Parse(`@test.select "name" in /users limit 10 cursor`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, "", cmdlist[0].Args["cursor"], "wrong first page cursor")
+	cmdlist, err = p.Parse(`@test.select "name" in /users limit 10 cursor "abc"`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, "abc", cmdlist[0].Args["cursor"], "wrong cursor")
+	cmdlist, err = p.Parse(`@test.select "name" in /users limit 10 cursor $page.cursor`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, bytengine.Variable{Name: "page", Fields: []string{"cursor"}}, cmdlist[0].Args["cursor"], "wrong cursor variable")
+
+	invalid := []string{
+		`@test.select "name" in /users offset -1`,
+		`@test.select "name" in /users offset 2 count`,
+		`@test.select "name" in /users offset 2 distinct "name"`,
+		`@test.select "name" in /users cursor`,
+		`@test.select "name" in /users limit 0 cursor`,
+		`@test.select "name" in /users limit 10 offset 2 cursor`,
+	}
+	for _, s := range invalid {
+		_, err = p.Parse(s)
+		assert.NotNil(t, err, fmt.Sprintf("'%s' parsed", s))
+	}
+}
+
+func TestSelectGroup(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("select", "", p.parseSelectCmd)
+
+	cmdlist, err := p.Parse(`@test.select in /users where "age" > 18 group by "country" file_mime sum("age") AVG(file_size)`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	group := []bytengine.GroupField{
+		{Field: "content.country", Name: "country"},
+		{Field: "__bytes__.mime", Name: "file_mime"},
+	}
+	assert.Equal(t, group, cmdlist[0].Args["group"], "wrong group fields")
+	aggregates := []bytengine.Aggregate{
+		{Func: bytengine.AggSum, Field: "content.age", Name: "sum(age)"},
+		{Func: bytengine.AggAvg, Field: "__bytes__.size", Name: "avg(file_size)"},
+	}
+	assert.Equal(t, aggregates, cmdlist[0].Args["aggregates"], "wrong aggregates")
+	assert.NotNil(t, cmdlist[0].Args["where"], "missing where condition")
+
+	invalid := []string{
+		`@test.select in /users group "country"`,
+		`@test.select in /users group by sum("age")`,
+		`@test.select in /users group by file_owner`,
+		`@test.select in /users group by "country" sum(file_owner)`,
+		`@test.select "name" in /users group by "country"`,
+		`@test.select in /users group by "country" limit 2`,
+		`@test.select in /users group by "country" count`,
+	}
+	for _, s := range invalid {
+		_, err = p.Parse(s)
+		assert.NotNil(t, err, fmt.Sprintf("'%s' parsed", s))
+	}
+}
+
+func TestRecursiveScope(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("select", "", p.parseSelectCmd)
+	p.registry.NewDatabaseItem("set", "", p.parseSetCmd)
+	p.registry.NewDatabaseItem("unset", "", p.parseUnsetCmd)
+	p.registry.NewDatabaseItem("delete", "rm", p.parseDeleteContentCmd)
+
+	cmdlist, err := p.Parse(`@test.select "name" in /docs/** /users; @test.unset "name" in /users/a/**`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Equal(t, []string{"/docs/**", "/users"}, cmdlist[0].Args["dirs"], "wrong recursive directories")
+	assert.Equal(t, []string{"/users/a/**"}, cmdlist[1].Args["dirs"], "wrong recursive unset directories")
+
+	cmdlist, err = p.Parse(`@test.select "name" in /docs/$dir/**`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	dirs := bytengine.TemplateList{
+		bytengine.Template{"/docs/", bytengine.Variable{Name: "dir", Fields: []string{}}, "/**"},
+	}
+	assert.Equal(t, dirs, cmdlist[0].Args["dirs"], "wrong recursive directory template")
+
+	// the recursive option applies to all directories, including the root
+	script := `@test.select "name" in / /docs/** /users/ --recursive where "age" > 1;
+	@test.set "name"="x" in /users/$dir --recursive --ifrev=2;
+	@test.unset "name" in /users --recursive;
+	@test.delete in / --recursive --paths`
+	cmdlist, err = p.Parse(script)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Len(t, cmdlist, 4, "wrong number of commands parsed")
+	assert.Equal(t, []string{"/**", "/docs/**", "/users/**"}, cmdlist[0].Args["dirs"], "wrong recursive select directories")
+	dirs = bytengine.TemplateList{
+		bytengine.Template{"/users/", bytengine.Variable{Name: "dir", Fields: []string{}}, "/**"},
+	}
+	assert.Equal(t, dirs, cmdlist[1].Args["dirs"], "wrong recursive set directories")
+	assert.Equal(t, int64(2), cmdlist[1].Options["ifrev"], "wrong revision option")
+	assert.Equal(t, []string{"/users/**"}, cmdlist[2].Args["dirs"], "wrong recursive unset directories")
+	assert.Equal(t, []string{"/**"}, cmdlist[3].Args["dirs"], "wrong recursive delete directories")
+
+	invalid := []string{
+		`@test.select "name" in /docs/*`,
+		`@test.select "name" in /docs/**/a`,
+		`@test.delete /docs/**`,
+		// '/**' starts a comment
+		`@test.select "name" in /** all files */ where "age" > 1`,
+		`@test.unset "name" in /**`,
+	}
+	for _, s := range invalid {
+		_, err = p.Parse(s)
+		assert.NotNil(t, err, fmt.Sprintf("'%s' parsed", s))
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("delete", "rm", p.parseDeleteContentCmd)
+
+	cmdlist, err := p.Parse(`@test.delete in /logs /tmp/** where "level" == "debug" --ifrev=2 --paths; @test.rm /logs`)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Len(t, cmdlist, 2, "wrong number of commands parsed")
+	assert.Equal(t, []string{"/logs", "/tmp/**"}, cmdlist[0].Args["dirs"], "wrong directories")
+	where := bytengine.Comparison{Field: "content.level", Op: bytengine.OpEqual, Value: "debug"}
+	assert.Equal(t, where, cmdlist[0].Args["where"], "wrong where condition")
+	assert.Equal(t, int64(2), cmdlist[0].Options["ifrev"], "wrong revision option")
+	assert.Equal(t, true, cmdlist[0].Options["paths"], "wrong paths option")
+	assert.Equal(t, "/logs", cmdlist[1].Args["path"], "wrong path of single delete")
+
+	_, err = p.Parse(`@test.delete in where "level" == "debug"`)
+	assert.NotNil(t, err, "delete without directories parsed")
+	_, err = p.Parse(`@test.delete in /logs --ifrev=-1`)
+	assert.NotNil(t, err, "negative revision parsed")
+}
+
+func TestSetNull(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("set", "", p.parseSetCmd)
+
+	// null is consumed so that assignments and clauses can follow it
+	s := `@test.set "a"=null in /docs where "b" == 1; @test.set "a"=null "b"=1 in /docs`
+	cmdlist, err := p.Parse(s)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Len(t, cmdlist, 2, "wrong number of commands parsed")
+	assert.Equal(t, map[string]interface{}{"content.a": nil}, cmdlist[0].Args["fields"], "wrong null assignment")
+	assert.Equal(t, []string{"/docs"}, cmdlist[0].Args["dirs"], "wrong directories")
+	where := bytengine.Comparison{Field: "content.b", Op: bytengine.OpEqual, Value: float64(1)}
+	assert.Equal(t, where, cmdlist[0].Args["where"], "wrong where condition")
+	fields := map[string]interface{}{"content.a": nil, "content.b": float64(1)}
+	assert.Equal(t, fields, cmdlist[1].Args["fields"], "wrong assignments after null")
+
+	// '*' is only valid in '*=' outside of paths
+	_, err = p.Parse(`@test.set "a"*2 in /docs`)
+	assert.NotNil(t, err, "lone '*' parsed")
+}
+
+func TestSetOperators(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("set", "", p.parseSetCmd)
+
+	s := `@test.set "a"=null "b"+=1 "c"*=2 "tags" push {"k":1} "set" addtoset "x" "old" pull [1]
+	"list" pop first "last" pop last "low" min 0 "high" max $max "name" rename "title" "updated" NOW
+	in /docs`
+	cmdlist, err := p.Parse(s)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	args := cmdlist[0].Args
+	assert.Equal(t, map[string]interface{}{"content.a": nil}, args["fields"], "wrong assignments")
+	assert.Equal(t, map[string]interface{}{"content.b": float64(1)}, args["incr"], "wrong increments")
+	assert.Equal(t, map[string]interface{}{"content.c": float64(2)}, args["multiply"], "wrong multiplications")
+	assert.Equal(t, map[string]interface{}{"content.tags": map[string]interface{}{"k": float64(1)}}, args["push"], "wrong push")
+	assert.Equal(t, map[string]interface{}{"content.set": "x"}, args["addtoset"], "wrong addtoset")
+	assert.Equal(t, map[string]interface{}{"content.old": []interface{}{float64(1)}}, args["pull"], "wrong pull")
+	assert.Equal(t, map[string]interface{}{"content.list": int64(-1), "content.last": int64(1)}, args["pop"], "wrong pop")
+	assert.Equal(t, map[string]interface{}{"content.low": float64(0)}, args["min"], "wrong min")
+	assert.Equal(t, map[string]interface{}{"content.high": bytengine.Variable{Name: "max", Fields: []string{}}}, args["max"], "wrong max")
+	assert.Equal(t, map[string]interface{}{"content.name": "content.title"}, args["rename"], "wrong rename")
+	assert.Equal(t, []string{"content.updated"}, args["now"], "wrong current timestamp fields")
+
+	invalid := []string{
+		`@test.set "a" push in /docs`,
+		`@test.set "a" pop middle in /docs`,
+		`@test.set "a" *= "x" in /docs`,
+		`@test.set "a" append 1 in /docs`,
+		`@test.set "a"=1 "a" push 2 in /docs`,
+		`@test.set "a" rename "b" "b"=1 in /docs`,
+	}
+	for _, s := range invalid {
+		_, err = p.Parse(s)
+		assert.NotNil(t, err, fmt.Sprintf("'%s' parsed", s))
+	}
+}
+
+func TestWhereConditions(t *testing.T) {
+	p := NewParser()
+	p.registry.NewDatabaseItem("select", "", p.parseSelectCmd)
+
+	s := `@test.select "name" in /users
+	where "age" >= 18 "country" in ["ghana","uk"]
+	regex("name","i") == "^j" or exists("email") == false or typeof("age") != "int"`
+	cmdlist, err := p.Parse(s)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	assert.Len(t, cmdlist, 1, "wrong number of commands parsed")
+
+	expected := bytengine.And{Conditions: []bytengine.Condition{
+		bytengine.Comparison{Field: "content.age", Op: bytengine.OpGreaterEqual, Value: float64(18)},
+		bytengine.In{Field: "content.country", Values: []interface{}{"ghana", "uk"}},
+		bytengine.Or{Conditions: []bytengine.Condition{
+			bytengine.Regex{Field: "content.name", Pattern: "^j", Options: "i"},
+			bytengine.Exists{Field: "content.email", Exists: false},
+			bytengine.TypeOf{Field: "content.age", Type: bytengine.TypeInt, Negate: true},
+		}},
+	}}
+	assert.Equal(t, expected, cmdlist[0].Args["where"], "wrong where condition")
+
+	s = `@test.select "name" in /users where file_size < 1024`
+	cmdlist, err = p.Parse(s)
+	assert.Nil(t, err, fmt.Sprintf("parsing failed: %s", err))
+	expected2 := bytengine.Comparison{Field: "__bytes__.size", Op: bytengine.OpLesser, Value: float64(1024)}
+	assert.Equal(t, expected2, cmdlist[0].Args["where"], "wrong where condition")
 }
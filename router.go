@@ -4,11 +4,29 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
 type CommandHandler func(cmd Command, user *User, eng *Engine) (interface{}, error)
 type DataFilter func(r interface{}, eng *Engine) (interface{}, error)
 
+// ContentInfo describes the json or byte layer of a file served by the
+// readbytes and directaccess commands. Size is -1 for json content.
+type ContentInfo struct {
+	Size     int64
+	Mime     string
+	Hash     string
+	Modified time.Time
+	Filename string // original upload name of attachments
+}
+
+// ServeFunc is passed by the http server in the 'serve' argument of the
+// readbytes and directaccess commands. It is called once the file is found
+// and before its content is written. It returns the part of the content to
+// write (a length of -1 writes up to the end) or false if no content should
+// be written, e.g. when the client's cached copy is still valid.
+type ServeFunc func(c ContentInfo) (offset, length int64, ok bool)
+
 var cmdHandlerRegistry = make(map[string]CommandHandler)
 var dataFilterRegistry = make(map[string]DataFilter)
 
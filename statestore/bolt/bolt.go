@@ -0,0 +1,241 @@
+// Package bolt provides a state store plugin that persists authentication
+// tokens and cache entries in a single file using the embedded bbolt
+// key/value store so that sessions and upload tickets survive restarts.
+// Entries are stored with their expiry time and expired entries are removed
+// in the background.
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	bolt "go.etcd.io/bbolt"
+)
+
+type Config struct {
+	Path          string        `json:"path"`
+	Timeout       time.Duration `json:"timeout"`
+	PurgeInterval time.Duration `json:"purgeinterval"`
+}
+
+const (
+	// default interval in seconds between removals of expired entries
+	DefaultPurgeInterval = 60
+)
+
+var (
+	tokenBucket = []byte("token")
+	cacheBucket = []byte("cache")
+)
+
+type StateStore struct {
+	mu   sync.Mutex
+	path string
+	stop chan struct{}
+	now  func() time.Time
+	DB   *bolt.DB
+}
+
+func NewStateStore() *StateStore {
+	return &StateStore{now: time.Now}
+}
+
+// encode prefixes the value with its expiry time in unix nanoseconds
+func encode(value string, expires time.Time) []byte {
+	b := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(b, uint64(expires.UnixNano()))
+	copy(b[8:], value)
+	return b
+}
+
+func decode(b []byte) (value string, expires time.Time) {
+	if len(b) < 8 {
+		return "", time.Time{}
+	}
+	expires = time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	return string(b[8:]), expires
+}
+
+func (s *StateStore) set(bucket []byte, key, value string, timeout int64) error {
+	if timeout <= 0 {
+		return errors.New("invalid expire time")
+	}
+	expires := s.now().Add(time.Duration(timeout) * time.Second)
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), encode(value, expires))
+	})
+}
+
+func (s *StateStore) get(bucket []byte, key string) (string, error) {
+	var value string
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket).Get([]byte(key))
+		if b == nil {
+			return fmt.Errorf("key '%s:%s' not found", bucket, key)
+		}
+		val, expires := decode(b)
+		if !s.now().Before(expires) {
+			return fmt.Errorf("key '%s:%s' not found", bucket, key)
+		}
+		value = val
+		return nil
+	})
+	return value, err
+}
+
+// purge removes all expired entries
+func (s *StateStore) purge(db *bolt.DB) error {
+	now := s.now()
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{tokenBucket, cacheBucket} {
+			b := tx.Bucket(name)
+			expired := [][]byte{}
+			err := b.ForEach(func(k, v []byte) error {
+				_, expires := decode(v)
+				if !now.Before(expires) {
+					expired = append(expired, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				err = b.Delete(k)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *StateStore) purgeLoop(db *bolt.DB, interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := s.purge(db)
+			if err != nil {
+				log.Println("State Store purge failed:", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *StateStore) TokenSet(token, user string, timeout int64) error {
+	return s.set(tokenBucket, token, user, timeout)
+}
+
+func (s *StateStore) TokenGet(token string) (string, error) {
+	return s.get(tokenBucket, token)
+}
+
+func (s *StateStore) CacheSet(id, value string, timeout int64) error {
+	return s.set(cacheBucket, id, value, timeout)
+}
+
+func (s *StateStore) CacheGet(id string) (string, error) {
+	return s.get(cacheBucket, id)
+}
+
+func (s *StateStore) ClearAll() error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{tokenBucket, cacheBucket} {
+			err := tx.DeleteBucket(name)
+			if err != nil {
+				return err
+			}
+			_, err = tx.CreateBucket(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close stops the purge loop and closes the database file
+func (s *StateStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.DB == nil {
+		return nil
+	}
+	close(s.stop)
+	err := s.DB.Close()
+	s.DB = nil
+	return err
+}
+
+func (s *StateStore) Start(config string) error {
+	var c Config
+	err := json.Unmarshal([]byte(config), &c)
+	if err != nil {
+		return err
+	}
+	if len(c.Path) == 0 {
+		return errors.New("bolt: database file path required")
+	}
+	if c.PurgeInterval <= 0 {
+		c.PurgeInterval = DefaultPurgeInterval
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the plugin is started by every worker so the open database is reused
+	if s.DB != nil {
+		if s.path == c.Path {
+			return nil
+		}
+		close(s.stop)
+		s.DB.Close()
+		s.DB = nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.Path), 0755)
+	if err != nil {
+		return err
+	}
+	opts := &bolt.Options{Timeout: c.Timeout * time.Second}
+	db, err := bolt.Open(c.Path, 0600, opts)
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{tokenBucket, cacheBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	s.DB = db
+	s.path = c.Path
+	s.stop = make(chan struct{})
+	go s.purgeLoop(db, c.PurgeInterval*time.Second, s.stop)
+	return nil
+}
+
+func init() {
+	bytengine.RegisterStateStore("bolt", NewStateStore())
+}
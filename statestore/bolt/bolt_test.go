@@ -0,0 +1,97 @@
+package bolt
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/statestore/statestoretest"
+)
+
+func TestConformance(t *testing.T) {
+	statestoretest.Run(t, func(t *testing.T) bytengine.StateStore {
+		sts, err := bytengine.NewStateStore(
+			"bolt",
+			`{
+                "path":"/tmp/bytengine_bolt_test/sts.db",
+                "timeout":5
+            }`,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sts
+	})
+}
+func TestPersistence(t *testing.T) {
+	config := `{"path":"/tmp/bytengine_bolt_test/sts_persist.db"}`
+	os.Remove("/tmp/bytengine_bolt_test/sts_persist.db")
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	sts1 := NewStateStore()
+	sts1.now = clock
+	err := sts1.Start(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sts1.TokenSet("token1", "user1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sts1.CacheSet("ticket1", "upload", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sts1.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// restart and check entries kept their expiry time
+	sts2 := NewStateStore()
+	sts2.now = clock
+	err = sts2.Start(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sts2.Close()
+
+	val, err := sts2.TokenGet("token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "user1" {
+		t.Fatal("Token value mismatch")
+	}
+
+	now = now.Add(10 * time.Second)
+	_, err = sts2.TokenGet("token1")
+	if err == nil {
+		t.Fatal("Token not expired")
+	}
+	val, err = sts2.CacheGet("ticket1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val != "upload" {
+		t.Fatal("Cache value mismatch")
+	}
+
+	// purge removes the expired token only
+	err = sts2.purge(sts2.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(-10 * time.Second)
+	_, err = sts2.TokenGet("token1")
+	if err == nil {
+		t.Fatal("Expired token not purged")
+	}
+	_, err = sts2.CacheGet("ticket1")
+	if err != nil {
+		t.Fatal("Cache entry purged before expiry")
+	}
+}
@@ -0,0 +1,133 @@
+// Package memory provides a state store plugin that keeps authentication
+// tokens and cache entries in memory. Entries expire like keys set with the
+// Redis SETEX command. All entries are lost when the process exits.
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+)
+
+type Config struct {
+	PurgeInterval time.Duration `json:"purgeinterval"`
+}
+
+const (
+	TokenPrefix = "token"
+	CachePrefix = "cache"
+
+	// default interval in seconds between removals of expired entries
+	DefaultPurgeInterval = 60
+)
+
+type item struct {
+	value   string
+	expires time.Time
+}
+
+type StateStore struct {
+	mu    sync.RWMutex
+	items map[string]item
+	once  sync.Once
+	now   func() time.Time
+}
+
+func NewStateStore() *StateStore {
+	return &StateStore{
+		items: map[string]item{},
+		now:   time.Now,
+	}
+}
+
+func (s *StateStore) set(key, value string, timeout int64) error {
+	if timeout <= 0 {
+		return errors.New("invalid expire time")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = item{
+		value:   value,
+		expires: s.now().Add(time.Duration(timeout) * time.Second),
+	}
+	return nil
+}
+
+func (s *StateStore) get(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.items[key]
+	if !ok || !s.now().Before(i.expires) {
+		return "", fmt.Errorf("key '%s' not found", key)
+	}
+	return i.value, nil
+}
+
+// purge removes all expired entries
+func (s *StateStore) purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, i := range s.items {
+		if !now.Before(i.expires) {
+			delete(s.items, key)
+		}
+	}
+}
+
+func (s *StateStore) TokenSet(token, user string, timeout int64) error {
+	key := fmt.Sprintf("%s:%s", TokenPrefix, token)
+	return s.set(key, user, timeout)
+}
+
+func (s *StateStore) TokenGet(token string) (string, error) {
+	key := fmt.Sprintf("%s:%s", TokenPrefix, token)
+	return s.get(key)
+}
+
+func (s *StateStore) CacheSet(id, value string, timeout int64) error {
+	key := fmt.Sprintf("%s:%s", CachePrefix, id)
+	return s.set(key, value, timeout)
+}
+
+func (s *StateStore) CacheGet(id string) (string, error) {
+	key := fmt.Sprintf("%s:%s", CachePrefix, id)
+	return s.get(key)
+}
+
+func (s *StateStore) ClearAll() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = map[string]item{}
+	return nil
+}
+
+func (s *StateStore) Start(config string) error {
+	var c Config
+	err := json.Unmarshal([]byte(config), &c)
+	if err != nil {
+		return err
+	}
+	if c.PurgeInterval <= 0 {
+		c.PurgeInterval = DefaultPurgeInterval
+	}
+
+	// the plugin is started by every worker so only one purge loop is run
+	s.once.Do(func() {
+		go func() {
+			for range time.Tick(c.PurgeInterval * time.Second) {
+				s.purge()
+			}
+		}()
+	})
+	return nil
+}
+
+func init() {
+	bytengine.RegisterStateStore("memory", NewStateStore())
+}
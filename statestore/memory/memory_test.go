@@ -0,0 +1,103 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/statestore/statestoretest"
+)
+
+func TestConformance(t *testing.T) {
+	statestoretest.Run(t, func(t *testing.T) bytengine.StateStore {
+		sts, err := bytengine.NewStateStore("memory", `{}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sts
+	})
+}
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	sts := NewStateStore()
+	sts.now = func() time.Time { return now }
+
+	err := sts.TokenSet("token1", "user1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sts.CacheSet("1", "cacheitem1", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(9 * time.Second)
+	if _, err = sts.TokenGet("token1"); err != nil {
+		t.Fatal("Token expired early")
+	}
+
+	now = now.Add(1 * time.Second)
+	if _, err = sts.TokenGet("token1"); err == nil {
+		t.Fatal("Token not expired")
+	}
+	if _, err = sts.CacheGet("1"); err != nil {
+		t.Fatal("Cache entry expired early")
+	}
+
+	// setting a key again resets its timeout
+	err = sts.CacheSet("1", "cacheitem2", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(15 * time.Second)
+	val, err := sts.CacheGet("1")
+	if err != nil {
+		t.Fatal("Cache entry timeout not reset")
+	}
+	if val != "cacheitem2" {
+		t.Fatal("Cache value mismatch")
+	}
+
+	// purge only removes expired entries
+	sts.purge()
+	if len(sts.items) != 1 {
+		t.Fatalf("Expected 1 entry after purge, found %d", len(sts.items))
+	}
+	now = now.Add(5 * time.Second)
+	sts.purge()
+	if len(sts.items) != 0 {
+		t.Fatalf("Expected 0 entries after purge, found %d", len(sts.items))
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	sts := NewStateStore()
+	err := sts.Start(`{"purgeinterval":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d-%d", worker, j)
+				if err := sts.CacheSet(key, key, 10); err != nil {
+					t.Error(err)
+					return
+				}
+				val, err := sts.CacheGet(key)
+				if err != nil || val != key {
+					t.Errorf("Cache value mismatch for %s", key)
+					return
+				}
+				sts.purge()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -2,6 +2,7 @@ package redis
 
 import (
 	"github.com/johnwilson/bytengine"
+	"github.com/johnwilson/bytengine/statestore/statestoretest"
 	"testing"
 )
 
@@ -56,3 +57,21 @@ func TestStateStore(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	statestoretest.Run(t, func(t *testing.T) bytengine.StateStore {
+		sts, err := bytengine.NewStateStore(
+			"redis",
+			`{
+                "address":"localhost:6379",
+                "database":1,
+                "password":"",
+                "timeout":60
+            }`,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sts
+	})
+}
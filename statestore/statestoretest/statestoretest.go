@@ -0,0 +1,126 @@
+// Package statestoretest provides a conformance test suite for
+// bytengine.StateStore plugins.
+//
+// Plugin packages run it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		statestoretest.Run(t, func(t *testing.T) bytengine.StateStore {
+//			...
+//		})
+//	}
+//
+// Every sub test removes all existing entries with ClearAll.
+package statestoretest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnwilson/bytengine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory returns a started state store plugin
+type Factory func(t *testing.T) bytengine.StateStore
+
+// Run runs all conformance tests against the plugin created by newSTS
+func Run(t *testing.T, newSTS Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, sts bytengine.StateStore)
+	}{
+		{"Tokens", testTokens},
+		{"Cache", testCache},
+		{"ClearAll", testClearAll},
+		{"Expiry", testExpiry},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sts := newSTS(t)
+			require.Nil(t, sts.ClearAll(), "clear all failed")
+			tc.fn(t, sts)
+		})
+	}
+}
+
+func testTokens(t *testing.T, sts bytengine.StateStore) {
+	assert.Nil(t, sts.TokenSet("token1", "user1", 10), "token not set")
+	val, err := sts.TokenGet("token1")
+	assert.Nil(t, err, "token not found")
+	assert.Equal(t, "user1", val, "token value mismatch")
+
+	// overwrite
+	assert.Nil(t, sts.TokenSet("token1", "user2", 10), "token not set")
+	val, err = sts.TokenGet("token1")
+	assert.Nil(t, err, "token not found")
+	assert.Equal(t, "user2", val, "token value not updated")
+
+	_, err = sts.TokenGet("token2")
+	assert.NotNil(t, err, "missing token found")
+}
+
+func testCache(t *testing.T, sts bytengine.StateStore) {
+	assert.Nil(t, sts.CacheSet("1", "cacheitem1", 10), "cache item not set")
+	val, err := sts.CacheGet("1")
+	assert.Nil(t, err, "cache item not found")
+	assert.Equal(t, "cacheitem1", val, "cache value mismatch")
+
+	_, err = sts.CacheGet("2")
+	assert.NotNil(t, err, "missing cache item found")
+
+	// tokens and cache items don't share keys
+	_, err = sts.TokenGet("1")
+	assert.NotNil(t, err, "cache item found as token")
+	assert.Nil(t, sts.TokenSet("1", "user1", 10), "token not set")
+	val, err = sts.CacheGet("1")
+	assert.Nil(t, err, "cache item not found")
+	assert.Equal(t, "cacheitem1", val, "cache item overwritten by token")
+}
+
+func testClearAll(t *testing.T, sts bytengine.StateStore) {
+	assert.Nil(t, sts.TokenSet("token1", "user1", 10), "token not set")
+	assert.Nil(t, sts.CacheSet("1", "cacheitem1", 10), "cache item not set")
+
+	assert.Nil(t, sts.ClearAll(), "clear all failed")
+	_, err := sts.TokenGet("token1")
+	assert.NotNil(t, err, "token not cleared")
+	_, err = sts.CacheGet("1")
+	assert.NotNil(t, err, "cache item not cleared")
+}
+
+func testExpiry(t *testing.T, sts bytengine.StateStore) {
+	assert.Nil(t, sts.TokenSet("token1", "user1", 1), "token not set")
+	assert.Nil(t, sts.CacheSet("1", "cacheitem1", 1), "cache item not set")
+	assert.Nil(t, sts.TokenSet("token2", "user2", 60), "token not set")
+
+	time.Sleep(2100 * time.Millisecond)
+	_, err := sts.TokenGet("token1")
+	assert.NotNil(t, err, "token didn't expire")
+	_, err = sts.CacheGet("1")
+	assert.NotNil(t, err, "cache item didn't expire")
+	val, err := sts.TokenGet("token2")
+	assert.Nil(t, err, "token expired too early")
+	assert.Equal(t, "user2", val, "token value mismatch")
+}
+
+func testConcurrency(t *testing.T, sts bytengine.StateStore) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				key := fmt.Sprintf("token%d_%d", i, j)
+				assert.Nil(t, sts.TokenSet(key, key, 10), "token not set")
+				val, err := sts.TokenGet(key)
+				assert.Nil(t, err, "token not found")
+				assert.Equal(t, key, val, "token value mismatch")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
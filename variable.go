@@ -0,0 +1,236 @@
+package bytengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Variable refers to the result of an earlier script command assigned with
+// 'let'. Fields select a value inside the result: object keys or list
+// indexes (e.g. '$user.emails.0').
+type Variable struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+func (v Variable) String() string {
+	return "$" + strings.Join(append([]string{v.Name}, v.Fields...), ".")
+}
+
+// Template is a string made of text and variables such as the path
+// '/users/user_$id'. Parts are strings or Variables.
+type Template []interface{}
+
+// TemplateList is a list of strings and Templates which resolves to a
+// []string, e.g. the directories of a select command
+type TemplateList []interface{}
+
+// copyJson makes a deep copy of a normalized json value
+func copyJson(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			m[k] = copyJson(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, item := range val {
+			l[i] = copyJson(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// variableValue converts a command result to the json value kept in a
+// script variable. Integers are kept as int64 so that they are stored with
+// the same type when used in later commands.
+func variableValue(r interface{}) (interface{}, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var val interface{}
+	err = dec.Decode(&val)
+	if err != nil {
+		return nil, err
+	}
+	return convertNumbers(val), nil
+}
+
+// convertNumbers replaces the json.Numbers of a decoded json value by int64
+// or float64 values
+func convertNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = convertNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = convertNumbers(item)
+		}
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	}
+	return v
+}
+
+func (v Variable) resolve(vars map[string]interface{}) (interface{}, error) {
+	val, ok := vars[v.Name]
+	if !ok {
+		return nil, fmt.Errorf("variable '$%s' isn't defined", v.Name)
+	}
+	for _, field := range v.Fields {
+		switch item := val.(type) {
+		case map[string]interface{}:
+			val, ok = item[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+			ok = err == nil && i >= 0 && i < len(item)
+			if ok {
+				val = item[i]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("'%s' not found in variable '$%s'", field, v.Name)
+		}
+	}
+	return copyJson(val), nil
+}
+
+func (t Template) resolve(vars map[string]interface{}) (string, error) {
+	s := ""
+	for _, part := range t {
+		v, ok := part.(Variable)
+		if !ok {
+			s += fmt.Sprint(part)
+			continue
+		}
+		val, err := v.resolve(vars)
+		if err != nil {
+			return "", err
+		}
+		switch item := val.(type) {
+		case string:
+			s += item
+		case int64:
+			s += strconv.FormatInt(item, 10)
+		case float64:
+			s += strconv.FormatFloat(item, 'f', -1, 64)
+		case bool:
+			s += strconv.FormatBool(item)
+		default:
+			return "", fmt.Errorf("variable '%s' can't be used in text", v)
+		}
+	}
+	return s, nil
+}
+
+// resolveValue replaces the variables in a command argument by their
+// values
+func resolveValue(v interface{}, vars map[string]interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case Variable:
+		return val.resolve(vars)
+	case Template:
+		return val.resolve(vars)
+	case TemplateList:
+		list := []string{}
+		for _, item := range val {
+			s, err := resolveValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			str, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid list item '%v'", s)
+			}
+			list = append(list, str)
+		}
+		return list, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			r, err := resolveValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = r
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, item := range val {
+			r, err := resolveValue(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = r
+		}
+		return l, nil
+	case Comparison:
+		r, err := resolveValue(val.Value, vars)
+		if err != nil {
+			return nil, err
+		}
+		val.Value = r
+		return val, nil
+	case In:
+		r, err := resolveValue(val.Values, vars)
+		if err != nil {
+			return nil, err
+		}
+		val.Values = r.([]interface{})
+		return val, nil
+	case And:
+		list, err := resolveConditions(val.Conditions, vars)
+		return And{Conditions: list}, err
+	case Or:
+		list, err := resolveConditions(val.Conditions, vars)
+		return Or{Conditions: list}, err
+	default:
+		return v, nil
+	}
+}
+
+func resolveConditions(list []Condition, vars map[string]interface{}) ([]Condition, error) {
+	resolved := []Condition{}
+	for _, c := range list {
+		r, err := resolveValue(c, vars)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, r.(Condition))
+	}
+	return resolved, nil
+}
+
+// resolveCommand returns the command with the variables in its arguments
+// replaced by their values
+func resolveCommand(cmd Command, vars map[string]interface{}) (Command, error) {
+	args := make(map[string]interface{}, len(cmd.Args))
+	for k, v := range cmd.Args {
+		r, err := resolveValue(v, vars)
+		if err != nil {
+			return cmd, err
+		}
+		args[k] = r
+	}
+	cmd.Args = args
+	return cmd, nil
+}
@@ -0,0 +1,32 @@
+package bytengine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVariableValue(t *testing.T) {
+	result := map[string]interface{}{
+		"count":  int64(3),
+		"ratio":  0.5,
+		"big":    int64(1) << 60,
+		"values": []int{1, 2},
+		"user":   struct{ Age int }{42},
+	}
+	val, err := variableValue(result)
+	assert.Nil(t, err, "result not converted")
+	expected := map[string]interface{}{
+		"count":  int64(3),
+		"ratio":  0.5,
+		"big":    int64(1) << 60,
+		"values": []interface{}{int64(1), int64(2)},
+		"user":   map[string]interface{}{"Age": int64(42)},
+	}
+	assert.Equal(t, expected, val, "wrong variable value")
+
+	vars := map[string]interface{}{"v": val}
+	s, err := Template{"/users/user_", Variable{Name: "v", Fields: []string{"count"}}}.resolve(vars)
+	assert.Nil(t, err, "template not resolved")
+	assert.Equal(t, "/users/user_3", s, "wrong template value")
+}